@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+	"gopkg.in/yaml.v3"
+)
+
+// The configuration of the daemon, read from a YAML or JSON file
+type config struct {
+	// Listen defines the address the push endpoints and the health
+	// endpoints are served on
+	Listen string `yaml:"listen"`
+
+	// ShutdownTimeout defines how long the receivers are given to stop
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	Log       logConfig        `yaml:"log"`
+	Sinks     []sinkConfig     `yaml:"sinks"`
+	Receivers []receiverConfig `yaml:"receivers"`
+}
+
+type logConfig struct {
+	// Level defines the minimum level logged: debug, info, warn or error
+	Level string `yaml:"level"`
+
+	// Format defines the log format, json or text
+	Format string `yaml:"format"`
+}
+
+// The configuration of a sink, the fields used depend on its type
+type sinkConfig struct {
+	Name string `yaml:"name"`
+
+	// Type defines the kind of sink: stdout, file, webhook or exec
+	Type string `yaml:"type"`
+
+	// file
+	Path       string `yaml:"path"`
+	MaxSizeMB  int64  `yaml:"max_size_mb"`
+	MaxBackups int    `yaml:"max_backups"`
+	Sync       bool   `yaml:"sync"`
+
+	// webhook
+	Url            string            `yaml:"url"`
+	Headers        map[string]string `yaml:"headers"`
+	Secret         string            `yaml:"secret"`
+	MaxRetries     int               `yaml:"max_retries"`
+	InitialBackoff time.Duration     `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration     `yaml:"max_backoff"`
+	FailureLogPath string            `yaml:"failure_log_path"`
+
+	// exec
+	Command []string `yaml:"command"`
+	Env     []string `yaml:"env"`
+
+	// webhook and exec
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// The configuration of a receiver
+type receiverConfig struct {
+	Name           string `yaml:"name"`
+	TransmitterUrl string `yaml:"transmitter_url"`
+
+	// Token and TokenFile define the bearer token used with the
+	// transmitter, TokenFile is read at startup
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"token_file"`
+
+	// Mode defines how events are received, poll or push
+	Mode string `yaml:"mode"`
+
+	// Events defines the requested events, as URIs or names such as
+	// session-revoked. Defaults to all the CAEP events
+	Events []string `yaml:"events"`
+
+	// poll
+	PollUrl      string        `yaml:"poll_url"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+	LongPoll     bool          `yaml:"long_poll"`
+	MaxEvents    int           `yaml:"max_events"`
+
+	// push
+	PushUrl                 string `yaml:"push_url"`
+	PushPath                string `yaml:"push_path"`
+	PushAuthorizationHeader string `yaml:"push_authorization_header"`
+
+	VerifySignatures bool   `yaml:"verify_signatures"`
+	Audience         string `yaml:"audience"`
+	StateFile        string `yaml:"state_file"`
+	Workers          int    `yaml:"workers"`
+
+	// DeadLetterDir defines the directory events are dead-lettered to
+	// once sending them to the sinks failed MaxAttempts times
+	DeadLetterDir string `yaml:"dead_letter_dir"`
+	MaxAttempts   int    `yaml:"max_attempts"`
+
+	// Sinks defines the names of the sinks events are sent to. Defaults
+	// to all the sinks
+	Sinks []string `yaml:"sinks"`
+}
+
+// The CAEP events requested when a receiver doesn't list its events
+var defaultEvents = []events.EventType{
+	events.SessionRevoked,
+	events.CredentialChange,
+	events.DeviceCompliance,
+	events.AssuranceLevelChange,
+	events.TokenClaimsChange,
+}
+
+// Reads the configuration file. ${VAR} references in the file are replaced
+// with the value of the environment variable, so secrets don't have to be
+// written in the file
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// YAML is a superset of JSON, so both are decoded by the YAML decoder
+	decoder := yaml.NewDecoder(bytes.NewReader(expandEnv(data)))
+	decoder.KnownFields(true)
+	cfg := &config{}
+	err = decoder.Decode(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	err = cfg.validate()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Matches the ${VAR} references of the configuration file. $VAR isn't
+// expanded, so commands of exec sinks can use their own variables
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+func expandEnv(data []byte) []byte {
+	return envReference.ReplaceAllFunc(data, func(reference []byte) []byte {
+		return []byte(os.Getenv(string(reference[2 : len(reference)-1])))
+	})
+}
+
+// Checks the configuration and fills in the defaults
+func (cfg *config) validate() error {
+	if cfg.Listen == "" {
+		cfg.Listen = ":8080"
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = 30 * time.Second
+	}
+	if len(cfg.Sinks) == 0 {
+		cfg.Sinks = []sinkConfig{{Name: "stdout", Type: "stdout"}}
+	}
+	if len(cfg.Receivers) == 0 {
+		return errors.New("no receivers are configured")
+	}
+
+	sinkNames := map[string]bool{}
+	for i := range cfg.Sinks {
+		sink := &cfg.Sinks[i]
+		if sink.Name == "" {
+			sink.Name = sink.Type
+		}
+		if sinkNames[sink.Name] {
+			return fmt.Errorf("duplicate sink name %q", sink.Name)
+		}
+		sinkNames[sink.Name] = true
+
+		switch sink.Type {
+		case "stdout":
+		case "file":
+			if sink.Path == "" {
+				return fmt.Errorf("sink %s: path is required", sink.Name)
+			}
+		case "webhook":
+			if sink.Url == "" {
+				return fmt.Errorf("sink %s: url is required", sink.Name)
+			}
+		case "exec":
+			if len(sink.Command) == 0 {
+				return fmt.Errorf("sink %s: command is required", sink.Name)
+			}
+		default:
+			return fmt.Errorf("sink %s: unknown type %q, must be stdout, file, webhook or exec", sink.Name, sink.Type)
+		}
+	}
+
+	receiverNames := map[string]bool{}
+	pushPaths := map[string]bool{"/healthz": true, "/readyz": true}
+	for i := range cfg.Receivers {
+		receiver := &cfg.Receivers[i]
+		if receiver.Name == "" {
+			return fmt.Errorf("receiver %d: name is required", i+1)
+		}
+		if receiverNames[receiver.Name] {
+			return fmt.Errorf("duplicate receiver name %q", receiver.Name)
+		}
+		receiverNames[receiver.Name] = true
+
+		if receiver.TransmitterUrl == "" {
+			return fmt.Errorf("receiver %s: transmitter_url is required", receiver.Name)
+		}
+		if receiver.Token == "" && receiver.TokenFile == "" {
+			return fmt.Errorf("receiver %s: token or token_file is required", receiver.Name)
+		}
+		for _, name := range receiver.Sinks {
+			if !sinkNames[name] {
+				return fmt.Errorf("receiver %s: unknown sink %q", receiver.Name, name)
+			}
+		}
+		if _, err := receiver.eventTypes(); err != nil {
+			return fmt.Errorf("receiver %s: %w", receiver.Name, err)
+		}
+
+		switch receiver.Mode {
+		case "", "poll":
+			receiver.Mode = "poll"
+			if receiver.PollUrl == "" {
+				return fmt.Errorf("receiver %s: poll_url is required in poll mode", receiver.Name)
+			}
+			// The receiver polls at a whole number of seconds
+			if receiver.PollInterval < 0 || receiver.PollInterval%time.Second != 0 {
+				return fmt.Errorf("receiver %s: poll_interval %s must be a whole number of seconds", receiver.Name, receiver.PollInterval)
+			}
+		case "push":
+			if receiver.PushUrl == "" {
+				return fmt.Errorf("receiver %s: push_url is required in push mode", receiver.Name)
+			}
+			if receiver.PushPath == "" {
+				pushUrl, err := url.Parse(receiver.PushUrl)
+				if err != nil {
+					return fmt.Errorf("receiver %s: invalid push_url: %w", receiver.Name, err)
+				}
+				receiver.PushPath = pushUrl.Path
+			}
+			if !strings.HasPrefix(receiver.PushPath, "/") {
+				return fmt.Errorf("receiver %s: push_path must start with /", receiver.Name)
+			}
+			if pushPaths[receiver.PushPath] {
+				return fmt.Errorf("receiver %s: push_path %s is already served", receiver.Name, receiver.PushPath)
+			}
+			pushPaths[receiver.PushPath] = true
+		default:
+			return fmt.Errorf("receiver %s: unknown mode %q, must be poll or push", receiver.Name, receiver.Mode)
+		}
+	}
+	return nil
+}
+
+// Returns the bearer token of the receiver
+func (receiver *receiverConfig) authorizationToken() (string, error) {
+	if receiver.Token != "" {
+		return receiver.Token, nil
+	}
+	data, err := os.ReadFile(receiver.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Returns the requested event types, resolving the event names
+func (receiver *receiverConfig) eventTypes() ([]events.EventType, error) {
+	if len(receiver.Events) == 0 {
+		return defaultEvents, nil
+	}
+
+	var eventTypes []events.EventType
+	for _, name := range receiver.Events {
+		eventType, found := events.EventEnum[name]
+		if !found {
+			for uri, candidate := range events.EventEnum {
+				if strings.HasSuffix(uri, "/"+name) {
+					eventType, found = candidate, true
+					break
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown event %q", name)
+		}
+		eventTypes = append(eventTypes, eventType)
+	}
+	return eventTypes, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a valid configuration with a poll receiver and a push receiver
+func testConfig() *config {
+	return &config{
+		Sinks: []sinkConfig{{Type: "stdout"}, {Name: "audit", Type: "file", Path: "/tmp/audit.log"}},
+		Receivers: []receiverConfig{
+			{Name: "polled", TransmitterUrl: "https://tr.example.com", Token: "token", PollUrl: "https://tr.example.com/poll", PollInterval: time.Minute},
+			{Name: "pushed", TransmitterUrl: "https://tr.example.com", Token: "token", Mode: "push", PushUrl: "https://receiver.example.com/ssf/pushed"},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *config)
+		wantErr string
+		check   func(t *testing.T, cfg *config)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg *config) {
+				if cfg.Listen != ":8080" || cfg.ShutdownTimeout != 30*time.Second || cfg.Sinks[0].Name != "stdout" || cfg.Receivers[0].Mode != "poll" {
+					t.Fatalf("validate() = %+v, want the defaults filled in", cfg)
+				}
+			},
+		},
+		{
+			name:    "no receivers",
+			modify:  func(cfg *config) { cfg.Receivers = nil },
+			wantErr: "no receivers are configured",
+		},
+		{
+			name:    "duplicate sink",
+			modify:  func(cfg *config) { cfg.Sinks = append(cfg.Sinks, sinkConfig{Type: "stdout"}) },
+			wantErr: `duplicate sink name "stdout"`,
+		},
+		{
+			name:    "unknown sink type",
+			modify:  func(cfg *config) { cfg.Sinks[1].Type = "kafka" },
+			wantErr: `unknown type "kafka"`,
+		},
+		{
+			name:    "file sink without path",
+			modify:  func(cfg *config) { cfg.Sinks[1].Path = "" },
+			wantErr: "sink audit: path is required",
+		},
+		{
+			name:    "receiver with an unknown sink",
+			modify:  func(cfg *config) { cfg.Receivers[0].Sinks = []string{"audit", "missing"} },
+			wantErr: `receiver polled: unknown sink "missing"`,
+		},
+		{
+			name:   "receiver with known sinks",
+			modify: func(cfg *config) { cfg.Receivers[0].Sinks = []string{"audit", "stdout"} },
+		},
+		{
+			name:    "duplicate receiver",
+			modify:  func(cfg *config) { cfg.Receivers[1].Name = "polled" },
+			wantErr: `duplicate receiver name "polled"`,
+		},
+		{
+			name:    "missing token",
+			modify:  func(cfg *config) { cfg.Receivers[0].Token = "" },
+			wantErr: "token or token_file is required",
+		},
+		{
+			name:    "unknown event",
+			modify:  func(cfg *config) { cfg.Receivers[0].Events = []string{"session-revoked", "user-deleted"} },
+			wantErr: `unknown event "user-deleted"`,
+		},
+		{
+			name:    "poll without poll_url",
+			modify:  func(cfg *config) { cfg.Receivers[0].PollUrl = "" },
+			wantErr: "poll_url is required",
+		},
+		{
+			name:    "sub-second poll interval",
+			modify:  func(cfg *config) { cfg.Receivers[0].PollInterval = 500 * time.Millisecond },
+			wantErr: "poll_interval 500ms must be a whole number of seconds",
+		},
+		{
+			name:    "fractional poll interval",
+			modify:  func(cfg *config) { cfg.Receivers[0].PollInterval = 1500 * time.Millisecond },
+			wantErr: "must be a whole number of seconds",
+		},
+		{
+			name:    "negative poll interval",
+			modify:  func(cfg *config) { cfg.Receivers[0].PollInterval = -time.Second },
+			wantErr: "must be a whole number of seconds",
+		},
+		{
+			name:   "default poll interval",
+			modify: func(cfg *config) { cfg.Receivers[0].PollInterval = 0 },
+		},
+		{
+			name:    "push without push_url",
+			modify:  func(cfg *config) { cfg.Receivers[1].PushUrl = "" },
+			wantErr: "push_url is required",
+		},
+		{
+			name: "push_path defaults to the push_url path",
+			check: func(t *testing.T, cfg *config) {
+				if got := cfg.Receivers[1].PushPath; got != "/ssf/pushed" {
+					t.Fatalf("push_path = %q, want /ssf/pushed", got)
+				}
+			},
+		},
+		{
+			name:   "push_path differing from push_url",
+			modify: func(cfg *config) { cfg.Receivers[1].PushPath = "/internal/pushed" },
+			check: func(t *testing.T, cfg *config) {
+				if got := cfg.Receivers[1].PushPath; got != "/internal/pushed" {
+					t.Fatalf("push_path = %q, want /internal/pushed", got)
+				}
+			},
+		},
+		{
+			name:    "relative push_path",
+			modify:  func(cfg *config) { cfg.Receivers[1].PushPath = "ssf/pushed" },
+			wantErr: "push_path must start with /",
+		},
+		{
+			name:    "push_url without a path",
+			modify:  func(cfg *config) { cfg.Receivers[1].PushUrl = "https://receiver.example.com" },
+			wantErr: "push_path must start with /",
+		},
+		{
+			name:    "push_path of the health endpoints",
+			modify:  func(cfg *config) { cfg.Receivers[1].PushPath = "/healthz" },
+			wantErr: "push_path /healthz is already served",
+		},
+		{
+			name: "duplicate push_path",
+			modify: func(cfg *config) {
+				cfg.Receivers = append(cfg.Receivers, receiverConfig{Name: "other", TransmitterUrl: "https://other.example.com", Token: "token", Mode: "push", PushUrl: "https://proxy.example.com/other", PushPath: "/ssf/pushed"})
+			},
+			wantErr: "receiver other: push_path /ssf/pushed is already served",
+		},
+		{
+			name:    "unknown mode",
+			modify:  func(cfg *config) { cfg.Receivers[0].Mode = "stream" },
+			wantErr: `unknown mode "stream"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig()
+			if test.modify != nil {
+				test.modify(cfg)
+			}
+			err := cfg.validate()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("validate() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validate() error = %v", err)
+			}
+			if test.check != nil {
+				test.check(t, cfg)
+			}
+		})
+	}
+}
+
+func TestLoadExampleConfig(t *testing.T) {
+	t.Setenv("CAEP_DEV_TOKEN", "caep-dev-token")
+	cfg, err := loadConfig("ssf-receiver.example.yaml")
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if len(cfg.Receivers) == 0 || cfg.Receivers[0].Token != "caep-dev-token" {
+		t.Fatalf("loadConfig() receivers = %+v, want the token expanded from the environment", cfg.Receivers)
+	}
+}
@@ -0,0 +1,266 @@
+// Command ssf-receiver runs one or more SSF receivers and forwards the
+// events they receive to sinks: standard output, rotating files, HTTP
+// webhooks and commands.
+//
+// Usage:
+//
+//	ssf-receiver --config ssf-receiver.yaml
+//
+// The configuration file is YAML or JSON. ${VAR} references are replaced
+// with environment variables:
+//
+//	listen: ":8080"            # push endpoints, /healthz and /readyz
+//	log:
+//	  level: info
+//	sinks:
+//	  - name: stdout
+//	    type: stdout
+//	  - name: audit
+//	    type: file
+//	    path: /var/log/ssf/events.ndjson
+//	receivers:
+//	  - name: caep-dev
+//	    transmitter_url: https://ssf.caep.dev
+//	    token: ${CAEP_DEV_TOKEN}
+//	    poll_url: https://ssf.caep.dev/ssf/streams/poll
+//	    events: [session-revoked, credential-change]
+//	    state_file: /var/lib/ssf/caep-dev.json
+//
+// Events are written to the sinks as JSON, in the shape documented by
+// sinks.Event. Logs are written to the standard error.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sgnl-ai/caep.dev-receiver/pkg"
+	"github.com/sgnl-ai/caep.dev-receiver/pkg/sinks"
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+func main() {
+	configPath := flag.String("config", "ssf-receiver.yaml", "path of the YAML or JSON configuration file")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ssf-receiver:", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger := newLogger(cfg.Log)
+	err = run(ctx, cfg, logger)
+	if err != nil {
+		logger.Error("ssf-receiver failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+}
+
+func newLogger(cfg logConfig) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.Format == "text" {
+		return slog.New(slog.NewTextHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+}
+
+// Runs the receivers until ctx is done, then shuts them down
+func run(ctx context.Context, cfg *config, logger *slog.Logger) error {
+	openSinks, err := newSinks(cfg.Sinks)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for name, sink := range openSinks {
+			if err := sink.Close(); err != nil {
+				logger.Warn("failed to close sink", slog.String("sink", name), slog.Any("error", err))
+			}
+		}
+	}()
+
+	// Every receiver sends its events to its own sinks
+	receiverSinks := map[string]sinks.Sink{}
+	receiverCfgs := map[string]pkg.ReceiverConfig{}
+	for _, receiverCfg := range cfg.Receivers {
+		receiverSinks[receiverCfg.Name] = sinks.Fanout(selectSinks(receiverCfg.Sinks, openSinks)...)
+		receiverCfgs[receiverCfg.Name], err = newReceiverConfig(receiverCfg, logger)
+		if err != nil {
+			return fmt.Errorf("receiver %s: %w", receiverCfg.Name, err)
+		}
+	}
+	manager, err := pkg.NewReceiverManager(pkg.ManagerConfig{
+		Handler: func(ctx context.Context, event events.SsfEvent) error {
+			info, _ := pkg.EventInfoFromContext(ctx)
+			sink, ok := receiverSinks[info.Receiver]
+			if !ok {
+				return fmt.Errorf("no sinks are configured for receiver %q", info.Receiver)
+			}
+			return sink.Send(ctx, sinks.NewEvent(info.Receiver, info, event))
+		},
+		Middleware: []pkg.Middleware{pkg.Recovery()},
+		Logger:     logger,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The server is started first, so push receivers can answer the
+	// verification events sent when their streams are created. The
+	// manager answers 503 until they are configured, so the transmitter
+	// retries
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", manager.HealthHandler())
+	mux.Handle("/readyz", manager.HealthHandler())
+	for _, receiverCfg := range cfg.Receivers {
+		if receiverCfg.Mode == "push" {
+			mux.Handle(receiverCfg.PushPath, manager.PushHandler())
+		}
+	}
+	server := &http.Server{Addr: cfg.Listen, Handler: mux}
+	serverErr := make(chan error, 1)
+	go func() {
+		err := server.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+	logger.Info("listening", slog.String("address", cfg.Listen))
+
+	shutdown := func() error {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		return errors.Join(manager.Shutdown(shutdownCtx), server.Shutdown(shutdownCtx))
+	}
+
+	err = manager.Start(receiverCfgs)
+	if err != nil {
+		return errors.Join(err, shutdown())
+	}
+
+	select {
+	case <-ctx.Done():
+		logger.Info("shutting down")
+		return shutdown()
+	case err := <-serverErr:
+		return errors.Join(err, shutdown())
+	}
+}
+
+// Opens the configured sinks, by name
+func newSinks(cfgs []sinkConfig) (map[string]sinks.Sink, error) {
+	opened := map[string]sinks.Sink{}
+	for _, cfg := range cfgs {
+		var sink sinks.Sink
+		var err error
+		switch cfg.Type {
+		case "stdout":
+			sink = sinks.Stdout()
+		case "file":
+			sink, err = sinks.NewFileSink(cfg.Path, sinks.FileOptions{
+				MaxSize:    cfg.MaxSizeMB << 20,
+				MaxBackups: cfg.MaxBackups,
+				Sync:       cfg.Sync,
+			})
+		case "webhook":
+			sink, err = sinks.NewWebhookSink(cfg.Url, sinks.WebhookOptions{
+				Headers: cfg.Headers,
+				Timeout: cfg.Timeout,
+				Secret:  []byte(cfg.Secret),
+				RetryPolicy: pkg.RetryPolicy{
+					MaxRetries:     cfg.MaxRetries,
+					InitialBackoff: cfg.InitialBackoff,
+					MaxBackoff:     cfg.MaxBackoff,
+				},
+				FailureLogPath: cfg.FailureLogPath,
+			})
+		case "exec":
+			sink, err = sinks.NewExecSink(cfg.Command, sinks.ExecOptions{Env: cfg.Env, Timeout: cfg.Timeout})
+		}
+		if err != nil {
+			for _, sink := range opened {
+				sink.Close()
+			}
+			return nil, fmt.Errorf("sink %s: %w", cfg.Name, err)
+		}
+		opened[cfg.Name] = sink
+	}
+	return opened, nil
+}
+
+// Returns the named sinks, or all of them when no names are given
+func selectSinks(names []string, openSinks map[string]sinks.Sink) []sinks.Sink {
+	var selected []sinks.Sink
+	if len(names) == 0 {
+		for _, sink := range openSinks {
+			selected = append(selected, sink)
+		}
+		return selected
+	}
+	for _, name := range names {
+		selected = append(selected, openSinks[name])
+	}
+	return selected
+}
+
+// Builds the configuration of a receiver. Polling starts once the receiver
+// is added to the manager, push receivers wait for the manager's
+// PushHandler to be served
+func newReceiverConfig(cfg receiverConfig, logger *slog.Logger) (pkg.ReceiverConfig, error) {
+	token, err := cfg.authorizationToken()
+	if err != nil {
+		return pkg.ReceiverConfig{}, err
+	}
+	eventTypes, err := cfg.eventTypes()
+	if err != nil {
+		return pkg.ReceiverConfig{}, err
+	}
+
+	logger = logger.With(slog.String("receiver", cfg.Name))
+	receiverCfg := pkg.ReceiverConfig{
+		TransmitterUrl:     cfg.TransmitterUrl,
+		EventsRequested:    eventTypes,
+		AuthorizationToken: token,
+		Middleware:         []pkg.Middleware{pkg.Logging(logger)},
+		HandlerWorkers:     cfg.Workers,
+		VerifySignatures:   cfg.VerifySignatures,
+		Audience:           cfg.Audience,
+		Logger:             logger,
+	}
+	if cfg.StateFile != "" {
+		receiverCfg.StateStore = pkg.NewFileStateStore(cfg.StateFile)
+	}
+	if cfg.DeadLetterDir != "" {
+		receiverCfg.DeadLetterStore, err = pkg.NewFileDeadLetterStore(cfg.DeadLetterDir)
+		if err != nil {
+			return pkg.ReceiverConfig{}, err
+		}
+		receiverCfg.MaxAttempts = cfg.MaxAttempts
+	}
+
+	if cfg.Mode == "push" {
+		receiverCfg.PushEndpointUrl = cfg.PushUrl
+		receiverCfg.PushPath = cfg.PushPath
+		receiverCfg.PushAuthorizationHeader = cfg.PushAuthorizationHeader
+	} else {
+		receiverCfg.TransmitterPollUrl = cfg.PollUrl
+		receiverCfg.PollInterval = int(cfg.PollInterval.Seconds())
+		receiverCfg.LongPoll = cfg.LongPoll
+		receiverCfg.MaxEvents = cfg.MaxEvents
+	}
+	return receiverCfg, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestNewReceiverConfig(t *testing.T) {
+	cfg := testConfig()
+	cfg.Receivers[1].PushPath = "/internal/pushed"
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	polled, err := newReceiverConfig(cfg.Receivers[0], logger)
+	if err != nil {
+		t.Fatalf("newReceiverConfig(polled) error = %v", err)
+	}
+	if polled.TransmitterPollUrl != "https://tr.example.com/poll" || polled.PollInterval != 60 || polled.PushEndpointUrl != "" {
+		t.Fatalf("newReceiverConfig(polled) = %+v, want a poll receiver", polled)
+	}
+
+	// The push path is served behind a proxy, so it differs from push_url
+	pushed, err := newReceiverConfig(cfg.Receivers[1], logger)
+	if err != nil {
+		t.Fatalf("newReceiverConfig(pushed) error = %v", err)
+	}
+	if pushed.PushEndpointUrl != "https://receiver.example.com/ssf/pushed" || pushed.PushPath != "/internal/pushed" || pushed.TransmitterPollUrl != "" {
+		t.Fatalf("newReceiverConfig(pushed) = %+v, want push_url and push_path", pushed)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sgnl-ai/caep.dev-receiver/pkg"
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+// A field of a typed event, as printed by ssfctl decode
+type eventField struct {
+	name  string
+	value interface{}
+}
+
+// The output of ssfctl decode in JSON
+type decodeOutput struct {
+	*pkg.DecodedSet
+	Events []decodedEventOutput `json:"events"`
+}
+
+type decodedEventOutput struct {
+	pkg.DecodedEvent
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+func runDecode(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, opts := newFlagSet("decode")
+	jwksFile := fs.String("jwks", "", "JWKS file used to verify the signature")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ssfctl decode [flags] [file]\n\nReads the SET from file, or from stdin when file is missing or \"-\".")
+		fs.PrintDefaults()
+	}
+	err := opts.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	var input []byte
+	if fs.NArg() == 0 || fs.Arg(0) == "-" {
+		input, err = io.ReadAll(os.Stdin)
+	} else {
+		input, err = os.ReadFile(fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	// SETs pasted from logs or tickets are often quoted
+	set := strings.Trim(strings.TrimSpace(string(input)), `"'`)
+
+	var keys *pkg.KeySet
+	if *jwksFile != "" {
+		keys, err = pkg.LoadJwksFile(*jwksFile)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", *jwksFile, err)
+		}
+	}
+
+	decoded, err := pkg.DecodeSet(set, keys)
+	if err != nil {
+		return fmt.Errorf("not a JWT: %w", err)
+	}
+
+	if opts.output == "json" {
+		output := decodeOutput{DecodedSet: decoded, Events: []decodedEventOutput{}}
+		for _, event := range decoded.Events {
+			fields := map[string]interface{}{}
+			for _, field := range eventFields(event.Event) {
+				fields[field.name] = field.value
+			}
+			output.Events = append(output.Events, decodedEventOutput{DecodedEvent: event, Fields: fields})
+		}
+		err = opts.render(stdout, output, table{})
+	} else {
+		err = writeDecodedSet(stdout, decoded, keys != nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	if decoded.SignatureError != "" {
+		return fmt.Errorf("invalid signature: %s", decoded.SignatureError)
+	}
+	if len(decoded.Problems) > 0 {
+		return fmt.Errorf("SET has %d problems", len(decoded.Problems))
+	}
+	return nil
+}
+
+// Writes a decoded SET as a series of tables
+func writeDecodedSet(w io.Writer, decoded *pkg.DecodedSet, verified bool) error {
+	fmt.Fprintln(w, "HEADER")
+	err := writeTable(w, mapTable(decoded.Header))
+	if err != nil {
+		return err
+	}
+
+	claims := map[string]interface{}{}
+	for name, value := range decoded.Claims {
+		if name == "events" {
+			continue
+		}
+		if timestamp, ok := value.(float64); ok && (name == "iat" || name == "exp" || name == "nbf" || name == "toe") {
+			value = formatTimestamp(int64(timestamp))
+		}
+		claims[name] = value
+	}
+	fmt.Fprintln(w, "\nCLAIMS")
+	err = writeTable(w, mapTable(claims))
+	if err != nil {
+		return err
+	}
+
+	for _, event := range decoded.Events {
+		fmt.Fprintln(w, "\nEVENT "+event.Uri)
+		t := table{}
+		if event.Event == nil {
+			t = mapTable(event.Attributes)
+			t.rows = append(t.rows, []string{"  error", event.Error})
+		}
+		for _, field := range eventFields(event.Event) {
+			value := compactJson(field.value)
+			if timestamp, ok := field.value.(int64); ok {
+				value = formatTimestamp(timestamp)
+			}
+			t.rows = append(t.rows, []string{"  " + field.name, value})
+		}
+		err = writeTable(w, t)
+		if err != nil {
+			return err
+		}
+	}
+
+	signature := "not verified, set --jwks to verify it"
+	if decoded.SignatureVerified {
+		signature = "valid"
+	} else if verified {
+		signature = "INVALID: " + decoded.SignatureError
+	}
+	fmt.Fprintln(w, "\nSIGNATURE\n  "+signature)
+
+	if len(decoded.Problems) > 0 {
+		fmt.Fprintln(w, "\nPROBLEMS")
+		for _, problem := range decoded.Problems {
+			fmt.Fprintln(w, "  - "+problem)
+		}
+	}
+	return nil
+}
+
+// Returns an indented two column table of the members of a JSON object,
+// ordered by name
+func mapTable(values map[string]interface{}) table {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	t := table{}
+	for _, name := range names {
+		t.rows = append(t.rows, []string{"  " + name, compactJson(values[name])})
+	}
+	return t
+}
+
+// Returns the typed fields of an event parsed by EventStructFromEvent
+func eventFields(event events.SsfEvent) []eventField {
+	if event == nil {
+		return nil
+	}
+
+	uri := event.GetEventUri()
+	fields := []eventField{{"type", uri[strings.LastIndex(uri, "/")+1:]}}
+	switch event := event.(type) {
+	case *events.VerificationEvent:
+		return append(fields, eventField{"state", event.State})
+	case *events.StreamUpdatedEvent:
+		return append(fields, eventField{"status", event.Status}, eventField{"reason", event.Reason})
+	}
+
+	fields = append(fields,
+		eventField{"subject_format", subjectFormatName(event.GetSubjectFormat())},
+		eventField{"subject", event.GetSubject()},
+		eventField{"event_timestamp", event.GetTimestamp()},
+	)
+	switch event := event.(type) {
+	case *events.CredentialChangeEvent:
+		fields = append(fields, eventField{"credential_type", string(event.CredentialType)}, eventField{"change_type", string(event.ChangeType)})
+	case *events.DeviceComplianceEvent:
+		fields = append(fields, eventField{"previous_status", event.PreviousStatus}, eventField{"current_status", event.CurrentStatus})
+	case *events.AssuranceLevelChangeEvent:
+		fields = append(fields, eventField{"namespace", event.Namespace}, eventField{"current_level", event.CurrentLevel})
+		if event.PreviousLevel != nil && *event.PreviousLevel != "" {
+			fields = append(fields, eventField{"previous_level", *event.PreviousLevel})
+		}
+		if event.ChangeDirection != nil && *event.ChangeDirection != "" {
+			fields = append(fields, eventField{"change_direction", *event.ChangeDirection})
+		}
+	case *events.TokenClaimsChangeEvent:
+		fields = append(fields, eventField{"claims", event.Claims})
+	}
+	return fields
+}
+
+func subjectFormatName(format events.SubjectFormat) string {
+	if name, found := events.SubjectFormatName[format]; found {
+		return name
+	}
+	return fmt.Sprint(int(format))
+}
+
+func formatTimestamp(timestamp int64) string {
+	return fmt.Sprintf("%d (%s)", timestamp, time.Unix(timestamp, 0).UTC().Format(time.RFC3339))
+}
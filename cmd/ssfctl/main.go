@@ -0,0 +1,233 @@
+// Command ssfctl inspects and manages the streams of a Shared Signals
+// Framework transmitter.
+//
+// Usage:
+//
+//	ssfctl discover
+//	ssfctl stream create|get|update|delete|list [flags]
+//	ssfctl status get|set [flags]
+//	ssfctl subjects add|remove [flags]
+//	ssfctl verify [flags]
+//	ssfctl poll [--follow] [flags]
+//	ssfctl decode [--jwks file] [file]
+//
+// The transmitter and the credentials are read from flags, or from the
+// environment when the flags aren't set:
+//
+//	--transmitter   SSF_TRANSMITTER   the issuer url of the transmitter
+//	--token         SSF_TOKEN         the bearer token
+//	--token-file    SSF_TOKEN_FILE    a file containing the bearer token
+//	-o              SSF_OUTPUT        the output format, table or json
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/sgnl-ai/caep.dev-receiver/pkg"
+)
+
+const usage = `Usage: ssfctl <command> [flags]
+
+Commands:
+  discover                  show the transmitter configuration metadata
+  stream create             create a stream
+  stream get                show a stream
+  stream update             update the configuration of a stream
+  stream delete             delete a stream
+  stream list               list the streams
+  status get                show the status of a stream
+  status set                enable, pause or disable a stream
+  subjects add              add a subject to a stream
+  subjects remove           remove a subject from a stream
+  verify                    request a verification event
+  poll                      poll for SETs, once or with --follow
+  decode                    decode and validate a SET, read from a file or stdin
+
+Run "ssfctl <command> -h" for the flags of a command.
+`
+
+// A leaf command, run with the arguments that follow its name
+type command func(ctx context.Context, args []string, stdout io.Writer) error
+
+var commands = map[string]command{
+	"discover":        runDiscover,
+	"stream create":   runStreamCreate,
+	"stream get":      runStreamGet,
+	"stream update":   runStreamUpdate,
+	"stream delete":   runStreamDelete,
+	"stream list":     runStreamList,
+	"status get":      runStatusGet,
+	"status set":      runStatusSet,
+	"subjects add":    runSubjectsAdd,
+	"subjects remove": runSubjectsRemove,
+	"verify":          runVerify,
+	"poll":            runPoll,
+	"decode":          runDecode,
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdout)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ssfctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout io.Writer) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Fprint(os.Stderr, usage)
+		return flag.ErrHelp
+	}
+
+	if cmd, found := commands[args[0]]; found {
+		return cmd(ctx, args[1:], stdout)
+	}
+	if len(args) > 1 {
+		if cmd, found := commands[args[0]+" "+args[1]]; found {
+			return cmd(ctx, args[2:], stdout)
+		}
+	}
+
+	fmt.Fprint(os.Stderr, usage)
+	return fmt.Errorf("unknown command %q", strings.Join(args[:min(len(args), 2)], " "))
+}
+
+// The flags shared by all the commands
+type options struct {
+	transmitter string
+	token       string
+	tokenFile   string
+	output      string
+}
+
+// Creates the flag set of a command, registering the shared flags
+func newFlagSet(name string) (*flag.FlagSet, *options) {
+	fs := flag.NewFlagSet("ssfctl "+name, flag.ContinueOnError)
+	opts := &options{}
+	fs.StringVar(&opts.transmitter, "transmitter", os.Getenv("SSF_TRANSMITTER"), "issuer url of the transmitter (env SSF_TRANSMITTER)")
+	fs.StringVar(&opts.token, "token", "", "bearer token (env SSF_TOKEN)")
+	fs.StringVar(&opts.tokenFile, "token-file", "", "file containing the bearer token (env SSF_TOKEN_FILE)")
+	fs.StringVar(&opts.output, "o", envOr("SSF_OUTPUT", "table"), "output format, table or json (env SSF_OUTPUT)")
+	return fs, opts
+}
+
+// Parses the command's arguments and validates the shared flags. At most
+// maxArgs positional arguments are accepted
+func (opts *options) parse(fs *flag.FlagSet, args []string, maxArgs int) error {
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > maxArgs {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(maxArgs))
+	}
+	if opts.output != "table" && opts.output != "json" {
+		return fmt.Errorf("unknown output format %q, must be table or json", opts.output)
+	}
+	return nil
+}
+
+// Returns the issuer url of the transmitter
+func (opts *options) transmitterUrl() (string, error) {
+	if opts.transmitter == "" {
+		return "", errors.New("the transmitter is required, set --transmitter or SSF_TRANSMITTER")
+	}
+	return opts.transmitter, nil
+}
+
+// Returns the bearer token, read from --token, --token-file, SSF_TOKEN or
+// SSF_TOKEN_FILE, in that order
+func (opts *options) authorizationToken() (string, error) {
+	if opts.token != "" {
+		return opts.token, nil
+	}
+
+	tokenFile := opts.tokenFile
+	if tokenFile == "" {
+		if token := os.Getenv("SSF_TOKEN"); token != "" {
+			return token, nil
+		}
+		tokenFile = os.Getenv("SSF_TOKEN_FILE")
+	}
+	if tokenFile == "" {
+		return "", errors.New("a token is required, set --token, --token-file, SSF_TOKEN or SSF_TOKEN_FILE")
+	}
+
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", tokenFile)
+	}
+	return token, nil
+}
+
+// Discovers the transmitter and returns a client for its stream
+// management API
+func (opts *options) client(ctx context.Context) (*pkg.TransmitterClient, error) {
+	transmitterUrl, err := opts.transmitterUrl()
+	if err != nil {
+		return nil, err
+	}
+	token, err := opts.authorizationToken()
+	if err != nil {
+		return nil, err
+	}
+	return pkg.NewTransmitterClient(ctx, pkg.ClientConfig{
+		TransmitterUrl:     transmitterUrl,
+		AuthorizationToken: token,
+	})
+}
+
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func runDiscover(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, opts := newFlagSet("discover")
+	err := opts.parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	transmitterUrl, err := opts.transmitterUrl()
+	if err != nil {
+		return err
+	}
+
+	transmitterCfg, err := pkg.DiscoverTransmitter(ctx, transmitterUrl)
+	if err != nil {
+		return err
+	}
+	return opts.render(stdout, transmitterCfg, fieldsTable(
+		"issuer", transmitterCfg.Issuer,
+		"spec_version", transmitterCfg.SpecVersion,
+		"jwks_uri", transmitterCfg.JwksUri,
+		"delivery_methods_supported", strings.Join(transmitterCfg.DeliveryMethodsSupported, ", "),
+		"configuration_endpoint", transmitterCfg.ConfigurationEndpoint,
+		"status_endpoint", transmitterCfg.StatusEndpoint,
+		"add_subject_endpoint", transmitterCfg.AddSubjectEndpoint,
+		"remove_subject_endpoint", transmitterCfg.RemoveSubjectEndpoint,
+		"verification_endpoint", transmitterCfg.VerificationEndpoint,
+		"critical_subject_members", strings.Join(transmitterCfg.CriticalSubjectMembers, ", "),
+		"default_subjects", transmitterCfg.DefaultSubjects,
+	))
+}
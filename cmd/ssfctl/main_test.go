@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sgnl-ai/caep.dev-receiver/pkg"
+)
+
+// Serves the discovery metadata and a single poll stream
+func newTestTransmitter(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case pkg.TransmitterConfigMetadataPath:
+			json.NewEncoder(w).Encode(pkg.TransmitterConfig{Issuer: server.URL, ConfigurationEndpoint: server.URL + "/streams"})
+		case "/streams":
+			json.NewEncoder(w).Encode(pkg.StreamConfiguration{
+				StreamId: r.URL.Query().Get("stream_id"),
+				Delivery: &pkg.SsfDelivery{Method: pkg.TransmitterPollRFC, EndpointUrl: server.URL + "/poll"},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRun(t *testing.T) {
+	for _, key := range []string{"SSF_TRANSMITTER", "SSF_TOKEN", "SSF_TOKEN_FILE", "SSF_OUTPUT", "SSF_POLL_URL"} {
+		t.Setenv(key, "")
+	}
+	transmitter := newTestTransmitter(t).URL
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantErr    string
+		wantOutput string
+	}{
+		{name: "no command", args: nil, wantErr: flag.ErrHelp.Error()},
+		{name: "help", args: []string{"help"}, wantErr: flag.ErrHelp.Error()},
+		{name: "unknown command", args: []string{"streams"}, wantErr: `unknown command "streams"`},
+		{name: "missing subcommand", args: []string{"stream"}, wantErr: `unknown command "stream"`},
+		{name: "unknown subcommand", args: []string{"stream", "rename", "--stream-id", "a"}, wantErr: `unknown command "stream rename"`},
+		{name: "unexpected argument", args: []string{"discover", "extra"}, wantErr: `unexpected argument "extra"`},
+		{name: "unknown output format", args: []string{"discover", "-o", "yaml"}, wantErr: `unknown output format "yaml"`},
+		{name: "missing transmitter", args: []string{"discover"}, wantErr: "the transmitter is required"},
+		{
+			name:       "command",
+			args:       []string{"discover", "--transmitter", transmitter, "-o", "json"},
+			wantOutput: `"issuer": "` + transmitter + `"`,
+		},
+		{
+			name:       "subcommand",
+			args:       []string{"stream", "get", "--transmitter", transmitter, "--token", "token", "--stream-id", "stream-1", "-o", "json"},
+			wantOutput: `"stream_id": "stream-1"`,
+		},
+		{
+			name:    "missing token",
+			args:    []string{"stream", "get", "--transmitter", transmitter},
+			wantErr: "a token is required",
+		},
+		{
+			name:    "poll without a stream or poll url",
+			args:    []string{"poll", "--transmitter", transmitter, "--token", "token"},
+			wantErr: "set --stream-id or --poll-url",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout strings.Builder
+			err := run(context.Background(), test.args, &stdout)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("run(%q) error = %v, want %q", test.args, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("run(%q) error = %v", test.args, err)
+			}
+			if !strings.Contains(stdout.String(), test.wantOutput) {
+				t.Fatalf("run(%q) printed %s, want %s", test.args, stdout.String(), test.wantOutput)
+			}
+		})
+	}
+}
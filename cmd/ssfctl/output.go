@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// The table form of a command's output
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// Returns a two column table of field names and values, skipping empty
+// values
+func fieldsTable(fieldsAndValues ...string) table {
+	t := table{header: []string{"FIELD", "VALUE"}}
+	for i := 0; i+1 < len(fieldsAndValues); i += 2 {
+		if fieldsAndValues[i+1] != "" {
+			t.rows = append(t.rows, []string{fieldsAndValues[i], fieldsAndValues[i+1]})
+		}
+	}
+	return t
+}
+
+// Writes the output of a command, as indented JSON or as the given table
+func (opts *options) render(w io.Writer, value interface{}, t table) error {
+	if opts.output == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+	return writeTable(w, t)
+}
+
+func writeTable(w io.Writer, t table) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if len(t.header) > 0 {
+		fmt.Fprintln(tw, strings.Join(t.header, "\t"))
+	}
+	for _, row := range t.rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// Formats a JSON value, such as a subject, on a single line
+func compactJson(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sgnl-ai/caep.dev-receiver/pkg"
+)
+
+// A SET returned by a poll request, as printed by ssfctl poll
+type polledSet struct {
+	JTI    string                 `json:"jti"`
+	Set    string                 `json:"set"`
+	Claims map[string]interface{} `json:"claims,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+func runPoll(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, opts := newFlagSet("poll")
+	streamId := fs.String("stream-id", "", "the id of the stream, used to find the poll url")
+	pollUrl := fs.String("poll-url", envOr("SSF_POLL_URL", ""), "the poll endpoint, defaults to the endpoint_url of the stream (env SSF_POLL_URL)")
+	maxEvents := fs.Int("max-events", 10, "the maximum number of SETs returned per poll request")
+	follow := fs.Bool("follow", false, "keep polling and print SETs as they arrive")
+	interval := fs.Duration("interval", 5*time.Second, "the wait between poll requests with --follow, when the queue is empty")
+	ack := fs.Bool("ack", false, "acknowledge the received SETs, removing them from the transmitter's queue")
+	err := opts.parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	if *pollUrl == "" && *streamId == "" {
+		return errors.New("the poll url is required, set --stream-id or --poll-url")
+	}
+
+	client, err := opts.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	if *pollUrl == "" {
+		stream, err := client.GetStream(ctx, *streamId)
+		if err != nil {
+			return fmt.Errorf("finding the poll url: %w", err)
+		}
+		if stream.Delivery == nil || stream.Delivery.Method != pkg.TransmitterPollRFC || stream.Delivery.EndpointUrl == "" {
+			return errors.New("the stream isn't a poll stream, set --poll-url")
+		}
+		*pollUrl = stream.Delivery.EndpointUrl
+	}
+
+	// Without --ack the transmitter returns the same SETs again, so SETs
+	// are only printed the first time they are seen
+	seen := map[string]bool{}
+	var acks []string
+	printHeader := true
+	for {
+		request := pkg.PollTransmitterRequest{Acknowledgements: acks, MaxEvents: *maxEvents, ReturnImmediately: true}
+		response, err := client.Poll(ctx, *pollUrl, request)
+		if err != nil {
+			if ctx.Err() != nil {
+				// The transmitter may not have received the
+				// acknowledgements sent with the cancelled request
+				return flushAcks(client, *pollUrl, acks)
+			}
+			if !*follow {
+				return err
+			}
+			fmt.Fprintln(os.Stderr, "ssfctl: poll failed:", err)
+			if sleep(ctx, *interval) != nil {
+				return nil
+			}
+			continue
+		}
+
+		acks = nil
+		var sets []polledSet
+		for jti, set := range response.Sets {
+			if *ack {
+				acks = append(acks, jti)
+			}
+			if seen[jti] {
+				continue
+			}
+			seen[jti] = true
+			sets = append(sets, decodePolledSet(jti, set))
+		}
+		sort.Slice(sets, func(i, j int) bool { return sets[i].JTI < sets[j].JTI })
+
+		err = printPolledSets(stdout, opts, sets, printHeader)
+		if err != nil {
+			return err
+		}
+		printHeader = false
+
+		if !*follow {
+			return sendAcks(ctx, client, *pollUrl, acks)
+		}
+
+		// Without --ack the transmitter returns the same SETs right away,
+		// so it is only polled again immediately when the last batch was
+		// acknowledged and more SETs are waiting
+		if response.MoreAvailable && len(acks) > 0 {
+			continue
+		}
+		if sleep(ctx, *interval) != nil {
+			// The acknowledgements of the last batch are sent before
+			// exiting
+			return flushAcks(client, *pollUrl, acks)
+		}
+	}
+}
+
+// ackFlushTimeout bounds sending the last acknowledgements once ssfctl
+// poll is interrupted
+const ackFlushTimeout = 10 * time.Second
+
+// Acknowledges the SETs with the transmitter, without polling for more
+func sendAcks(ctx context.Context, client *pkg.TransmitterClient, pollUrl string, acks []string) error {
+	if len(acks) == 0 {
+		return nil
+	}
+	_, err := client.Poll(ctx, pollUrl, pkg.PollTransmitterRequest{Acknowledgements: acks, MaxEvents: 0, ReturnImmediately: true})
+	return err
+}
+
+// Sends the acknowledgements that are still pending once polling was
+// interrupted. The command's context is cancelled by then, so a fresh one
+// bounded by ackFlushTimeout is used
+func flushAcks(client *pkg.TransmitterClient, pollUrl string, acks []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ackFlushTimeout)
+	defer cancel()
+	err := sendAcks(ctx, client, pollUrl, acks)
+	if err != nil {
+		return fmt.Errorf("acknowledging %d SETs: %w", len(acks), err)
+	}
+	return nil
+}
+
+// Decodes a SET without verifying its signature, for display
+func decodePolledSet(jti string, set string) polledSet {
+	polled := polledSet{JTI: jti, Set: set}
+	decoded, err := pkg.DecodeSet(set, nil)
+	if err != nil {
+		polled.Error = err.Error()
+		return polled
+	}
+	polled.Claims = decoded.Claims
+	return polled
+}
+
+// Prints the SETs of a poll response. JSON output is written as one object
+// per line, so it can be piped while following
+func printPolledSets(w io.Writer, opts *options, sets []polledSet, printHeader bool) error {
+	if opts.output == "json" {
+		encoder := json.NewEncoder(w)
+		for _, set := range sets {
+			err := encoder.Encode(set)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	t := table{}
+	if printHeader {
+		t.header = []string{"JTI", "ISSUED", "EVENTS", "SUBJECT"}
+	}
+	for _, set := range sets {
+		if set.Error != "" {
+			t.rows = append(t.rows, []string{set.JTI, "", "invalid SET: " + set.Error, ""})
+			continue
+		}
+
+		var issued string
+		if iat, ok := set.Claims["iat"].(float64); ok {
+			issued = time.Unix(int64(iat), 0).UTC().Format(time.RFC3339)
+		}
+
+		var eventUris []string
+		var subject interface{} = set.Claims["sub_id"]
+		if ssfEvents, ok := set.Claims["events"].(map[string]interface{}); ok {
+			for uri, event := range ssfEvents {
+				eventUris = append(eventUris, uri[strings.LastIndex(uri, "/")+1:])
+				if attributes, ok := event.(map[string]interface{}); ok && subject == nil {
+					subject = attributes["subject"]
+				}
+			}
+		}
+		sort.Strings(eventUris)
+		t.rows = append(t.rows, []string{set.JTI, issued, strings.Join(eventUris, ", "), compactJson(subject)})
+	}
+	return writeTable(w, t)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sgnl-ai/caep.dev-receiver/pkg"
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+// The flags describing a stream configuration, for stream create and
+// stream update
+type streamFlags struct {
+	file                string
+	events              string
+	delivery            string
+	endpointUrl         string
+	authorizationHeader string
+	description         string
+}
+
+func (sf *streamFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&sf.file, "file", "", "JSON file with the stream configuration, the other flags override its fields")
+	fs.StringVar(&sf.events, "events", "", "comma separated event URIs or names, e.g. session-revoked,credential-change")
+	fs.StringVar(&sf.delivery, "delivery", "", "delivery method, poll or push, or its URN")
+	fs.StringVar(&sf.endpointUrl, "endpoint-url", "", "the push endpoint, or the poll endpoint")
+	fs.StringVar(&sf.authorizationHeader, "authorization-header", "", "the Authorization header the transmitter sends with pushed SETs")
+	fs.StringVar(&sf.description, "description", "", "description of the stream")
+}
+
+// Builds the stream configuration from the file and the flags
+func (sf *streamFlags) configuration() (pkg.StreamConfiguration, error) {
+	var stream pkg.StreamConfiguration
+	if sf.file != "" {
+		data, err := os.ReadFile(sf.file)
+		if err != nil {
+			return stream, err
+		}
+		err = json.Unmarshal(data, &stream)
+		if err != nil {
+			return stream, fmt.Errorf("reading %s: %w", sf.file, err)
+		}
+	}
+
+	if sf.events != "" {
+		stream.EventsRequested = nil
+		for _, name := range strings.Split(sf.events, ",") {
+			uri, err := eventUri(strings.TrimSpace(name))
+			if err != nil {
+				return stream, err
+			}
+			stream.EventsRequested = append(stream.EventsRequested, uri)
+		}
+	}
+
+	if sf.delivery != "" || sf.endpointUrl != "" || sf.authorizationHeader != "" {
+		if stream.Delivery == nil {
+			stream.Delivery = &pkg.SsfDelivery{}
+		}
+		switch sf.delivery {
+		case "":
+		case "poll":
+			stream.Delivery.Method = pkg.TransmitterPollRFC
+		case "push":
+			stream.Delivery.Method = pkg.TransmitterPushRFC
+		default:
+			stream.Delivery.Method = sf.delivery
+		}
+		if sf.endpointUrl != "" {
+			stream.Delivery.EndpointUrl = sf.endpointUrl
+		}
+		if sf.authorizationHeader != "" {
+			stream.Delivery.AuthorizationHeader = sf.authorizationHeader
+		}
+	}
+
+	if sf.description != "" {
+		stream.Description = sf.description
+	}
+	return stream, nil
+}
+
+// Returns the URI of an event given its URI or the last segment of its
+// URI, e.g. session-revoked
+func eventUri(name string) (string, error) {
+	if strings.Contains(name, ":") {
+		return name, nil
+	}
+	for _, uri := range events.EventUri {
+		if strings.HasSuffix(uri, "/"+name) {
+			return uri, nil
+		}
+	}
+	return "", fmt.Errorf("unknown event %q", name)
+}
+
+func streamTable(streams ...pkg.StreamConfiguration) table {
+	t := table{header: []string{"STREAM ID", "DELIVERY", "ENDPOINT", "EVENTS DELIVERED", "DESCRIPTION"}}
+	for _, stream := range streams {
+		var method, endpoint string
+		if stream.Delivery != nil {
+			method, endpoint = stream.Delivery.Method, stream.Delivery.EndpointUrl
+		}
+		delivered := stream.EventsDelivered
+		if len(delivered) == 0 {
+			delivered = stream.EventsRequested
+		}
+		t.rows = append(t.rows, []string{stream.StreamId, method, endpoint, strconv.Itoa(len(delivered)), stream.Description})
+	}
+	return t
+}
+
+func runStreamCreate(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, opts := newFlagSet("stream create")
+	var sf streamFlags
+	sf.register(fs)
+	err := opts.parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	stream, err := sf.configuration()
+	if err != nil {
+		return err
+	}
+	if stream.Delivery == nil {
+		stream.Delivery = &pkg.SsfDelivery{Method: pkg.TransmitterPollRFC}
+	}
+
+	client, err := opts.client(ctx)
+	if err != nil {
+		return err
+	}
+	created, err := client.CreateStream(ctx, stream)
+	if err != nil {
+		return err
+	}
+	return opts.render(stdout, created, streamTable(*created))
+}
+
+func runStreamGet(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, opts := newFlagSet("stream get")
+	streamId := fs.String("stream-id", "", "the id of the stream, optional if the transmitter has a single stream")
+	err := opts.parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	client, err := opts.client(ctx)
+	if err != nil {
+		return err
+	}
+	stream, err := client.GetStream(ctx, *streamId)
+	if err != nil {
+		return err
+	}
+
+	var method, endpoint string
+	if stream.Delivery != nil {
+		method, endpoint = stream.Delivery.Method, stream.Delivery.EndpointUrl
+	}
+	return opts.render(stdout, stream, fieldsTable(
+		"stream_id", stream.StreamId,
+		"iss", stream.Iss,
+		"aud", compactJson(stream.Aud),
+		"delivery_method", method,
+		"endpoint_url", endpoint,
+		"events_supported", strings.Join(stream.EventsSupported, ", "),
+		"events_requested", strings.Join(stream.EventsRequested, ", "),
+		"events_delivered", strings.Join(stream.EventsDelivered, ", "),
+		"min_verification_interval", formatInt(stream.MinVerificationInterval),
+		"inactivity_timeout", formatInt(stream.InactivityTimeout),
+		"description", stream.Description,
+	))
+}
+
+func runStreamUpdate(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, opts := newFlagSet("stream update")
+	streamId := fs.String("stream-id", "", "the id of the stream")
+	var sf streamFlags
+	sf.register(fs)
+	err := opts.parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	stream, err := sf.configuration()
+	if err != nil {
+		return err
+	}
+	if *streamId != "" {
+		stream.StreamId = *streamId
+	}
+	if stream.StreamId == "" {
+		return errors.New("--stream-id is required")
+	}
+
+	client, err := opts.client(ctx)
+	if err != nil {
+		return err
+	}
+	updated, err := client.UpdateStream(ctx, stream)
+	if err != nil {
+		return err
+	}
+	return opts.render(stdout, updated, streamTable(*updated))
+}
+
+func runStreamDelete(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, opts := newFlagSet("stream delete")
+	streamId := fs.String("stream-id", "", "the id of the stream")
+	err := opts.parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	if *streamId == "" {
+		return errors.New("--stream-id is required")
+	}
+
+	client, err := opts.client(ctx)
+	if err != nil {
+		return err
+	}
+	err = client.DeleteStream(ctx, *streamId)
+	if err != nil {
+		return err
+	}
+
+	result := map[string]string{"stream_id": *streamId, "result": "deleted"}
+	return opts.render(stdout, result, fieldsTable("stream_id", *streamId, "result", "deleted"))
+}
+
+func runStreamList(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, opts := newFlagSet("stream list")
+	err := opts.parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	client, err := opts.client(ctx)
+	if err != nil {
+		return err
+	}
+	streams, err := client.ListStreams(ctx)
+	if err != nil {
+		return err
+	}
+	return opts.render(stdout, streams, streamTable(streams...))
+}
+
+func statusTable(status *pkg.StreamStatusResponse) table {
+	return fieldsTable("stream_id", status.StreamId, "status", status.Status, "reason", status.Reason)
+}
+
+func runStatusGet(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, opts := newFlagSet("status get")
+	streamId := fs.String("stream-id", "", "the id of the stream, optional if the transmitter has a single stream")
+	err := opts.parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	client, err := opts.client(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := client.GetStreamStatus(ctx, *streamId)
+	if err != nil {
+		return err
+	}
+	return opts.render(stdout, status, statusTable(status))
+}
+
+func runStatusSet(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, opts := newFlagSet("status set")
+	streamId := fs.String("stream-id", "", "the id of the stream")
+	statusName := fs.String("status", "", "the new status: enabled, paused or disabled")
+	reason := fs.String("reason", "", "the reason for the change")
+	err := opts.parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	status, found := pkg.StatusEnumMap[*statusName]
+	if !found {
+		return fmt.Errorf("invalid --status %q, must be enabled, paused or disabled", *statusName)
+	}
+
+	client, err := opts.client(ctx)
+	if err != nil {
+		return err
+	}
+	updated, err := client.SetStreamStatus(ctx, *streamId, status, *reason)
+	if err != nil {
+		return err
+	}
+	return opts.render(stdout, updated, statusTable(updated))
+}
+
+// The flags identifying a subject, either as JSON or as a format and
+// identifier
+type subjectFlags struct {
+	subject string
+	format  string
+	id      string
+}
+
+func (sf *subjectFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&sf.subject, "subject", "", `the subject as JSON, e.g. {"format":"email","email":"user@example.com"}`)
+	fs.StringVar(&sf.format, "format", "", "the subject format, used with --id: email, phone_number, opaque, account, uri or did")
+	fs.StringVar(&sf.id, "id", "", "the subject identifier, used with --format")
+}
+
+// Builds the subject from the flags
+func (sf *subjectFlags) value() (map[string]interface{}, error) {
+	if sf.subject != "" {
+		var subject map[string]interface{}
+		err := json.Unmarshal([]byte(sf.subject), &subject)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --subject: %w", err)
+		}
+		return subject, nil
+	}
+	if sf.format == "" || sf.id == "" {
+		return nil, errors.New("a subject is required, set --subject or --format and --id")
+	}
+
+	// The member holding the identifier is named after the format, except
+	// for the formats below
+	member := sf.format
+	switch sf.format {
+	case events.OpaqueSubjectFormat:
+		member = "id"
+	case events.DecentralizedIdentifierSubjectFormat:
+		member = "url"
+	case events.UniqueResourceIdentifierSubjectFormat, events.AccountSubjectFormat:
+		member = "uri"
+	case events.IssuerAndSubjectFormat, events.AliasesSubjectFormat:
+		return nil, fmt.Errorf("%s subjects must be given with --subject", sf.format)
+	}
+	return map[string]interface{}{"format": sf.format, member: sf.id}, nil
+}
+
+func runSubjectsAdd(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, opts := newFlagSet("subjects add")
+	streamId := fs.String("stream-id", "", "the id of the stream")
+	var sf subjectFlags
+	sf.register(fs)
+	verified := fs.Bool("verified", true, "whether the receiver verified the subject")
+	err := opts.parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	subject, err := sf.value()
+	if err != nil {
+		return err
+	}
+	// verified is only sent when it's set explicitly, the transmitter
+	// assumes true otherwise
+	var verifiedFlag *bool
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "verified" {
+			verifiedFlag = verified
+		}
+	})
+
+	client, err := opts.client(ctx)
+	if err != nil {
+		return err
+	}
+	err = client.AddSubject(ctx, *streamId, subject, verifiedFlag)
+	if err != nil {
+		return err
+	}
+
+	result := map[string]interface{}{"stream_id": *streamId, "subject": subject, "result": "added"}
+	return opts.render(stdout, result, fieldsTable("stream_id", *streamId, "subject", compactJson(subject), "result", "added"))
+}
+
+func runSubjectsRemove(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, opts := newFlagSet("subjects remove")
+	streamId := fs.String("stream-id", "", "the id of the stream")
+	var sf subjectFlags
+	sf.register(fs)
+	err := opts.parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	subject, err := sf.value()
+	if err != nil {
+		return err
+	}
+
+	client, err := opts.client(ctx)
+	if err != nil {
+		return err
+	}
+	err = client.RemoveSubject(ctx, *streamId, subject)
+	if err != nil {
+		return err
+	}
+
+	result := map[string]interface{}{"stream_id": *streamId, "subject": subject, "result": "removed"}
+	return opts.render(stdout, result, fieldsTable("stream_id", *streamId, "subject", compactJson(subject), "result", "removed"))
+}
+
+func runVerify(ctx context.Context, args []string, stdout io.Writer) error {
+	fs, opts := newFlagSet("verify")
+	streamId := fs.String("stream-id", "", "the id of the stream")
+	state := fs.String("state", "", "the state echoed back in the verification event")
+	err := opts.parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	client, err := opts.client(ctx)
+	if err != nil {
+		return err
+	}
+	err = client.RequestVerification(ctx, *streamId, *state)
+	if err != nil {
+		return err
+	}
+
+	result := map[string]string{"stream_id": *streamId, "state": *state, "result": "requested"}
+	return opts.render(stdout, result, fieldsTable("stream_id", *streamId, "state", *state, "result", "requested"))
+}
+
+func formatInt(value int) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.Itoa(value)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/sgnl-ai/caep.dev-receiver/pkg"
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+func main() {
+	// Configure the receiver (no poll callback, events are consumed from a channel)
+	receiverConfig := pkg.ReceiverConfig{
+		TransmitterUrl:     "https://ssf.caep.dev",
+		TransmitterPollUrl: "https://ssf.caep.dev/ssf/streams/poll",
+		EventsRequested:    []events.EventType{0, 1, 2, 3, 4},
+		AuthorizationToken: "<access token>",
+		PollInterval:       20,
+		DeliveryBufferSize: 10,
+	}
+
+	receiver, err := pkg.ConfigureSsfReceiver(receiverConfig)
+	if err != nil {
+		print(err)
+		return
+	}
+
+	// Start polling and share the events between several consumers
+	deliveries := receiver.Events()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(consumer int) {
+			defer wg.Done()
+			for delivery := range deliveries {
+				fmt.Printf("consumer %d received %s (jti %s)\n", consumer, delivery.Event.GetEventUri(), delivery.JTI)
+				delivery.Ack()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+}
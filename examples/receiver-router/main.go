@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/sgnl-ai/caep.dev-receiver/pkg"
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+func main() {
+	// Register a handler per event type instead of switching on event.GetType()
+	router := pkg.NewRouter()
+	router.OnSessionRevoked(func(ctx context.Context, event *events.SessionRevokedEvent) error {
+		fmt.Printf("session revoked for %v\n", event.Subject)
+		return nil
+	})
+	router.OnCredentialChange(func(ctx context.Context, event *events.CredentialChangeEvent) error {
+		fmt.Printf("credential %s changed (%s) for %v\n", event.CredentialType, event.ChangeType, event.Subject)
+		return nil
+	})
+	pkg.Handle(router, func(ctx context.Context, event *events.DeviceComplianceEvent) error {
+		fmt.Printf("device compliance changed from %s to %s\n", event.PreviousStatus, event.CurrentStatus)
+		return nil
+	})
+	router.Fallback(func(ctx context.Context, event events.SsfEvent) error {
+		fmt.Printf("unrouted event %s\n", event.GetEventUri())
+		return nil
+	})
+
+	// Configure the receiver with the router as its handler to start polling
+	receiverConfig := pkg.ReceiverConfig{
+		TransmitterUrl:     "https://ssf.caep.dev",
+		TransmitterPollUrl: "https://ssf.caep.dev/ssf/streams/poll",
+		EventsRequested:    []events.EventType{0, 1, 2, 3, 4},
+		AuthorizationToken: "<access token>",
+		Handler:            router.Handle,
+		PollInterval:       20,
+		ShutdownAction:     pkg.ShutdownPauseStream,
+	}
+
+	receiver, err := pkg.ConfigureSsfReceiver(receiverConfig)
+	if err != nil {
+		print(err)
+		return
+	}
+
+	// Wait for an interrupt, then give the handlers up to 10 seconds to
+	// finish before the stream is paused
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = receiver.Shutdown(shutdownCtx)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
@@ -1,5 +1,8 @@
 module github.com/sgnl-ai/caep.dev-receiver
 
-go 1.20
+go 1.21
 
-require github.com/golang-jwt/jwt/v5 v5.0.0
+require (
+	github.com/golang-jwt/jwt/v5 v5.0.0
+	gopkg.in/yaml.v3 v3.0.1
+)
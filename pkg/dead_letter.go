@@ -0,0 +1,351 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+// DefaultMaxAttempts is how many times an event is handled before it is
+// dead-lettered, when the receiver is not configured with MaxAttempts
+const DefaultMaxAttempts = 5
+
+// Returned when a dead letter isn't in the store
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// A failed attempt to handle an event
+type DeadLetterAttempt struct {
+	// Error defines the error the handler returned
+	Error string `json:"error"`
+
+	// At defines when the handler failed
+	At time.Time `json:"at"`
+}
+
+// An event the handler failed on MaxAttempts times, set aside so the
+// events after it can be handled.
+//
+// SETs from the receiver's Inbox that cannot be parsed are dead-lettered
+// as a whole, with only the JTI as their Id and no EventUri or Event;
+// replaying them fails
+type DeadLetter struct {
+	// Id identifies the dead letter: the JTI of the SET and the event URI
+	// joined by a "#"
+	Id string `json:"id"`
+
+	// SET defines the raw SET the event was received in
+	SET string `json:"set"`
+
+	// EventUri defines the type of the event
+	EventUri string `json:"event_uri"`
+
+	// Event defines the members of the event, as found in the events
+	// claim of the SET. See ParsedEvent for the parsed SsfEvent
+	Event map[string]interface{} `json:"event,omitempty"`
+
+	// JTI, Txn, Issuer and DeliveryMethod describe the SET, see EventInfo
+	JTI            string `json:"jti"`
+	Txn            string `json:"txn,omitempty"`
+	Issuer         string `json:"issuer,omitempty"`
+	DeliveryMethod string `json:"delivery_method,omitempty"`
+
+	// Attempts defines the failed attempts to handle the event, oldest
+	// first, including failed replays
+	Attempts []DeadLetterAttempt `json:"attempts"`
+
+	// FirstFailedAt defines when the handler first failed on the event
+	FirstFailedAt time.Time `json:"first_failed_at"`
+
+	// DeadLetteredAt defines when the event was dead-lettered
+	DeadLetteredAt time.Time `json:"dead_lettered_at"`
+}
+
+// Returns the EventInfo of the SET the event was received in
+func (letter DeadLetter) EventInfo() EventInfo {
+	return EventInfo{JTI: letter.JTI, Txn: letter.Txn, Issuer: letter.Issuer, DeliveryMethod: letter.DeliveryMethod}
+}
+
+// Parses the event out of the dead letter's SET. The signature of the SET
+// isn't verified again
+func (letter DeadLetter) ParsedEvent() (events.SsfEvent, error) {
+	_, ssfEvents, err := parseSsfEventSet(letter.SET)
+	if err != nil {
+		return nil, err
+	}
+	for _, ssfEvent := range ssfEvents {
+		if ssfEvent.GetEventUri() == letter.EventUri {
+			return ssfEvent, nil
+		}
+	}
+	return nil, fmt.Errorf("SET %s doesn't contain a %s event", letter.JTI, letter.EventUri)
+}
+
+// Stores the events the receiver's handler repeatedly failed on. See
+// NewFileDeadLetterStore.
+//
+// Implementations must be safe for concurrent use
+type DeadLetterStore interface {
+	// Stores the dead letter, replacing any dead letter with the same Id
+	Put(letter DeadLetter) error
+
+	// Returns the dead letter with the given Id, or ErrDeadLetterNotFound
+	Get(id string) (*DeadLetter, error)
+
+	// Returns all the dead letters, oldest first
+	List() ([]DeadLetter, error)
+
+	// Removes the dead letter with the given Id. Removing a dead letter
+	// that isn't in the store is not an error
+	Delete(id string) error
+}
+
+// A DeadLetterStore keeping every dead letter as a JSON file in a
+// directory, so they can also be inspected and removed by hand
+type FileDeadLetterStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// Opens the file backed dead-letter store in the given directory, creating
+// the directory if it doesn't exist
+func NewFileDeadLetterStore(dir string) (*FileDeadLetterStore, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileDeadLetterStore{dir: dir}, nil
+}
+
+// Returns the path of a dead letter's file. Ids contain URIs, so the file
+// is named after their hash
+func (store *FileDeadLetterStore) path(id string) string {
+	hash := sha256.Sum256([]byte(id))
+	return filepath.Join(store.dir, hex.EncodeToString(hash[:16])+".json")
+}
+
+func (store *FileDeadLetterStore) Put(letter DeadLetter) error {
+	data, err := json.MarshalIndent(letter, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return writeFileAtomic(store.path(letter.Id), data)
+}
+
+func (store *FileDeadLetterStore) Get(id string) (*DeadLetter, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	letter, err := readDeadLetter(store.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrDeadLetterNotFound
+	}
+	return letter, err
+}
+
+func (store *FileDeadLetterStore) List() ([]DeadLetter, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	letters := []DeadLetter{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		letter, err := readDeadLetter(filepath.Join(store.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, *letter)
+	}
+	sort.SliceStable(letters, func(i, j int) bool {
+		return letters[i].DeadLetteredAt.Before(letters[j].DeadLetteredAt)
+	})
+	return letters, nil
+}
+
+func (store *FileDeadLetterStore) Delete(id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	err := os.Remove(store.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func readDeadLetter(path string) (*DeadLetter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	letter := &DeadLetter{}
+	err = json.Unmarshal(data, letter)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return letter, nil
+}
+
+// Passes a dead-lettered event to the handler again. The dead letter is
+// removed from the store once the handler succeeds, otherwise the failed
+// attempt is added to its history and the handler's error is returned
+func ReplayDeadLetter(ctx context.Context, store DeadLetterStore, id string, handler Handler) error {
+	letter, err := store.Get(id)
+	if err != nil {
+		return err
+	}
+	event, err := letter.ParsedEvent()
+	if err != nil {
+		return err
+	}
+
+	ctx = ContextWithEventInfo(contextWithSet(ctx, letter.SET), letter.EventInfo())
+	err = callHandler(ctx, handler, event)
+	if err != nil {
+		letter.Attempts = append(letter.Attempts, DeadLetterAttempt{Error: err.Error(), At: time.Now().UTC()})
+		return errors.Join(err, store.Put(*letter))
+	}
+	return store.Delete(id)
+}
+
+// Returns the events the receiver's handler failed on, oldest first
+func (receiver *SsfReceiverImplementation) DeadLetters() ([]DeadLetter, error) {
+	if receiver.deadLetters == nil {
+		return nil, errors.New("receiver has no DeadLetterStore")
+	}
+	return receiver.deadLetters.List()
+}
+
+// Passes a dead-lettered event to the receiver's handler again, see
+// ReplayDeadLetter
+func (receiver *SsfReceiverImplementation) ReplayDeadLetter(ctx context.Context, id string) error {
+	if receiver.deadLetters == nil {
+		return errors.New("receiver has no DeadLetterStore")
+	}
+	return ReplayDeadLetter(ctx, receiver.deadLetters, id, receiver.replayHandler)
+}
+
+type setKey struct{}
+
+// Returns a copy of ctx carrying the raw SET of the event being handled
+func contextWithSet(ctx context.Context, set string) context.Context {
+	return context.WithValue(ctx, setKey{}, set)
+}
+
+func setFromContext(ctx context.Context) string {
+	set, _ := ctx.Value(setKey{}).(string)
+	return set
+}
+
+// Tracks the failed attempts to handle the events that haven't succeeded
+// or been dead-lettered yet, by dead letter Id.
+//
+// The attempts are only kept in memory: they aren't part of ReceiverState,
+// so a restarted receiver counts an event's attempts from zero again
+type attemptTracker struct {
+	mu       sync.Mutex
+	attempts map[string][]DeadLetterAttempt
+}
+
+// Records a failed attempt and returns all the failed attempts of the
+// event. Events that haven't been retried within DefaultDedupWindow are
+// forgotten, since the transmitter gave up on them
+func (tracker *attemptTracker) fail(id string, err error) []DeadLetterAttempt {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	now := time.Now().UTC()
+	if tracker.attempts == nil {
+		tracker.attempts = map[string][]DeadLetterAttempt{}
+	}
+	for failedId, attempts := range tracker.attempts {
+		if now.Sub(attempts[len(attempts)-1].At) > DefaultDedupWindow {
+			delete(tracker.attempts, failedId)
+		}
+	}
+	tracker.attempts[id] = append(tracker.attempts[id], DeadLetterAttempt{Error: err.Error(), At: now})
+	return tracker.attempts[id]
+}
+
+func (tracker *attemptTracker) forget(id string) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	delete(tracker.attempts, id)
+}
+
+// Returns a middleware counting the failed attempts to handle each event.
+// Once an event failed maxAttempts times it is stored in the dead-letter
+// store and reported as handled, so it is acknowledged and the events
+// after it aren't held up
+func (receiver *SsfReceiverImplementation) deadLetterMiddleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, event events.SsfEvent) error {
+			err := callHandler(ctx, next, event)
+			info, _ := EventInfoFromContext(ctx)
+			id := info.JTI + "#" + event.GetEventUri()
+			if err == nil {
+				receiver.attempts.forget(id)
+				return nil
+			}
+
+			attempts := receiver.attempts.fail(id, err)
+			if len(attempts) < receiver.maxAttempts {
+				return err
+			}
+
+			letter := DeadLetter{
+				Id:             id,
+				SET:            setFromContext(ctx),
+				EventUri:       event.GetEventUri(),
+				JTI:            info.JTI,
+				Txn:            info.Txn,
+				Issuer:         info.Issuer,
+				DeliveryMethod: info.DeliveryMethod,
+				Attempts:       attempts,
+				FirstFailedAt:  attempts[0].At,
+				DeadLetteredAt: time.Now().UTC(),
+			}
+			if claims, decodeErr := decodeSet(letter.SET); decodeErr == nil {
+				if setEvents, ok := claims["events"].(map[string]interface{}); ok {
+					letter.Event, _ = setEvents[letter.EventUri].(map[string]interface{})
+				}
+			}
+
+			// The event is retried when it can't be stored, so it isn't lost
+			putErr := receiver.deadLetters.Put(letter)
+			if putErr != nil {
+				receiver.logger.Error("failed to dead-letter event",
+					slog.String("id", id),
+					slog.Any("error", putErr))
+				return errors.Join(err, putErr)
+			}
+			receiver.attempts.forget(id)
+			receiver.logger.Warn("dead-lettered event",
+				slog.String("id", id),
+				slog.Int("attempts", len(attempts)),
+				slog.Any("error", err))
+			return nil
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+func newTestDeadLetterStore(t *testing.T) *FileDeadLetterStore {
+	t.Helper()
+	store, err := NewFileDeadLetterStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileDeadLetterStore() error = %v", err)
+	}
+	return store
+}
+
+func TestDeadLetterAfterMaxAttempts(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	store := newTestDeadLetterStore(t)
+	receiver, handled := newTestPushReceiver(t, transmitter, ReceiverConfig{
+		PushAuthorizationHeader: testPushAuthorization,
+		DeadLetterStore:         store,
+		MaxAttempts:             3,
+	}, map[string]error{"failing": errors.New("database unavailable")})
+	authorized := http.Header{"Authorization": {testPushAuthorization}}
+	set := testSet(t, "failing", nil)
+
+	// The transmitter retries the SET until it is accepted
+	for attempt := 1; attempt < 3; attempt++ {
+		if response := pushSet(receiver.PushHandler(), set, authorized); response.Code != http.StatusInternalServerError {
+			t.Fatalf("attempt %d answered %d, want 500", attempt, response.Code)
+		}
+		if letters, _ := store.List(); len(letters) != 0 {
+			t.Fatalf("attempt %d dead-lettered the event, want it retried", attempt)
+		}
+	}
+
+	// The last attempt is acknowledged, so the transmitter moves on
+	if response := pushSet(receiver.PushHandler(), set, authorized); response.Code != http.StatusAccepted {
+		t.Fatalf("attempt 3 answered %d, want 202", response.Code)
+	}
+	if got := handled(); len(got) != 3 {
+		t.Fatalf("handled %v, want 3 attempts", got)
+	}
+
+	letters, err := receiver.DeadLetters()
+	if err != nil || len(letters) != 1 {
+		t.Fatalf("DeadLetters() = %v, %v, want one dead letter", letters, err)
+	}
+	letter := letters[0]
+	if letter.Id != "failing#"+sessionRevokedUri || letter.JTI != "failing" || letter.EventUri != sessionRevokedUri || letter.SET != set {
+		t.Fatalf("dead letter = %+v, want the failing session revoked event", letter)
+	}
+	if len(letter.Attempts) != 3 || letter.Attempts[2].Error != "database unavailable" {
+		t.Fatalf("dead letter attempts = %+v, want 3 failed attempts", letter.Attempts)
+	}
+	if !letter.FirstFailedAt.Equal(letter.Attempts[0].At) || letter.DeadLetteredAt.Before(letter.Attempts[2].At) {
+		t.Fatalf("dead letter failed at %s and dead-lettered at %s, want the first and last attempts", letter.FirstFailedAt, letter.DeadLetteredAt)
+	}
+	if _, found := letter.Event["subject"]; !found {
+		t.Fatalf("dead letter event = %v, want the members of the event", letter.Event)
+	}
+}
+
+func TestFileDeadLetterStoreList(t *testing.T) {
+	store := newTestDeadLetterStore(t)
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	letter := func(id string, deadLetteredAt time.Duration, errs ...string) DeadLetter {
+		letter := DeadLetter{Id: id, JTI: id, DeadLetteredAt: start.Add(deadLetteredAt)}
+		for i, err := range errs {
+			letter.Attempts = append(letter.Attempts, DeadLetterAttempt{Error: err, At: start.Add(time.Duration(i) * time.Second)})
+		}
+		letter.FirstFailedAt = letter.Attempts[0].At
+		return letter
+	}
+	second := letter("second", 2*time.Minute, "timeout", "timeout")
+	first := letter("first", time.Minute, "refused")
+	third := letter("third", 3*time.Minute, "bad gateway")
+	for _, letter := range []DeadLetter{third, first, second} {
+		if err := store.Put(letter); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+
+	// Files that aren't dead letters are ignored
+	if err := os.WriteFile(filepath.Join(store.dir, "notes.txt"), []byte("notes"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	letters, err := store.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if want := []DeadLetter{first, second, third}; !reflect.DeepEqual(letters, want) {
+		t.Fatalf("List() = %+v, want %+v", letters, want)
+	}
+
+	// Putting a dead letter again replaces it
+	first.Attempts = append(first.Attempts, DeadLetterAttempt{Error: "replay failed", At: start.Add(time.Hour)})
+	if err := store.Put(first); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if got, err := store.Get("first"); err != nil || !reflect.DeepEqual(*got, first) {
+		t.Fatalf("Get() = %+v, %v, want %+v", got, err, first)
+	}
+
+	if err := store.Delete("second"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := store.Delete("second"); err != nil {
+		t.Fatalf("Delete() of a removed dead letter error = %v", err)
+	}
+	if _, err := store.Get("second"); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("Get() error = %v, want ErrDeadLetterNotFound", err)
+	}
+	if letters, _ := store.List(); len(letters) != 2 {
+		t.Fatalf("List() returned %d dead letters after Delete, want 2", len(letters))
+	}
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	store := newTestDeadLetterStore(t)
+	failedAt := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	letter := DeadLetter{
+		Id:             "jti-1#" + sessionRevokedUri,
+		SET:            testSet(t, "jti-1", nil),
+		EventUri:       sessionRevokedUri,
+		JTI:            "jti-1",
+		Issuer:         "https://tr.example.com",
+		Attempts:       []DeadLetterAttempt{{Error: "database unavailable", At: failedAt}},
+		FirstFailedAt:  failedAt,
+		DeadLetteredAt: failedAt,
+	}
+	if err := store.Put(letter); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	var replayed []EventInfo
+	handler := func(err error) Handler {
+		return func(ctx context.Context, event events.SsfEvent) error {
+			info, _ := EventInfoFromContext(ctx)
+			replayed = append(replayed, info)
+			if event.GetEventUri() != sessionRevokedUri {
+				t.Errorf("replayed a %s event, want %s", event.GetEventUri(), sessionRevokedUri)
+			}
+			return err
+		}
+	}
+
+	// A failed replay is added to the history and the letter is kept
+	replayErr := errors.New("still unavailable")
+	err := ReplayDeadLetter(context.Background(), store, letter.Id, handler(replayErr))
+	if !errors.Is(err, replayErr) {
+		t.Fatalf("ReplayDeadLetter() error = %v, want the handler's error", err)
+	}
+	kept, err := store.Get(letter.Id)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(kept.Attempts) != 2 || kept.Attempts[1].Error != "still unavailable" || kept.Attempts[1].At.Before(failedAt) {
+		t.Fatalf("dead letter attempts = %+v, want the failed replay appended", kept.Attempts)
+	}
+	if !kept.FirstFailedAt.Equal(failedAt) {
+		t.Fatalf("dead letter first failed at %s, want %s", kept.FirstFailedAt, failedAt)
+	}
+
+	// A successful replay removes the letter
+	err = ReplayDeadLetter(context.Background(), store, letter.Id, handler(nil))
+	if err != nil {
+		t.Fatalf("ReplayDeadLetter() error = %v", err)
+	}
+	if _, err := store.Get(letter.Id); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("Get() error = %v, want the replayed letter removed", err)
+	}
+	if len(replayed) != 2 || replayed[0].JTI != "jti-1" || replayed[0].Issuer != "https://tr.example.com" {
+		t.Fatalf("replayed %+v, want the EventInfo of the SET twice", replayed)
+	}
+
+	err = ReplayDeadLetter(context.Background(), store, letter.Id, handler(nil))
+	if !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("ReplayDeadLetter() of a removed letter error = %v, want ErrDeadLetterNotFound", err)
+	}
+}
+
+func TestReplayUnparseableDeadLetter(t *testing.T) {
+	store := newTestDeadLetterStore(t)
+	letter := DeadLetter{Id: "malformed", SET: "not a SET", JTI: "malformed", Attempts: []DeadLetterAttempt{{Error: "malformed"}}}
+	if err := store.Put(letter); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	called := false
+	err := ReplayDeadLetter(context.Background(), store, letter.Id, func(ctx context.Context, event events.SsfEvent) error {
+		called = true
+		return nil
+	})
+	if err == nil || called {
+		t.Fatalf("ReplayDeadLetter() = %v with the handler called %v, want an error without calling it", err, called)
+	}
+	if _, err := store.Get(letter.Id); err != nil {
+		t.Fatalf("Get() error = %v, want the letter kept", err)
+	}
+}
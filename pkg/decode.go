@@ -0,0 +1,172 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+// The typ header of SETs, as defined by the SSF specification
+const SetType = "secevent+jwt"
+
+// A SET decoded for inspection by DecodeSet
+type DecodedSet struct {
+	// Header defines the JOSE header of the SET
+	Header map[string]interface{} `json:"header"`
+
+	// Claims defines the claims of the SET
+	Claims map[string]interface{} `json:"claims"`
+
+	// Events defines the events of the SET, ordered by URI
+	Events []DecodedEvent `json:"events"`
+
+	// SignatureVerified reports whether the signature was verified with the
+	// given keys
+	SignatureVerified bool `json:"signature_verified"`
+
+	// SignatureError defines why the signature couldn't be verified
+	SignatureError string `json:"signature_error,omitempty"`
+
+	// Problems describes how the SET violates the SET (RFC 8417) and SSF
+	// specifications, empty when it is valid
+	Problems []string `json:"problems,omitempty"`
+}
+
+// An event of a SET decoded by DecodeSet
+type DecodedEvent struct {
+	// Uri defines the event type URI
+	Uri string `json:"uri"`
+
+	// Attributes defines the raw attributes of the event
+	Attributes map[string]interface{} `json:"attributes"`
+
+	// Event defines the event parsed by events.EventStructFromEvent, nil
+	// when it couldn't be parsed
+	Event events.SsfEvent `json:"-"`
+
+	// Error defines why the event couldn't be parsed
+	Error string `json:"error,omitempty"`
+}
+
+// Decodes a SET for inspection, without rejecting it when it is invalid.
+//
+// The header, claims and events of the SET are returned along with the
+// ways it violates the specifications: missing claims, unknown event URIs,
+// events that can't be parsed and malformed subjects. When keys is not
+// nil the signature is verified with them, otherwise it isn't checked.
+//
+// An error is only returned when the SET isn't a JWT
+func DecodeSet(set string, keys *KeySet) (*DecodedSet, error) {
+	claims := jwt.MapClaims{}
+	token, _, err := jwt.NewParser().ParseUnverified(set, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded := &DecodedSet{Header: token.Header, Claims: claims, Events: []DecodedEvent{}}
+	problem := func(format string, args ...interface{}) {
+		decoded.Problems = append(decoded.Problems, fmt.Sprintf(format, args...))
+	}
+
+	if typ, _ := token.Header["typ"].(string); typ != SetType {
+		problem("typ header is %q, must be %q", typ, SetType)
+	}
+	if token.Method.Alg() == "none" {
+		problem("SET is not signed")
+	}
+
+	if keys != nil {
+		_, err = verifySetSignature(set, func(kid string) (interface{}, error) {
+			key, found := keys.Key(kid)
+			if !found {
+				return nil, fmt.Errorf("no signing key with kid %q", kid)
+			}
+			return key, nil
+		})
+		decoded.SignatureVerified = err == nil
+		if err != nil {
+			decoded.SignatureError = err.Error()
+		}
+	}
+
+	if jti, _ := claims["jti"].(string); jti == "" {
+		problem("jti claim is missing")
+	}
+	if iss, _ := claims["iss"].(string); iss == "" {
+		problem("iss claim is missing")
+	}
+	if _, ok := claims["iat"].(float64); !ok {
+		problem("iat claim is missing or not a number")
+	}
+	if _, found := claims["sub"]; found {
+		problem("sub claim must not be used, the subject belongs in sub_id")
+	}
+	if subId, found := claims["sub_id"]; found {
+		subject, ok := subId.(map[string]interface{})
+		if !ok {
+			problem("sub_id claim is not a subject identifier")
+		} else if err := events.ValidateSubject(subject); err != nil {
+			problem("invalid sub_id: %v", err)
+		}
+	}
+
+	ssfEvents, ok := claims["events"].(map[string]interface{})
+	if !ok {
+		problem("events claim is missing or not an object")
+		return decoded, nil
+	}
+	if len(ssfEvents) != 1 {
+		problem("events claim contains %d events, must contain exactly one", len(ssfEvents))
+	}
+
+	for uri, eventSubject := range ssfEvents {
+		decoded.Events = append(decoded.Events, decodeEvent(uri, eventSubject, claims, problem))
+	}
+	sort.Slice(decoded.Events, func(i, j int) bool { return decoded.Events[i].Uri < decoded.Events[j].Uri })
+	return decoded, nil
+}
+
+// Decodes a single event of a SET, reporting its problems
+func decodeEvent(uri string, eventSubject interface{}, claims map[string]interface{}, problem func(format string, args ...interface{})) DecodedEvent {
+	event := DecodedEvent{Uri: uri}
+	attributes, ok := eventSubject.(map[string]interface{})
+	if !ok {
+		event.Error = "event attributes are not an object"
+		problem("event %s: %s", uri, event.Error)
+		return event
+	}
+	event.Attributes = attributes
+
+	eventType, known := events.EventEnum[uri]
+	if !known {
+		event.Error = "unknown event URI"
+		problem("event %s: %s", uri, event.Error)
+		return event
+	}
+
+	// Verification and stream updated events apply to the stream, the
+	// other events need a subject, either in sub_id or in the event
+	if eventType != events.VerificationEventType && eventType != events.StreamUpdatedEventType {
+		if _, found := claims["sub_id"]; !found {
+			subject, found := attributes["subject"]
+			if !found {
+				problem("event %s: subject is missing, set sub_id", uri)
+			} else if subjectMap, ok := subject.(map[string]interface{}); !ok {
+				problem("event %s: subject is not a subject identifier", uri)
+			} else if err := events.ValidateSubject(subjectMap); err != nil {
+				problem("event %s: invalid subject: %v", uri, err)
+			}
+		}
+	}
+
+	parsed, err := events.EventStructFromEvent(uri, eventSubject, claims)
+	if err != nil {
+		event.Error = err.Error()
+		problem("event %s: %v", uri, err)
+		return event
+	}
+	event.Event = parsed
+	return event
+}
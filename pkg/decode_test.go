@@ -0,0 +1,200 @@
+package pkg
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Returns a SET with the given claims, signed with ES256 and with the SET
+// typ header
+func decodeTestSet(t *testing.T, key *ecdsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = SetType
+	token.Header["kid"] = "key-1"
+	set, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+// Returns the claims of a valid session revoked SET, with the given claims
+// merged in and the claims set to nil removed
+func decodeTestClaims(extra jwt.MapClaims) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"jti": "jti-1",
+		"iss": "https://tr.example.com",
+		"iat": 1700000000,
+		"events": map[string]any{
+			sessionRevokedUri: map[string]any{
+				"subject":         map[string]any{"format": "email", "email": "user@example.com"},
+				"event_timestamp": 1700000000,
+			},
+		},
+	}
+	for name, value := range extra {
+		if value == nil {
+			delete(claims, name)
+			continue
+		}
+		claims[name] = value
+	}
+	return claims
+}
+
+func TestDecodeSetRejectsMalformedSets(t *testing.T) {
+	encode := func(data string) string { return base64.RawURLEncoding.EncodeToString([]byte(data)) }
+	header := encode(`{"alg":"ES256","typ":"secevent+jwt"}`)
+	claims := encode(`{"jti":"jti-1"}`)
+
+	tests := []struct {
+		name string
+		set  string
+	}{
+		{"empty", ""},
+		{"not a JWT", "not a SET"},
+		{"two segments", header + "." + claims},
+		{"four segments", header + "." + claims + ".sig.extra"},
+		{"header not base64", "!!!." + claims + ".sig"},
+		{"header not JSON", encode("header") + "." + claims + ".sig"},
+		{"claims not base64", header + ".!!!.sig"},
+		{"claims not JSON", header + "." + encode("claims") + ".sig"},
+		{"claims not an object", header + "." + encode(`["jti-1"]`) + ".sig"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoded, err := DecodeSet(test.set, nil)
+			if err == nil {
+				t.Fatalf("DecodeSet() = %+v, want an error", decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeSet(t *testing.T) {
+	key := newTestSigningKey(t)
+	keys, err := ParseJwks(testJwks(t, map[string]*ecdsa.PrivateKey{"key-1": key}))
+	if err != nil {
+		t.Fatalf("ParseJwks() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		set          string
+		keys         *KeySet
+		wantProblems []string
+		wantVerified bool
+		wantEvents   int
+	}{
+		{
+			name:       "valid",
+			set:        decodeTestSet(t, key, decodeTestClaims(nil)),
+			wantEvents: 1,
+		},
+		{
+			name:         "valid and verified",
+			set:          decodeTestSet(t, key, decodeTestClaims(nil)),
+			keys:         keys,
+			wantVerified: true,
+			wantEvents:   1,
+		},
+		{
+			name:       "signed with another key",
+			set:        decodeTestSet(t, newTestSigningKey(t), decodeTestClaims(nil)),
+			keys:       keys,
+			wantEvents: 1,
+		},
+		{
+			name:         "unsigned and without typ",
+			set:          testSet(t, "jti-1", jwt.MapClaims{"iss": "https://tr.example.com"}),
+			wantProblems: []string{"typ header", "SET is not signed"},
+			wantEvents:   1,
+		},
+		{
+			name:         "missing claims",
+			set:          decodeTestSet(t, key, decodeTestClaims(jwt.MapClaims{"jti": nil, "iss": nil, "iat": "yesterday"})),
+			wantProblems: []string{"jti claim", "iss claim", "iat claim"},
+			wantEvents:   1,
+		},
+		{
+			name:         "sub claim",
+			set:          decodeTestSet(t, key, decodeTestClaims(jwt.MapClaims{"sub": "user-1"})),
+			wantProblems: []string{"sub claim must not be used"},
+			wantEvents:   1,
+		},
+		{
+			name:         "invalid sub_id",
+			set:          decodeTestSet(t, key, decodeTestClaims(jwt.MapClaims{"sub_id": map[string]any{"format": "email"}})),
+			wantProblems: []string{"invalid sub_id"},
+			wantEvents:   1,
+		},
+		{
+			name:         "sub_id that isn't an object",
+			set:          decodeTestSet(t, key, decodeTestClaims(jwt.MapClaims{"sub_id": "user@example.com"})),
+			wantProblems: []string{"sub_id claim is not a subject identifier"},
+			wantEvents:   1,
+		},
+		{
+			name:         "no events",
+			set:          decodeTestSet(t, key, decodeTestClaims(jwt.MapClaims{"events": nil})),
+			wantProblems: []string{"events claim is missing"},
+		},
+		{
+			name: "two events",
+			set: decodeTestSet(t, key, decodeTestClaims(jwt.MapClaims{"events": map[string]any{
+				sessionRevokedUri:                 map[string]any{"subject": map[string]any{"format": "opaque", "id": "1"}, "event_timestamp": 1700000000},
+				"https://example.com/event/other": map[string]any{},
+			}})),
+			wantProblems: []string{"contains 2 events", "unknown event URI"},
+			wantEvents:   2,
+		},
+		{
+			name:         "event missing its subject",
+			set:          decodeTestSet(t, key, decodeTestClaims(jwt.MapClaims{"events": map[string]any{sessionRevokedUri: map[string]any{"event_timestamp": 1700000000}}})),
+			wantProblems: []string{"subject is missing", "cannot retrieve subject"},
+			wantEvents:   1,
+		},
+		{
+			name:         "event with an invalid subject",
+			set:          decodeTestSet(t, key, decodeTestClaims(jwt.MapClaims{"events": map[string]any{sessionRevokedUri: map[string]any{"subject": map[string]any{"format": "account", "uri": "user"}, "event_timestamp": 1700000000}}})),
+			wantProblems: []string{"invalid subject"},
+			wantEvents:   1,
+		},
+		{
+			name:         "event attributes that aren't an object",
+			set:          decodeTestSet(t, key, decodeTestClaims(jwt.MapClaims{"events": map[string]any{sessionRevokedUri: "revoked"}})),
+			wantProblems: []string{"event attributes are not an object"},
+			wantEvents:   1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoded, err := DecodeSet(test.set, test.keys)
+			if err != nil {
+				t.Fatalf("DecodeSet() error = %v", err)
+			}
+			if len(decoded.Problems) != len(test.wantProblems) {
+				t.Fatalf("DecodeSet() problems = %q, want %d problems", decoded.Problems, len(test.wantProblems))
+			}
+			for i, want := range test.wantProblems {
+				if !strings.Contains(decoded.Problems[i], want) {
+					t.Fatalf("DecodeSet() problem %d = %q, want it to mention %q", i, decoded.Problems[i], want)
+				}
+			}
+			if decoded.SignatureVerified != test.wantVerified {
+				t.Fatalf("DecodeSet() SignatureVerified = %v, want %v", decoded.SignatureVerified, test.wantVerified)
+			}
+			if test.keys != nil && !test.wantVerified && decoded.SignatureError == "" {
+				t.Fatal("DecodeSet() didn't report why the signature couldn't be verified")
+			}
+			if len(decoded.Events) != test.wantEvents {
+				t.Fatalf("DecodeSet() returned %d events, want %d", len(decoded.Events), test.wantEvents)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package pkg
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDedupWindow is how long a processed JTI is remembered by the
+// dedup stores when they are not given a window
+const DefaultDedupWindow = 24 * time.Hour
+
+// Remembers the JTIs of the SETs the receiver has already processed, so
+// SETs redelivered by the transmitter are skipped.
+//
+// Implementations must be safe for concurrent use
+type DedupStore interface {
+	// Reports whether the JTI was marked as processed within the store's
+	// window
+	Seen(jti string) (bool, error)
+
+	// Marks the JTI as processed
+	Mark(jti string) error
+}
+
+// An in-memory DedupStore that remembers JTIs for a fixed window, evicting
+// the least recently marked JTIs once it holds its maximum number of
+// entries
+type MemoryDedupStore struct {
+	window     time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type dedupEntry struct {
+	jti      string
+	markedAt time.Time
+}
+
+// Creates an in-memory dedup store remembering JTIs for the given window.
+// A window of 0 uses DefaultDedupWindow, and a maxEntries of 0 leaves the
+// number of entries unbounded
+func NewMemoryDedupStore(window time.Duration, maxEntries int) *MemoryDedupStore {
+	if window <= 0 {
+		window = DefaultDedupWindow
+	}
+	return &MemoryDedupStore{
+		window:     window,
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+func (store *MemoryDedupStore) Seen(jti string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.expire(time.Now())
+	_, found := store.entries[jti]
+	return found, nil
+}
+
+func (store *MemoryDedupStore) Mark(jti string) error {
+	store.mark(jti, time.Now())
+	return nil
+}
+
+func (store *MemoryDedupStore) mark(jti string, markedAt time.Time) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if element, found := store.entries[jti]; found {
+		store.order.Remove(element)
+	}
+
+	// Entries are kept ordered by when they were marked so expire only has
+	// to look at the front, even when loading entries out of order
+	entry := &dedupEntry{jti: jti, markedAt: markedAt}
+	previous := store.order.Back()
+	for previous != nil && previous.Value.(*dedupEntry).markedAt.After(markedAt) {
+		previous = previous.Prev()
+	}
+	if previous == nil {
+		store.entries[jti] = store.order.PushFront(entry)
+	} else {
+		store.entries[jti] = store.order.InsertAfter(entry, previous)
+	}
+
+	store.expire(time.Now())
+	for store.maxEntries > 0 && store.order.Len() > store.maxEntries {
+		store.remove(store.order.Front())
+	}
+}
+
+// Returns the number of JTIs currently remembered
+func (store *MemoryDedupStore) Len() int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.expire(time.Now())
+	return store.order.Len()
+}
+
+// Removes the entries marked before the window, oldest first
+func (store *MemoryDedupStore) expire(now time.Time) {
+	for element := store.order.Front(); element != nil; element = store.order.Front() {
+		if now.Sub(element.Value.(*dedupEntry).markedAt) < store.window {
+			return
+		}
+		store.remove(element)
+	}
+}
+
+func (store *MemoryDedupStore) remove(element *list.Element) {
+	store.order.Remove(element)
+	delete(store.entries, element.Value.(*dedupEntry).jti)
+}
+
+// Calls fn for every remembered entry, oldest first
+func (store *MemoryDedupStore) each(fn func(entry *dedupEntry)) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.expire(time.Now())
+	for element := store.order.Front(); element != nil; element = element.Next() {
+		fn(element.Value.(*dedupEntry))
+	}
+}
+
+// A DedupStore that persists the processed JTIs to a file, so redelivered
+// SETs are still skipped after the receiver restarts.
+//
+// Marked JTIs are appended to the file, which is compacted once it holds
+// mostly expired entries
+type FileDedupStore struct {
+	path   string
+	memory *MemoryDedupStore
+
+	mu    sync.Mutex
+	file  *os.File
+	lines int
+}
+
+// Opens the file backed dedup store at the given path, creating the file if
+// it doesn't exist. A window of 0 uses DefaultDedupWindow
+func NewFileDedupStore(path string, window time.Duration) (*FileDedupStore, error) {
+	store := &FileDedupStore{path: path, memory: NewMemoryDedupStore(window, 0)}
+
+	err := store.load()
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.compact()
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *FileDedupStore) Seen(jti string) (bool, error) {
+	return store.memory.Seen(jti)
+}
+
+func (store *FileDedupStore) Mark(jti string) error {
+	if strings.ContainsAny(jti, "\t\n") {
+		return fmt.Errorf("cannot store jti %q", jti)
+	}
+
+	markedAt := time.Now()
+	store.memory.mark(jti, markedAt)
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	_, err := fmt.Fprintf(store.file, "%s\t%d\n", jti, markedAt.UnixNano())
+	if err != nil {
+		return err
+	}
+	store.lines++
+
+	if store.lines > 2*store.memory.Len()+1000 {
+		return store.compactLocked()
+	}
+	return nil
+}
+
+// Closes the underlying file
+func (store *FileDedupStore) Close() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.file.Close()
+}
+
+// Reads the entries of the file into memory, skipping malformed lines
+func (store *FileDedupStore) load() error {
+	file, err := os.Open(store.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		jti, rawMarkedAt, found := strings.Cut(scanner.Text(), "\t")
+		if !found {
+			continue
+		}
+		markedAt, err := strconv.ParseInt(rawMarkedAt, 10, 64)
+		if err != nil {
+			continue
+		}
+		store.memory.mark(jti, time.Unix(0, markedAt))
+	}
+	return scanner.Err()
+}
+
+func (store *FileDedupStore) compact() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.compactLocked()
+}
+
+// Rewrites the file with only the entries still within the window, and
+// atomically replaces the old file with it
+func (store *FileDedupStore) compactLocked() error {
+	tmp, err := os.CreateTemp(filepath.Dir(store.path), filepath.Base(store.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	lines := 0
+	store.memory.each(func(entry *dedupEntry) {
+		fmt.Fprintf(writer, "%s\t%d\n", entry.jti, entry.markedAt.UnixNano())
+		lines++
+	})
+
+	err = writer.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), store.path)
+	if err != nil {
+		return err
+	}
+
+	if store.file != nil {
+		store.file.Close()
+	}
+	store.file, err = os.OpenFile(store.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	store.lines = lines
+	return nil
+}
+
+// Reports whether the receiver already processed the SET with the given
+// JTI. Errors from the dedup store are treated as not seen, so events are
+// never dropped because the store is unavailable
+func (receiver *SsfReceiverImplementation) isDuplicate(jti string) bool {
+	if receiver.dedup == nil || jti == "" {
+		return false
+	}
+	seen, err := receiver.dedup.Seen(jti)
+	return err == nil && seen
+}
+
+// Records that the receiver processed the SET with the given JTI
+func (receiver *SsfReceiverImplementation) markProcessed(jti string) {
+	if receiver.dedup == nil || jti == "" {
+		return
+	}
+	receiver.dedup.Mark(jti)
+}
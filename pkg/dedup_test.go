@@ -0,0 +1,147 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryDedupStoreExpiry(t *testing.T) {
+	tests := []struct {
+		name     string
+		markedAt time.Duration
+		want     bool
+	}{
+		{"just marked", 0, true},
+		{"within window", -59 * time.Minute, true},
+		{"at window", -time.Hour, false},
+		{"past window", -2 * time.Hour, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := NewMemoryDedupStore(time.Hour, 0)
+			store.mark("jti-1", time.Now().Add(test.markedAt))
+
+			seen, err := store.Seen("jti-1")
+			if err != nil {
+				t.Fatalf("Seen() error = %v", err)
+			}
+			if seen != test.want {
+				t.Fatalf("Seen() = %t, want %t", seen, test.want)
+			}
+		})
+	}
+}
+
+func TestMemoryDedupStoreExpiresOldestFirst(t *testing.T) {
+	store := NewMemoryDedupStore(time.Hour, 0)
+	now := time.Now()
+	store.mark("old", now.Add(-2*time.Hour))
+	store.mark("older-remarked", now.Add(-3*time.Hour))
+	store.mark("older-remarked", now)
+	store.mark("recent", now.Add(-time.Minute))
+
+	if store.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", store.Len())
+	}
+	for jti, want := range map[string]bool{"old": false, "older-remarked": true, "recent": true} {
+		if seen, _ := store.Seen(jti); seen != want {
+			t.Fatalf("Seen(%q) = %t, want %t", jti, seen, want)
+		}
+	}
+}
+
+func TestMemoryDedupStoreEvictsBeyondMaxEntries(t *testing.T) {
+	store := NewMemoryDedupStore(time.Hour, 2)
+	store.Mark("a")
+	store.Mark("b")
+	store.Mark("c")
+
+	if store.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", store.Len())
+	}
+	if seen, _ := store.Seen("a"); seen {
+		t.Fatal("least recently marked jti was not evicted")
+	}
+	if seen, _ := store.Seen("c"); !seen {
+		t.Fatal("most recently marked jti was evicted")
+	}
+}
+
+func TestFileDedupStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup")
+	store, err := NewFileDedupStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileDedupStore() error = %v", err)
+	}
+	if err := store.Mark("jti-1"); err != nil {
+		t.Fatalf("Mark() error = %v", err)
+	}
+	if err := store.Mark("bad\tjti"); err == nil {
+		t.Fatal("Mark() accepted a jti with a tab")
+	}
+	store.Close()
+
+	// An expired entry and a malformed line are dropped when reopening
+	expired := time.Now().Add(-2 * time.Hour).UnixNano()
+	appendLine(t, path, "expired\t"+strconv.FormatInt(expired, 10))
+	appendLine(t, path, "malformed")
+
+	store, err = NewFileDedupStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileDedupStore() error = %v", err)
+	}
+	defer store.Close()
+
+	for jti, want := range map[string]bool{"jti-1": true, "expired": false, "malformed": false} {
+		if seen, _ := store.Seen(jti); seen != want {
+			t.Fatalf("Seen(%q) = %t, want %t", jti, seen, want)
+		}
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(contents), "\n"); lines != 1 {
+		t.Fatalf("compacted file has %d lines, want 1:\n%s", lines, contents)
+	}
+}
+
+func appendLine(t *testing.T, path string, line string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	_, err = file.WriteString(line + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPollSkipsRedeliveredSets(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	receiver := newTestReceiver(t, transmitter, ReceiverConfig{DedupStore: NewMemoryDedupStore(time.Hour, 0)})
+	set := testSet(t, "jti-1", nil)
+	transmitter.add("jti-1", set)
+
+	response, err := receiver.Poll()
+	if err != nil || len(response.Events) != 1 {
+		t.Fatalf("Poll() = %v, %v, want one event", response, err)
+	}
+
+	// The transmitter redelivers the SET, e.g. because the ack was lost
+	transmitter.add("jti-1", set)
+	response, err = receiver.Poll()
+	if err != nil || len(response.Events) != 0 {
+		t.Fatalf("Poll() = %v, %v, want no events", response, err)
+	}
+	if acks := transmitter.acknowledged(); len(acks) != 2 {
+		t.Fatalf("acknowledged %v, want the redelivered SET acknowledged again", acks)
+	}
+}
@@ -0,0 +1,295 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+// DefaultDeliveryBufferSize is the capacity of the channel returned by
+// Events when the receiver is not configured with a DeliveryBufferSize
+const DefaultDeliveryBufferSize = 100
+
+// Represents a single SSF Event delivered on the channel returned by Events.
+//
+// Every Delivery must be settled exactly once with Ack or Nack. A SET is
+// acknowledged with the transmitter once every event it contains has been
+// acknowledged; acknowledgements are sent with the next poll request
+type Delivery struct {
+	// Event defines the received SSF Event
+	Event events.SsfEvent
+
+	// JTI defines the unique id of the SET that contained the event
+	JTI string
+
+	info     EventInfo
+	set      string
+	traceCtx context.Context
+	state    *deliveryState
+}
+
+// Tracks whether a single Delivery has been settled
+type deliveryState struct {
+	set  *deliveredSet
+	once sync.Once
+}
+
+// Tracks the deliveries of the events contained in a single SET
+type deliveredSet struct {
+	receiver  *SsfReceiverImplementation
+	jti       string
+	mu        sync.Mutex
+	remaining int
+	failed    bool
+
+	// done, when set, receives the outcome of a pushed SET, which is
+	// answered by the push handler instead of acknowledged with the next
+	// poll request
+	done chan error
+}
+
+// Marks the event as processed. The SET is acknowledged with the
+// transmitter once all of its events have been acknowledged
+func (delivery Delivery) Ack() {
+	if delivery.state == nil {
+		return
+	}
+	delivery.state.once.Do(func() {
+		delivery.state.set.settle(nil)
+	})
+}
+
+// Marks the event as failed, so its SET is not acknowledged.
+//
+// If err is a *SetError the SET is reported to the transmitter as rejected
+// with that error, and will not be redelivered. Otherwise the SET is left
+// unacknowledged and the transmitter will redeliver it
+func (delivery Delivery) Nack(err error) {
+	if delivery.state == nil {
+		return
+	}
+	delivery.state.once.Do(func() {
+		if err == nil {
+			err = errors.New("delivery was not acknowledged")
+		}
+		delivery.state.set.settle(err)
+	})
+}
+
+func (set *deliveredSet) settle(err error) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	if set.failed {
+		return
+	}
+
+	if err != nil {
+		set.failed = true
+		if set.done != nil {
+			set.done <- err
+			return
+		}
+		var setErr *SetError
+		if errors.As(err, &setErr) {
+			set.receiver.queueSetError(set.jti, *setErr)
+		}
+		return
+	}
+
+	set.remaining--
+	if set.remaining == 0 && set.done != nil {
+		set.done <- nil
+	} else if set.remaining == 0 {
+		set.receiver.markProcessed(set.jti)
+		set.receiver.queueAck(set.jti)
+	}
+}
+
+// Returns a channel that delivers the received SSF Events one at a time.
+//
+// The channel is buffered; once it is full the poll loop blocks until
+// consumers catch up, so no more events are polled than can be handled.
+// The SETs of each poll response are delivered in the order they were
+// issued; once several goroutines consume the channel, events may be
+// handled out of that order.
+// Several goroutines can consume from the channel. Polling is started if it
+// isn't running yet, and from then on events are no longer passed to the
+// poll callback.
+//
+// A push receiver without a Handler delivers the events of each pushed SET
+// on the channel instead, and answers the transmitter once they have all
+// been settled
+func (receiver *SsfReceiverImplementation) Events() <-chan Delivery {
+	receiver.mu.Lock()
+	if receiver.deliveries == nil {
+		receiver.deliveries = make(chan Delivery, receiver.deliveryBufferSize)
+	}
+	deliveries := receiver.deliveries
+	receiver.mu.Unlock()
+
+	receiver.InitPollInterval()
+	return deliveries
+}
+
+// Returns the channel returned by Events, or nil if Events hasn't been
+// called
+func (receiver *SsfReceiverImplementation) deliveryChannel() chan Delivery {
+	receiver.mu.RLock()
+	defer receiver.mu.RUnlock()
+	return receiver.deliveries
+}
+
+// Sends the events of every SET in the batch on the deliveries channel,
+// blocking while the channel is full. SETs that were already processed are
+// acknowledged without being delivered again, SETs that cannot be parsed
+// are reported to the transmitter as invalid, and SETs that can't be
+// verified because the signing keys are unavailable are left
+// unacknowledged.
+//
+// The SETs of a batch are delivered in the order they were issued, see
+// orderedSets, and the events of a SET in the order they appear in it
+func (receiver *SsfReceiverImplementation) deliver(ctx context.Context, deliveries chan Delivery, batch *pollBatch) error {
+	for _, jti := range orderedSets(batch.sets) {
+		rawSet := batch.sets[jti]
+		if receiver.isDuplicate(jti) {
+			receiver.queueAck(jti)
+			continue
+		}
+
+		info, ssfEvents, err := receiver.parseSet(ctx, jti, rawSet, "poll")
+		if errors.Is(err, ErrSigningKeysUnavailable) {
+			// Left unacknowledged, so it is redelivered
+			continue
+		}
+		if err != nil {
+			receiver.queueSetError(jti, setErrorFor(err))
+			continue
+		}
+
+		if len(ssfEvents) == 0 {
+			receiver.queueAck(jti)
+			continue
+		}
+
+		// Handlers continue the poll cycle's trace, but aren't cancelled
+		// when the poll loop stops
+		traceCtx := context.WithoutCancel(ctx)
+		set := &deliveredSet{receiver: receiver, jti: jti, remaining: len(ssfEvents)}
+		for _, ssfEvent := range ssfEvents {
+			delivery := Delivery{Event: ssfEvent, JTI: jti, info: info, set: rawSet, traceCtx: traceCtx, state: &deliveryState{set: set}}
+			select {
+			case deliveries <- delivery:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// Sends the events of a pushed SET on the deliveries channel and waits for
+// them to be settled. Returns the error of the first Nack, or ctx's error
+// when the transmitter gives up on the request first
+func (receiver *SsfReceiverImplementation) deliverPushed(ctx context.Context, deliveries chan Delivery, info EventInfo, rawSet string, ssfEvents []events.SsfEvent) error {
+	if len(ssfEvents) == 0 {
+		return nil
+	}
+
+	traceCtx := context.WithoutCancel(ctx)
+	set := &deliveredSet{receiver: receiver, jti: info.JTI, remaining: len(ssfEvents), done: make(chan error, 1)}
+	for _, ssfEvent := range ssfEvents {
+		delivery := Delivery{Event: ssfEvent, JTI: info.JTI, info: info, set: rawSet, traceCtx: traceCtx, state: &deliveryState{set: set}}
+		select {
+		case deliveries <- delivery:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	select {
+	case err := <-set.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Returns the JTIs of the given SETs ordered by their iat claim, and by JTI
+// for SETs issued at the same time. The transmitter returns a batch as a
+// map, so this is the only ordering a receiver can rely on. SETs without a
+// readable iat claim come first
+func orderedSets(sets map[string]string) []string {
+	type orderedSet struct {
+		jti      string
+		issuedAt float64
+	}
+
+	ordered := make([]orderedSet, 0, len(sets))
+	for jti, set := range sets {
+		entry := orderedSet{jti: jti}
+		if claims, err := decodeSet(set); err == nil {
+			entry.issuedAt, _ = claims["iat"].(float64)
+		}
+		ordered = append(ordered, entry)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].issuedAt != ordered[j].issuedAt {
+			return ordered[i].issuedAt < ordered[j].issuedAt
+		}
+		return ordered[i].jti < ordered[j].jti
+	})
+
+	jtis := make([]string, len(ordered))
+	for i, entry := range ordered {
+		jtis[i] = entry.jti
+	}
+	return jtis
+}
+
+// Queues the acknowledgement of a SET to be sent with the next poll request
+func (receiver *SsfReceiverImplementation) queueAck(jti string) {
+	receiver.ackMu.Lock()
+	defer receiver.ackMu.Unlock()
+	receiver.pendingAcks = append(receiver.pendingAcks, jti)
+}
+
+// Queues the rejection of a SET to be sent with the next poll request
+func (receiver *SsfReceiverImplementation) queueSetError(jti string, setErr SetError) {
+	receiver.ackMu.Lock()
+	defer receiver.ackMu.Unlock()
+	if receiver.pendingSetErrors == nil {
+		receiver.pendingSetErrors = map[string]SetError{}
+	}
+	receiver.pendingSetErrors[jti] = setErr
+}
+
+// Returns and clears the pending acknowledgements and SET errors
+func (receiver *SsfReceiverImplementation) takePendingAcks() ([]string, map[string]SetError) {
+	receiver.ackMu.Lock()
+	defer receiver.ackMu.Unlock()
+
+	acks, setErrors := receiver.pendingAcks, receiver.pendingSetErrors
+	receiver.pendingAcks, receiver.pendingSetErrors = nil, nil
+	if acks == nil {
+		acks = []string{}
+	}
+	return acks, setErrors
+}
+
+// Puts back acknowledgements and SET errors that could not be sent
+func (receiver *SsfReceiverImplementation) restorePendingAcks(acks []string, setErrors map[string]SetError) {
+	receiver.ackMu.Lock()
+	defer receiver.ackMu.Unlock()
+
+	receiver.pendingAcks = append(receiver.pendingAcks, acks...)
+	for jti, setErr := range setErrors {
+		if receiver.pendingSetErrors == nil {
+			receiver.pendingSetErrors = map[string]SetError{}
+		}
+		receiver.pendingSetErrors[jti] = setErr
+	}
+}
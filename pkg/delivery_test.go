@@ -0,0 +1,193 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestOrderedSets(t *testing.T) {
+	tests := []struct {
+		name string
+		sets map[string]string
+		want []string
+	}{
+		{"empty", map[string]string{}, []string{}},
+		{
+			"by issued at",
+			map[string]string{
+				"c": testSet(t, "c", jwt.MapClaims{"iat": 100}),
+				"a": testSet(t, "a", jwt.MapClaims{"iat": 300}),
+				"b": testSet(t, "b", jwt.MapClaims{"iat": 200}),
+			},
+			[]string{"c", "b", "a"},
+		},
+		{
+			"same issued at by jti",
+			map[string]string{
+				"b": testSet(t, "b", jwt.MapClaims{"iat": 100}),
+				"a": testSet(t, "a", jwt.MapClaims{"iat": 100}),
+				"c": testSet(t, "c", jwt.MapClaims{"iat": 50}),
+			},
+			[]string{"c", "a", "b"},
+		},
+		{
+			"unreadable first",
+			map[string]string{
+				"a": testSet(t, "a", jwt.MapClaims{"iat": 100}),
+				"z": "not a SET",
+			},
+			[]string{"z", "a"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := orderedSets(test.sets); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("orderedSets() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDeliverInIssueOrder(t *testing.T) {
+	receiver := newTestReceiver(t, newFakeTransmitter(t), ReceiverConfig{})
+	batch := &pollBatch{sets: map[string]string{}}
+	want := []string{"first", "second", "third", "fourth"}
+	for i, jti := range want {
+		batch.sets[jti] = testSet(t, jti, jwt.MapClaims{"iat": 1000 + i})
+	}
+
+	deliveries := make(chan Delivery, len(want))
+	err := receiver.deliver(context.Background(), deliveries, batch)
+	if err != nil {
+		t.Fatalf("deliver() error = %v", err)
+	}
+	close(deliveries)
+
+	var got []string
+	for delivery := range deliveries {
+		got = append(got, delivery.JTI)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("delivered %v, want %v", got, want)
+	}
+}
+
+// Receives the next delivery, failing the test if none arrives in time
+func nextDelivery(t *testing.T, deliveries <-chan Delivery) Delivery {
+	t.Helper()
+	select {
+	case delivery := <-deliveries:
+		return delivery
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a delivery")
+		return Delivery{}
+	}
+}
+
+// Pushes a SET to the handler the way a transmitter does, and returns the
+// response
+func pushSet(handler http.Handler, set string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/ssf/push", strings.NewReader(set))
+	req.Header.Set("Content-Type", "application/secevent+jwt")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestEventsAckAndNack(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	receiver := newTestReceiver(t, transmitter, ReceiverConfig{})
+	transmitter.add("acked", testSet(t, "acked", jwt.MapClaims{"iat": 100}))
+	transmitter.add("retried", testSet(t, "retried", jwt.MapClaims{"iat": 200}))
+	transmitter.add("rejected", testSet(t, "rejected", jwt.MapClaims{"iat": 300}))
+
+	deliveries := receiver.Events()
+	for _, want := range []string{"acked", "retried", "rejected"} {
+		delivery := nextDelivery(t, deliveries)
+		if delivery.JTI != want {
+			t.Fatalf("delivered %s, want %s", delivery.JTI, want)
+		}
+		switch delivery.JTI {
+		case "acked":
+			delivery.Ack()
+			// Settling twice has no effect
+			delivery.Nack(errors.New("ignored"))
+		case "retried":
+			delivery.Nack(errors.New("database unavailable"))
+		case "rejected":
+			delivery.Nack(&SetError{Err: "invalid_request", Description: "unsupported subject"})
+		}
+	}
+
+	// The acknowledgements are sent when the receiver shuts down
+	if err := receiver.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if acks := transmitter.acknowledged(); !reflect.DeepEqual(acks, []string{"acked"}) {
+		t.Fatalf("acknowledged %v, want [acked]", acks)
+	}
+	rejected := transmitter.rejected()
+	if len(rejected) != 1 || rejected["rejected"].Description != "unsupported subject" {
+		t.Fatalf("rejected %v, want only the SET nacked with a SetError", rejected)
+	}
+}
+
+func TestEventsOnPushReceiver(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	receiver := newTestReceiver(t, transmitter, ReceiverConfig{PushEndpointUrl: "https://receiver.example.com/ssf/push"})
+	deliveries := receiver.Events()
+
+	tests := []struct {
+		name       string
+		settle     func(Delivery)
+		wantStatus int
+		wantErr    string
+	}{
+		{"ack", func(delivery Delivery) { delivery.Ack() }, http.StatusAccepted, ""},
+		{"nack", func(delivery Delivery) { delivery.Nack(errors.New("database unavailable")) }, http.StatusInternalServerError, ""},
+		{"nack with a SetError", func(delivery Delivery) {
+			delivery.Nack(&SetError{Err: "invalid_request", Description: "unsupported subject"})
+		}, http.StatusBadRequest, "invalid_request"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jti := strings.ReplaceAll(test.name, " ", "-")
+			responses := make(chan *httptest.ResponseRecorder, 1)
+			go func() { responses <- pushSet(receiver.PushHandler(), testSet(t, jti, nil), nil) }()
+
+			delivery := nextDelivery(t, deliveries)
+			if delivery.JTI != jti {
+				t.Fatalf("delivered %s, want %s", delivery.JTI, jti)
+			}
+			test.settle(delivery)
+
+			response := <-responses
+			if response.Code != test.wantStatus {
+				t.Fatalf("push answered %d, want %d", response.Code, test.wantStatus)
+			}
+			if test.wantErr != "" {
+				var setErr SetError
+				json.Unmarshal(response.Body.Bytes(), &setErr)
+				if setErr.Err != test.wantErr {
+					t.Fatalf("push answered %s, want err %s", response.Body, test.wantErr)
+				}
+			}
+		})
+	}
+
+	if polls := transmitter.streamRequests("poll"); polls != 0 {
+		t.Fatalf("push receiver polled the transmitter %d times", polls)
+	}
+}
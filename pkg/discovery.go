@@ -0,0 +1,134 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultRequestTimeout is how long a request to the transmitter may take
+// when the receiver is not configured with a RequestTimeout
+const DefaultRequestTimeout = 30 * time.Second
+
+// Fetches the Transmitter Configuration Metadata for the given issuer.
+//
+// The request is bounded by DefaultRequestTimeout unless ctx has a deadline.
+//
+// The metadata url is built following the SSF discovery rules: the well-known
+// path is inserted between the host (including any port) and the path of the
+// issuer, after removing any terminating "/" from the path. Both "https://"
+// and "http://" issuers are accepted, the latter being useful for local
+// development transmitters.
+//
+// Returns a *TransmitterError if the transmitter does not answer with a 200,
+// or an error if the issuer in the returned metadata does not match the
+// requested issuer
+func DiscoverTransmitter(ctx context.Context, issuer string) (*TransmitterConfig, error) {
+	transmitterCfg, _, err := fetchTransmitterConfig(ctx, issuer, "", 0)
+	return transmitterCfg, err
+}
+
+// Bounds ctx by the timeout, or DefaultRequestTimeout when it is 0, unless
+// ctx already has a deadline, so a transmitter that never answers can't
+// block the caller forever
+func withRequestTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, found := ctx.Deadline(); found {
+		return context.WithCancel(ctx)
+	}
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Makes the Transmitter Configuration Metadata request for the given issuer.
+// If etag is set it is sent as If-None-Match, and a 304 response is reported
+// by returning a nil config along with the response headers. The request
+// is bounded by timeout, see withRequestTimeout
+func fetchTransmitterConfig(ctx context.Context, issuer string, etag string, timeout time.Duration) (*TransmitterConfig, http.Header, error) {
+	metadataUrl, err := transmitterConfigUrl(issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := withRequestTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", metadataUrl, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if etag != "" && response.StatusCode == http.StatusNotModified {
+		return nil, response.Header, nil
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, nil, newTransmitterError(response, body)
+	}
+
+	var transmitterCfg TransmitterConfig
+	err = json.Unmarshal(body, &transmitterCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !issuersMatch(transmitterCfg.Issuer, issuer) {
+		return nil, nil, fmt.Errorf("transmitter configuration issuer %q does not match requested issuer %q", transmitterCfg.Issuer, issuer)
+	}
+
+	return &transmitterCfg, response.Header, nil
+}
+
+// Builds the Transmitter Configuration Metadata url for the given issuer,
+// e.g. https://example.com/issuer1 becomes
+// https://example.com/.well-known/ssf-configuration/issuer1
+func transmitterConfigUrl(issuer string) (string, error) {
+	issuerUrl, err := url.Parse(issuer)
+	if err != nil {
+		return "", err
+	}
+
+	if issuerUrl.Scheme != "https" && issuerUrl.Scheme != "http" {
+		return "", fmt.Errorf("transmitter issuer %q must use the https or http scheme", issuer)
+	}
+
+	if issuerUrl.Host == "" {
+		return "", fmt.Errorf("transmitter issuer %q is missing a host", issuer)
+	}
+
+	if issuerUrl.RawQuery != "" || issuerUrl.Fragment != "" {
+		return "", errors.New("transmitter issuer must not contain a query or fragment")
+	}
+
+	path := strings.TrimSuffix(issuerUrl.EscapedPath(), "/")
+	return issuerUrl.Scheme + "://" + issuerUrl.Host + TransmitterConfigMetadataPath + path, nil
+}
+
+// Compares the issuer returned in the transmitter's metadata with the
+// issuer the receiver was configured with. A single terminating "/" is
+// ignored on both sides
+func issuersMatch(returned string, requested string) bool {
+	return strings.TrimSuffix(returned, "/") == strings.TrimSuffix(requested, "/")
+}
@@ -0,0 +1,169 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+func TestConfigureSsfReceiverTimesOutOnHangingTransmitter(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	start := time.Now()
+	_, err := ConfigureSsfReceiver(ReceiverConfig{
+		TransmitterUrl:     server.URL,
+		TransmitterPollUrl: server.URL + "/poll",
+		EventsRequested:    []events.EventType{events.SessionRevoked},
+		AuthorizationToken: "token",
+		RequestTimeout:     100 * time.Millisecond,
+	})
+	if err == nil {
+		t.Fatal("expected discovery to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("discovery took %s, expected it to time out after RequestTimeout", elapsed)
+	}
+}
+
+func TestWithRequestTimeoutKeepsExistingDeadline(t *testing.T) {
+	tests := []struct {
+		name     string
+		deadline time.Duration
+		timeout  time.Duration
+		want     time.Duration
+	}{
+		{"no deadline uses the timeout", 0, time.Second, time.Second},
+		{"no deadline defaults", 0, 0, DefaultRequestTimeout},
+		{"existing deadline is kept", time.Minute, time.Second, time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.deadline > 0 {
+				var cancel func()
+				ctx, cancel = context.WithTimeout(ctx, test.deadline)
+				defer cancel()
+			}
+			ctx, cancel := withRequestTimeout(ctx, test.timeout)
+			defer cancel()
+
+			deadline, found := ctx.Deadline()
+			if !found {
+				t.Fatal("expected a deadline")
+			}
+			if remaining := time.Until(deadline); remaining > test.want || remaining < test.want-time.Second {
+				t.Fatalf("deadline in %s, want about %s", remaining, test.want)
+			}
+		})
+	}
+}
+
+func TestTransmitterConfigUrl(t *testing.T) {
+	tests := []struct {
+		name    string
+		issuer  string
+		want    string
+		wantErr bool
+	}{
+		{"host", "https://tr.example.com", "https://tr.example.com/.well-known/ssf-configuration", false},
+		{"trailing slash", "https://tr.example.com/", "https://tr.example.com/.well-known/ssf-configuration", false},
+		{"path", "https://tr.example.com/issuer1", "https://tr.example.com/.well-known/ssf-configuration/issuer1", false},
+		{"path with trailing slash", "https://tr.example.com/tenants/a/", "https://tr.example.com/.well-known/ssf-configuration/tenants/a", false},
+		{"port", "http://localhost:8080/ssf", "http://localhost:8080/.well-known/ssf-configuration/ssf", false},
+		{"escaped path", "https://tr.example.com/a%20b", "https://tr.example.com/.well-known/ssf-configuration/a%20b", false},
+		{"unsupported scheme", "ftp://tr.example.com", "", true},
+		{"missing host", "https:///issuer", "", true},
+		{"query", "https://tr.example.com?tenant=a", "", true},
+		{"fragment", "https://tr.example.com#a", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := transmitterConfigUrl(test.issuer)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("transmitterConfigUrl(%q) error = %v, wantErr %v", test.issuer, err, test.wantErr)
+			}
+			if got != test.want {
+				t.Fatalf("transmitterConfigUrl(%q) = %q, want %q", test.issuer, got, test.want)
+			}
+		})
+	}
+}
+
+// Serves the given status and metadata at the well-known path of the
+// issuer path, and records the paths requested
+func newMetadataServer(t *testing.T, issuerPath string, status int, metadata func(base string) TransmitterConfig) (*httptest.Server, *[]string) {
+	t.Helper()
+	var requested []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		if r.URL.Path != TransmitterConfigMetadataPath+issuerPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if status != http.StatusOK {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error":"temporarily_unavailable","error_description":"try later"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(metadata(server.URL))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requested
+}
+
+func TestDiscoverTransmitter(t *testing.T) {
+	t.Run("issuer with a path", func(t *testing.T) {
+		server, requested := newMetadataServer(t, "/tenants/a", http.StatusOK, func(base string) TransmitterConfig {
+			return TransmitterConfig{Issuer: base + "/tenants/a", JwksUri: base + "/jwks"}
+		})
+		config, err := DiscoverTransmitter(context.Background(), server.URL+"/tenants/a/")
+		if err != nil {
+			t.Fatalf("DiscoverTransmitter() error = %v", err)
+		}
+		if config.JwksUri != server.URL+"/jwks" {
+			t.Fatalf("DiscoverTransmitter() JwksUri = %q, want %q", config.JwksUri, server.URL+"/jwks")
+		}
+		if want := TransmitterConfigMetadataPath + "/tenants/a"; len(*requested) != 1 || (*requested)[0] != want {
+			t.Fatalf("requested %v, want [%s]", *requested, want)
+		}
+	})
+
+	t.Run("issuer mismatch", func(t *testing.T) {
+		server, _ := newMetadataServer(t, "", http.StatusOK, func(base string) TransmitterConfig {
+			return TransmitterConfig{Issuer: "https://attacker.example.com"}
+		})
+		_, err := DiscoverTransmitter(context.Background(), server.URL)
+		if err == nil {
+			t.Fatal("DiscoverTransmitter() accepted metadata for another issuer")
+		}
+		var transmitterErr *TransmitterError
+		if errors.As(err, &transmitterErr) {
+			t.Fatalf("DiscoverTransmitter() error = %v, want an issuer mismatch", err)
+		}
+	})
+
+	t.Run("non 200 status", func(t *testing.T) {
+		server, _ := newMetadataServer(t, "", http.StatusServiceUnavailable, nil)
+		_, err := DiscoverTransmitter(context.Background(), server.URL)
+		var transmitterErr *TransmitterError
+		if !errors.As(err, &transmitterErr) {
+			t.Fatalf("DiscoverTransmitter() error = %v, want a *TransmitterError", err)
+		}
+		if transmitterErr.StatusCode != http.StatusServiceUnavailable || transmitterErr.ErrorCode != "temporarily_unavailable" {
+			t.Fatalf("DiscoverTransmitter() error = %+v, want a 503 temporarily_unavailable", transmitterErr)
+		}
+	})
+}
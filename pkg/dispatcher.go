@@ -0,0 +1,129 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"runtime/debug"
+	"sync"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+// DefaultHandlerWorkers is the number of workers the receiver dispatches
+// events to when it is not configured with HandlerWorkers
+const DefaultHandlerWorkers = 4
+
+// Handles a single SSF Event. Returning an error reports the event as failed
+type Handler func(ctx context.Context, event events.SsfEvent) error
+
+// Returned in place of a handler's error when the handler panicked
+type PanicError struct {
+	// Value defines the value the handler panicked with
+	Value interface{}
+
+	// Stack defines the stack trace of the panicking goroutine
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// Dispatches deliveries to a handler on a pool of workers.
+//
+// Deliveries are assigned to workers by hashing the event's subject, so
+// events for the same subject are handled one at a time in the order they
+// were dispatched, while events for different subjects are handled in
+// parallel. Deliveries are acknowledged when the handler succeeds and
+// Nack'ed with the handler's error when it fails or panics
+type Dispatcher struct {
+	handler Handler
+	queues  []chan Delivery
+	wg      sync.WaitGroup
+}
+
+// Creates a dispatcher with the given number of workers, each buffering up
+// to queueSize deliveries, and starts its workers
+func NewDispatcher(workers int, queueSize int, handler Handler) *Dispatcher {
+	if workers <= 0 {
+		workers = DefaultHandlerWorkers
+	}
+
+	dispatcher := &Dispatcher{handler: handler, queues: make([]chan Delivery, workers)}
+	for i := range dispatcher.queues {
+		dispatcher.queues[i] = make(chan Delivery, queueSize)
+		dispatcher.wg.Add(1)
+		go dispatcher.work(dispatcher.queues[i])
+	}
+	return dispatcher
+}
+
+// Queues the delivery on the worker responsible for its subject, blocking
+// while that worker's queue is full
+func (dispatcher *Dispatcher) Dispatch(ctx context.Context, delivery Delivery) error {
+	queue := dispatcher.queues[subjectHash(delivery.Event)%uint32(len(dispatcher.queues))]
+	select {
+	case queue <- delivery:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stops accepting deliveries and waits for the queued ones to be handled
+func (dispatcher *Dispatcher) Close() {
+	for _, queue := range dispatcher.queues {
+		close(queue)
+	}
+	dispatcher.wg.Wait()
+}
+
+func (dispatcher *Dispatcher) work(queue chan Delivery) {
+	defer dispatcher.wg.Done()
+
+	for delivery := range queue {
+		ctx := context.Background()
+		if delivery.traceCtx != nil {
+			ctx = delivery.traceCtx
+		}
+		ctx = ContextWithEventInfo(contextWithSet(ctx, delivery.set), delivery.info)
+		err := callHandler(ctx, dispatcher.handler, delivery.Event)
+		if err != nil {
+			delivery.Nack(err)
+		} else {
+			delivery.Ack()
+		}
+	}
+}
+
+// Calls the handler, converting a panic into a *PanicError
+func callHandler(ctx context.Context, handler Handler, event events.SsfEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return handler(ctx, event)
+}
+
+// Hashes the subject of the event, events without a subject all share the
+// same hash
+func subjectHash(event events.SsfEvent) uint32 {
+	hash := fnv.New32a()
+	if event != nil {
+		// Map keys are sorted when marshalled, so equal subjects hash equally
+		subject, _ := json.Marshal(event.GetSubject())
+		hash.Write(subject)
+	}
+	return hash.Sum32()
+}
+
+// Dispatches every delivery received on the channel to the receiver's
+// dispatcher
+func (receiver *SsfReceiverImplementation) dispatchDeliveries(deliveries <-chan Delivery) {
+	for delivery := range deliveries {
+		receiver.dispatcher.Dispatch(context.Background(), delivery)
+	}
+}
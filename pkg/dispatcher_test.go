@@ -0,0 +1,124 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+func testEvent(email string, timestamp int64) events.SsfEvent {
+	return &events.SessionRevokedEvent{
+		Format:         events.Email,
+		Subject:        map[string]interface{}{"format": "email", "email": email},
+		EventTimestamp: timestamp,
+	}
+}
+
+// Returns a delivery of the event whose settlement is recorded on receiver
+func testDelivery(receiver *SsfReceiverImplementation, jti string, event events.SsfEvent) Delivery {
+	set := &deliveredSet{receiver: receiver, jti: jti, remaining: 1}
+	return Delivery{Event: event, JTI: jti, state: &deliveryState{set: set}}
+}
+
+func TestDispatcherKeepsPerSubjectOrder(t *testing.T) {
+	var mu sync.Mutex
+	handled := map[string][]int64{}
+	dispatcher := NewDispatcher(4, 10, func(ctx context.Context, event events.SsfEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		email := event.GetSubject()["email"].(string)
+		handled[email] = append(handled[email], event.GetTimestamp())
+		return nil
+	})
+
+	receiver := &SsfReceiverImplementation{}
+	subjects := []string{"a@example.com", "b@example.com", "c@example.com"}
+	for i := int64(0); i < 50; i++ {
+		for _, subject := range subjects {
+			jti := fmt.Sprintf("%s-%d", subject, i)
+			err := dispatcher.Dispatch(context.Background(), testDelivery(receiver, jti, testEvent(subject, i)))
+			if err != nil {
+				t.Fatalf("Dispatch() error = %v", err)
+			}
+		}
+	}
+	dispatcher.Close()
+
+	for _, subject := range subjects {
+		timestamps := handled[subject]
+		if len(timestamps) != 50 {
+			t.Fatalf("%s: handled %d events, want 50", subject, len(timestamps))
+		}
+		for i, timestamp := range timestamps {
+			if timestamp != int64(i) {
+				t.Fatalf("%s: event %d handled at position %d", subject, timestamp, i)
+			}
+		}
+	}
+	if len(receiver.pendingAcks) != 150 {
+		t.Fatalf("acknowledged %d SETs, want 150", len(receiver.pendingAcks))
+	}
+}
+
+func TestDispatcherSettlesDeliveries(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    Handler
+		wantAck    bool
+		wantSetErr bool
+	}{
+		{
+			name:    "success is acknowledged",
+			handler: func(ctx context.Context, event events.SsfEvent) error { return nil },
+			wantAck: true,
+		},
+		{
+			name:    "failure is left unacknowledged",
+			handler: func(ctx context.Context, event events.SsfEvent) error { return errors.New("failed") },
+		},
+		{
+			name:    "panic is left unacknowledged",
+			handler: func(ctx context.Context, event events.SsfEvent) error { panic("boom") },
+		},
+		{
+			name: "SET error is reported",
+			handler: func(ctx context.Context, event events.SsfEvent) error {
+				return &SetError{Err: "invalid_request", Description: "unsupported subject"}
+			},
+			wantSetErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiver := &SsfReceiverImplementation{}
+			dispatcher := NewDispatcher(1, 1, test.handler)
+			dispatcher.Dispatch(context.Background(), testDelivery(receiver, "jti-1", testEvent("a@example.com", 1)))
+			dispatcher.Close()
+
+			if acked := len(receiver.pendingAcks) == 1; acked != test.wantAck {
+				t.Fatalf("acknowledged = %t, want %t", acked, test.wantAck)
+			}
+			if _, rejected := receiver.pendingSetErrors["jti-1"]; rejected != test.wantSetErr {
+				t.Fatalf("rejected = %t, want %t", rejected, test.wantSetErr)
+			}
+		})
+	}
+}
+
+func TestCallHandlerRecoversPanics(t *testing.T) {
+	err := callHandler(context.Background(), func(ctx context.Context, event events.SsfEvent) error {
+		panic("boom")
+	}, testEvent("a@example.com", 1))
+
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("callHandler() error = %v, want a *PanicError", err)
+	}
+	if panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+		t.Fatalf("PanicError = %+v, want the panic value and stack", panicErr)
+	}
+}
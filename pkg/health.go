@@ -0,0 +1,220 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// minHealthStaleAfter bounds how soon a receiver without a successful poll
+// is reported as unhealthy, when it is not configured with HealthStaleAfter
+const minHealthStaleAfter = time.Minute
+
+// streamStatusCacheLifetime defines how long the stream status fetched for
+// Health is reused, so probes don't hit the transmitter on every request
+const streamStatusCacheLifetime = 30 * time.Second
+
+// streamStatusTimeout bounds the stream status request made by Health, so
+// a slow transmitter doesn't time out the probes
+const streamStatusTimeout = 2 * time.Second
+
+// DefaultHealthMaxPollFailures is how many poll requests in a row may fail
+// before Health reports the receiver as not ready, when it is not
+// configured with HealthMaxPollFailures
+const DefaultHealthMaxPollFailures = 3
+
+// The health of a receiver, as reported by Health
+type Health struct {
+	// Healthy reports whether the receiver is working. A polling receiver
+	// that hasn't polled successfully for longer than its HealthStaleAfter
+	// is unhealthy, so a stuck receiver can be restarted
+	Healthy bool `json:"healthy"`
+
+	// Ready reports whether the receiver is healthy and able to process
+	// events: fewer than HealthMaxPollFailures poll requests failed in a
+	// row, its stream is enabled, and the transmitter's signing keys are
+	// fresh when signatures are verified
+	Ready bool `json:"ready"`
+
+	// Problems describes why the receiver isn't healthy or ready
+	Problems []string `json:"problems,omitempty"`
+
+	// StreamId defines the Id of the receiver's stream
+	StreamId string `json:"stream_id"`
+
+	// Polling reports whether the receiver's poll loop is running
+	Polling bool `json:"polling"`
+
+	// LastSuccessfulPoll defines when the transmitter was last polled
+	// successfully
+	LastSuccessfulPoll time.Time `json:"last_successful_poll,omitempty"`
+
+	// ConsecutiveFailures defines how many poll requests failed since the
+	// last successful one
+	ConsecutiveFailures int `json:"consecutive_failures"`
+
+	// LastPollError defines the error of the last poll request, if it
+	// failed
+	LastPollError string `json:"last_poll_error,omitempty"`
+
+	// StreamStatus defines the stream status reported by the transmitter
+	StreamStatus string `json:"stream_status,omitempty"`
+
+	// StreamStatusError defines why the stream status couldn't be fetched
+	StreamStatusError string `json:"stream_status_error,omitempty"`
+
+	// LastVerification defines the result of decoding and verifying the
+	// most recent SET
+	LastVerification *VerificationResult `json:"last_verification,omitempty"`
+
+	// Jwks defines the state of the transmitter's signing keys, nil when
+	// the receiver doesn't verify signatures
+	Jwks *JwksStatus `json:"jwks,omitempty"`
+}
+
+// Records the result of a poll request
+func (receiver *SsfReceiverImplementation) recordPollResult(err error) {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+
+	if err != nil {
+		receiver.pollFailures++
+		receiver.lastPollError = err
+		return
+	}
+	receiver.lastPollTime = time.Now()
+	receiver.pollFailures = 0
+	receiver.lastPollError = nil
+}
+
+// Reports the receiver's health. The stream status is fetched from the
+// transmitter with a single request bounded by a short timeout, and reused
+// for 30 seconds
+func (receiver *SsfReceiverImplementation) Health(ctx context.Context) Health {
+	receiver.lifecycleMu.Lock()
+	stopped := receiver.stopped
+	polling := receiver.pollDone != nil
+	pollStartedAt := receiver.pollStartedAt
+	receiver.lifecycleMu.Unlock()
+
+	receiver.mu.RLock()
+	health := Health{
+		StreamId:            receiver.streamId,
+		Polling:             polling,
+		LastSuccessfulPoll:  receiver.lastPollTime,
+		ConsecutiveFailures: receiver.pollFailures,
+		LastVerification:    receiver.lastVerification,
+	}
+	if receiver.lastPollError != nil {
+		health.LastPollError = receiver.lastPollError.Error()
+	}
+	staleAfter := receiver.healthStaleAfter()
+	maxPollFailures := receiver.maxPollFailures
+	receiver.mu.RUnlock()
+	if maxPollFailures <= 0 {
+		maxPollFailures = DefaultHealthMaxPollFailures
+	}
+
+	var problems, notReady []string
+	if stopped {
+		problems = append(problems, "receiver has been shut down")
+	}
+
+	if polling {
+		since := health.LastSuccessfulPoll
+		if pollStartedAt.After(since) {
+			since = pollStartedAt
+		}
+		if time.Since(since) > staleAfter {
+			problems = append(problems, fmt.Sprintf("no successful poll in %s", time.Since(since).Round(time.Second)))
+		}
+	}
+	if health.ConsecutiveFailures >= maxPollFailures {
+		notReady = append(notReady, fmt.Sprintf("last %d poll requests failed", health.ConsecutiveFailures))
+	}
+
+	status, err := receiver.cachedStreamStatus(ctx)
+	if err != nil {
+		health.StreamStatusError = err.Error()
+	} else {
+		health.StreamStatus = EnumToStringStatusMap[status]
+		if status != StreamEnabled {
+			notReady = append(notReady, "stream is "+health.StreamStatus)
+		}
+	}
+
+	if receiver.jwks != nil {
+		jwksStatus := receiver.jwks.Status()
+		health.Jwks = &jwksStatus
+		if !jwksStatus.Fresh {
+			notReady = append(notReady, "transmitter signing keys are not fresh")
+		}
+	}
+
+	health.Healthy = len(problems) == 0
+	health.Ready = health.Healthy && len(notReady) == 0
+	health.Problems = append(problems, notReady...)
+	return health
+}
+
+// Returns how long the receiver can go without a successful poll before it
+// is unhealthy. Must be called with mu held
+func (receiver *SsfReceiverImplementation) healthStaleAfter() time.Duration {
+	if receiver.staleAfter > 0 {
+		return receiver.staleAfter
+	}
+
+	staleAfter := 3 * time.Duration(receiver.pollInterval) * time.Second
+	if receiver.longPoll {
+		staleAfter = 3 * receiver.longPollTimeout
+	}
+	return max(staleAfter, minHealthStaleAfter)
+}
+
+// Returns the stream status, fetching it from the transmitter when the
+// cached status is older than streamStatusCacheLifetime. The request isn't
+// retried and is bounded by streamStatusTimeout
+func (receiver *SsfReceiverImplementation) cachedStreamStatus(ctx context.Context) (StreamStatus, error) {
+	receiver.mu.RLock()
+	status, err, checkedAt := receiver.streamStatus, receiver.streamStatusErr, receiver.streamStatusCheckedAt
+	receiver.mu.RUnlock()
+
+	if time.Since(checkedAt) < streamStatusCacheLifetime {
+		return status, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, streamStatusTimeout)
+	defer cancel()
+	status, err = receiver.getStreamStatus(ctx, false)
+	receiver.mu.Lock()
+	receiver.streamStatus, receiver.streamStatusErr, receiver.streamStatusCheckedAt = status, err, time.Now()
+	receiver.mu.Unlock()
+	return status, err
+}
+
+// Returns an http.Handler reporting the receiver's health as JSON, for
+// liveness and readiness probes. Requests to a path ending in /readyz
+// respond with 200 when the receiver is ready, any other path responds
+// with 200 when the receiver is healthy. 503 is returned otherwise
+func (receiver *SsfReceiverImplementation) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		health := receiver.Health(r.Context())
+
+		ok := health.Healthy
+		if strings.HasSuffix(r.URL.Path, "/readyz") {
+			ok = health.Ready
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if ok {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(health)
+	})
+}
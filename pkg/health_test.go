@@ -0,0 +1,59 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestHealthToleratesPollFailures(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxPollFailures int
+		failures        int
+		wantReady       bool
+	}{
+		{"no failures", 0, 0, true},
+		{"below the default", 0, DefaultHealthMaxPollFailures - 1, true},
+		{"at the default", 0, DefaultHealthMaxPollFailures, false},
+		{"below the configured limit", 5, 4, true},
+		{"at the configured limit", 5, 5, false},
+		{"single failure with a limit of 1", 1, 1, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiver := newTestReceiver(t, newFakeTransmitter(t), ReceiverConfig{HealthMaxPollFailures: test.maxPollFailures})
+			for i := 0; i < test.failures; i++ {
+				receiver.recordPollResult(errors.New("poll failed"))
+			}
+
+			health := receiver.Health(context.Background())
+			if !health.Healthy {
+				t.Fatalf("Health() = %+v, want healthy", health)
+			}
+			if health.Ready != test.wantReady {
+				t.Fatalf("Health().Ready = %t, want %t: %v", health.Ready, test.wantReady, health.Problems)
+			}
+			if health.ConsecutiveFailures != test.failures {
+				t.Fatalf("Health().ConsecutiveFailures = %d, want %d", health.ConsecutiveFailures, test.failures)
+			}
+		})
+	}
+}
+
+func TestHealthFetchesStreamStatusOnceWithoutRetries(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	transmitter.statusCode = http.StatusServiceUnavailable
+	receiver := newTestReceiver(t, transmitter, ReceiverConfig{})
+
+	health := receiver.Health(context.Background())
+	if health.StreamStatusError == "" {
+		t.Fatalf("Health() = %+v, want a stream status error", health)
+	}
+	receiver.Health(context.Background())
+
+	if requests := transmitter.streamRequests("status"); requests != 1 {
+		t.Fatalf("made %d stream status requests, want a single cached request", requests)
+	}
+}
@@ -0,0 +1,648 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultInboxSegmentSize is the size at which the inbox starts a new
+	// segment when it is not opened with a SegmentSize
+	DefaultInboxSegmentSize = 16 << 20
+
+	inboxSegmentSuffix = ".log"
+	inboxOffsetSuffix  = ".offset"
+	inboxConsumersDir  = "consumers"
+
+	// inboxHandlerConsumer is the consumer name the receiver's handler
+	// commits its inbox offsets under
+	inboxHandlerConsumer = "handler"
+
+	// inboxConsumeBatchSize is how many records Consume reads at once, and
+	// handles before committing its offset
+	inboxConsumeBatchSize = 100
+)
+
+// Options for opening an Inbox
+type InboxOptions struct {
+	// SegmentSize defines the size, in bytes, at which the inbox starts
+	// writing a new segment
+	//
+	// Optional, defaults to 16 MiB
+	SegmentSize int64
+
+	// MaxSize defines the total size, in bytes, the inbox may grow to. Once
+	// it is exceeded the oldest segments are deleted, even if some
+	// consumers haven't read them yet
+	//
+	// Optional, defaults to 0 (unbounded)
+	MaxSize int64
+}
+
+// A SET stored in the inbox
+type InboxRecord struct {
+	// Offset defines the position of the record in the inbox
+	Offset uint64 `json:"offset"`
+
+	// JTI defines the unique id of the SET
+	JTI string `json:"jti"`
+
+	// SET defines the raw SET as received from the transmitter
+	SET string `json:"set"`
+
+	// ReceivedAt defines when the SET was received
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// A durable, on-disk inbox of received SETs.
+//
+// The inbox is a write-ahead log split into segment files. The receiver
+// appends polled SETs to it and syncs them to disk before acknowledging
+// them with the transmitter, so the transmitter doesn't need to hold on to
+// SETs while handlers are slow or unavailable. Consumers read the inbox in
+// order and commit their own offsets, and segments are deleted once every
+// consumer has committed past them, or when the inbox outgrows its
+// MaxSize
+type Inbox struct {
+	dir         string
+	segmentSize int64
+	maxSize     int64
+
+	mu         sync.Mutex
+	segments   []inboxSegment
+	active     *os.File
+	nextOffset uint64
+
+	// appended is closed and replaced every time records are appended, to
+	// wake up waiting consumers
+	appended chan struct{}
+}
+
+// A segment file holding the records starting at baseOffset
+type inboxSegment struct {
+	baseOffset uint64
+	path       string
+	size       int64
+}
+
+// Opens the inbox stored in the given directory, creating it if needed
+func OpenInbox(dir string, opts InboxOptions) (*Inbox, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultInboxSegmentSize
+	}
+
+	err := os.MkdirAll(filepath.Join(dir, inboxConsumersDir), 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	inbox := &Inbox{
+		dir:         dir,
+		segmentSize: opts.SegmentSize,
+		maxSize:     opts.MaxSize,
+		appended:    make(chan struct{}),
+	}
+
+	err = inbox.loadSegments()
+	if err != nil {
+		return nil, err
+	}
+	return inbox, nil
+}
+
+// Finds the existing segments, and recovers the last one by truncating any
+// partially written record left behind by a crash
+func (inbox *Inbox) loadSegments() error {
+	entries, err := os.ReadDir(inbox.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, inboxSegmentSuffix) {
+			continue
+		}
+		baseOffset, err := strconv.ParseUint(strings.TrimSuffix(name, inboxSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		inbox.segments = append(inbox.segments, inboxSegment{baseOffset: baseOffset, path: filepath.Join(inbox.dir, name), size: info.Size()})
+	}
+	sort.Slice(inbox.segments, func(i, j int) bool { return inbox.segments[i].baseOffset < inbox.segments[j].baseOffset })
+
+	if len(inbox.segments) == 0 {
+		return inbox.rollSegment(0)
+	}
+
+	last := &inbox.segments[len(inbox.segments)-1]
+	nextOffset, validSize, err := recoverSegment(last.path, last.baseOffset)
+	if err != nil {
+		return err
+	}
+	if validSize != last.size {
+		err = os.Truncate(last.path, validSize)
+		if err != nil {
+			return err
+		}
+		last.size = validSize
+	}
+
+	inbox.nextOffset = nextOffset
+	inbox.active, err = os.OpenFile(last.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	return err
+}
+
+// Scans a segment, returning the offset following its last complete record
+// and the size of the segment up to that record
+func recoverSegment(path string, baseOffset uint64) (uint64, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	nextOffset := baseOffset
+	var validSize int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nextOffset, validSize, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var record InboxRecord
+		if json.Unmarshal(line, &record) != nil {
+			return nextOffset, validSize, nil
+		}
+		nextOffset = record.Offset + 1
+		validSize += int64(len(line))
+	}
+}
+
+// Closes the active segment and starts a new one at baseOffset. Must be
+// called with the lock held
+func (inbox *Inbox) rollSegment(baseOffset uint64) error {
+	if inbox.active != nil {
+		err := inbox.active.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	path := filepath.Join(inbox.dir, fmt.Sprintf("%020d%s", baseOffset, inboxSegmentSuffix))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	inbox.active = file
+	inbox.nextOffset = baseOffset
+	inbox.segments = append(inbox.segments, inboxSegment{baseOffset: baseOffset, path: path})
+	return syncDir(inbox.dir)
+}
+
+// Appends the SETs to the inbox and syncs them to disk, assigning each
+// record its offset. Returns once the records are durable
+func (inbox *Inbox) Append(records []InboxRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	inbox.mu.Lock()
+	defer inbox.mu.Unlock()
+
+	if inbox.active == nil {
+		return errors.New("inbox is closed")
+	}
+
+	active := &inbox.segments[len(inbox.segments)-1]
+	if active.size >= inbox.segmentSize {
+		err := inbox.rollSegment(inbox.nextOffset)
+		if err != nil {
+			return err
+		}
+		active = &inbox.segments[len(inbox.segments)-1]
+	}
+
+	var buffer bytes.Buffer
+	for i := range records {
+		records[i].Offset = inbox.nextOffset + uint64(i)
+		if records[i].ReceivedAt.IsZero() {
+			records[i].ReceivedAt = time.Now()
+		}
+		line, err := json.Marshal(records[i])
+		if err != nil {
+			return err
+		}
+		buffer.Write(line)
+		buffer.WriteByte('\n')
+	}
+
+	_, err := inbox.active.Write(buffer.Bytes())
+	if err != nil {
+		return err
+	}
+	err = inbox.active.Sync()
+	if err != nil {
+		return err
+	}
+
+	active.size += int64(buffer.Len())
+	inbox.nextOffset += uint64(len(records))
+
+	close(inbox.appended)
+	inbox.appended = make(chan struct{})
+
+	return inbox.enforceRetention()
+}
+
+// Deletes the oldest segments while the inbox is larger than its MaxSize.
+// Must be called with the lock held
+func (inbox *Inbox) enforceRetention() error {
+	if inbox.maxSize <= 0 {
+		return nil
+	}
+
+	var total int64
+	for _, segment := range inbox.segments {
+		total += segment.size
+	}
+
+	for total > inbox.maxSize && len(inbox.segments) > 1 {
+		oldest := inbox.segments[0]
+		err := os.Remove(oldest.path)
+		if err != nil {
+			return err
+		}
+		total -= oldest.size
+		inbox.segments = inbox.segments[1:]
+	}
+	return nil
+}
+
+// Returns up to max records starting at the given offset. If the records
+// at that offset were already deleted, reading starts at the oldest record
+// still in the inbox
+func (inbox *Inbox) Read(from uint64, max int) ([]InboxRecord, error) {
+	inbox.mu.Lock()
+	segments := append([]inboxSegment{}, inbox.segments...)
+	nextOffset := inbox.nextOffset
+	inbox.mu.Unlock()
+
+	if from >= nextOffset {
+		return nil, nil
+	}
+
+	start := 0
+	for i, segment := range segments {
+		if segment.baseOffset <= from {
+			start = i
+		}
+	}
+
+	var records []InboxRecord
+	for _, segment := range segments[start:] {
+		if len(records) >= max {
+			break
+		}
+		err := readSegment(segment, from, max-len(records), &records)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// Appends up to max records at or after the given offset from the segment
+func readSegment(segment inboxSegment, from uint64, max int, records *[]InboxRecord) error {
+	file, err := os.Open(segment.path)
+	if os.IsNotExist(err) {
+		// Deleted by retention or compaction since the segments were listed
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Only read up to the size known to hold complete records
+	reader := bufio.NewReader(io.LimitReader(file, segment.size))
+	for added := 0; added < max; {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var record InboxRecord
+		err = json.Unmarshal(line, &record)
+		if err != nil {
+			return err
+		}
+		if record.Offset >= from {
+			*records = append(*records, record)
+			added++
+		}
+	}
+	return nil
+}
+
+// Returns the offset the consumer should read from next, 0 if it never
+// committed an offset
+func (inbox *Inbox) Committed(consumer string) (uint64, error) {
+	path, err := inbox.offsetPath(consumer)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// Commits the offset the consumer should read from next
+func (inbox *Inbox) Commit(consumer string, next uint64) error {
+	path, err := inbox.offsetPath(consumer)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(strconv.FormatUint(next, 10)))
+}
+
+func (inbox *Inbox) offsetPath(consumer string) (string, error) {
+	if consumer == "" || strings.ContainsAny(consumer, `/\`) || consumer == "." || consumer == ".." {
+		return "", fmt.Errorf("invalid inbox consumer name %q", consumer)
+	}
+	return filepath.Join(inbox.dir, inboxConsumersDir, consumer+inboxOffsetSuffix), nil
+}
+
+// Deletes the segments every consumer has committed past. The active
+// segment is never deleted
+func (inbox *Inbox) Compact() error {
+	entries, err := os.ReadDir(filepath.Join(inbox.dir, inboxConsumersDir))
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	minCommitted := ^uint64(0)
+	for _, entry := range entries {
+		consumer, found := strings.CutSuffix(entry.Name(), inboxOffsetSuffix)
+		if !found {
+			continue
+		}
+		committed, err := inbox.Committed(consumer)
+		if err != nil {
+			return err
+		}
+		if committed < minCommitted {
+			minCommitted = committed
+		}
+	}
+
+	inbox.mu.Lock()
+	defer inbox.mu.Unlock()
+
+	for len(inbox.segments) > 1 && inbox.segments[1].baseOffset <= minCommitted {
+		err := os.Remove(inbox.segments[0].path)
+		if err != nil {
+			return err
+		}
+		inbox.segments = inbox.segments[1:]
+	}
+	return nil
+}
+
+// Calls handle for every record in the inbox, in order, starting at the
+// consumer's committed offset. When handle fails the record is retried
+// after a backoff. Blocks waiting for new records until the context is done.
+//
+// The offset is committed once per batch of records read, when handle
+// fails and when the context is done, rather than after every record. A
+// consumer that crashes may therefore handle up to a batch of records
+// again after a restart
+func (inbox *Inbox) Consume(ctx context.Context, consumer string, handle func(ctx context.Context, record InboxRecord) error) error {
+	next, err := inbox.Committed(consumer)
+	if err != nil {
+		return err
+	}
+
+	committed := next
+	commit := func() error {
+		if next == committed {
+			return nil
+		}
+		err := inbox.Commit(consumer, next)
+		if err == nil {
+			committed = next
+		}
+		return err
+	}
+
+	failures := 0
+	for {
+		inbox.mu.Lock()
+		appended := inbox.appended
+		inbox.mu.Unlock()
+
+		records, err := inbox.Read(next, inboxConsumeBatchSize)
+		if err != nil {
+			return err
+		}
+
+		if len(records) == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-appended:
+			}
+			continue
+		}
+
+		for _, record := range records {
+			if ctx.Err() != nil {
+				break
+			}
+
+			err = handle(ctx, record)
+			if err != nil {
+				failures++
+				break
+			}
+			failures = 0
+			next = record.Offset + 1
+		}
+
+		commitErr := commit()
+		if commitErr != nil {
+			return commitErr
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if failures > 0 {
+			err = sleepContext(ctx, DefaultRetryPolicy.Backoff(failures, err))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = inbox.Compact()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Appends the SETs of the batch to the receiver's inbox in the order they
+// were issued, and acknowledges them with the transmitter once they are
+// durable
+func (receiver *SsfReceiverImplementation) appendToInbox(ctx context.Context, batch *pollBatch) error {
+	if len(batch.sets) == 0 {
+		return nil
+	}
+
+	records := make([]InboxRecord, 0, len(batch.sets))
+	for _, jti := range orderedSets(batch.sets) {
+		records = append(records, InboxRecord{JTI: jti, SET: batch.sets[jti]})
+	}
+
+	err := receiver.inbox.Append(records)
+	if err != nil {
+		return err
+	}
+	return acknowledgeEvents(ctx, &batch.sets, receiver)
+}
+
+// Passes the events of every SET in the receiver's inbox to its handler.
+// SETs that cannot be parsed are skipped, since retrying them can't
+// succeed; they are counted as rejected and stored in the dead-letter
+// store when the receiver has one. SETs that can't be verified because the
+// signing keys are unavailable are retried.
+//
+// Cancelling the context stops the consumer once the SET being handled is
+// done, the handler itself is not cancelled
+func (receiver *SsfReceiverImplementation) consumeInbox(ctx context.Context) error {
+	return receiver.inbox.Consume(ctx, inboxHandlerConsumer, func(ctx context.Context, record InboxRecord) error {
+		ctx = context.WithoutCancel(ctx)
+		if receiver.isDuplicate(record.JTI) {
+			return nil
+		}
+
+		info, ssfEvents, err := receiver.parseSet(ctx, record.JTI, record.SET, "poll")
+		if errors.Is(err, ErrSigningKeysUnavailable) {
+			// Retried after a backoff
+			return err
+		}
+		if err != nil {
+			return receiver.skipInboxRecord(record, info, err)
+		}
+
+		ctx = ContextWithEventInfo(contextWithSet(ctx, record.SET), info)
+		for _, ssfEvent := range ssfEvents {
+			err = callHandler(ctx, receiver.handler, ssfEvent)
+			if err != nil {
+				return err
+			}
+		}
+
+		receiver.markProcessed(record.JTI)
+		return nil
+	})
+}
+
+// Sets aside an inbox SET that cannot be parsed. The SET was already
+// acknowledged, so it is dead-lettered rather than dropped when the
+// receiver has a dead-letter store. Returns an error only when the dead
+// letter can't be stored, so the SET is retried instead of being lost
+func (receiver *SsfReceiverImplementation) skipInboxRecord(record InboxRecord, info EventInfo, parseErr error) error {
+	if receiver.deadLetters == nil {
+		receiver.logger.Warn("skipped inbox SET that cannot be parsed",
+			slog.String("jti", record.JTI),
+			slog.Uint64("offset", record.Offset),
+			slog.Any("error", parseErr))
+		return nil
+	}
+
+	now := time.Now().UTC()
+	letter := DeadLetter{
+		Id:             record.JTI,
+		SET:            record.SET,
+		JTI:            record.JTI,
+		Txn:            info.Txn,
+		Issuer:         info.Issuer,
+		DeliveryMethod: info.DeliveryMethod,
+		Attempts:       []DeadLetterAttempt{{Error: parseErr.Error(), At: now}},
+		FirstFailedAt:  now,
+		DeadLetteredAt: now,
+	}
+	err := receiver.deadLetters.Put(letter)
+	if err != nil {
+		receiver.logger.Error("failed to dead-letter inbox SET",
+			slog.String("jti", record.JTI),
+			slog.Any("error", err))
+		return errors.Join(parseErr, err)
+	}
+
+	receiver.logger.Warn("dead-lettered inbox SET that cannot be parsed",
+		slog.String("jti", record.JTI),
+		slog.Uint64("offset", record.Offset),
+		slog.Any("error", parseErr))
+	return nil
+}
+
+// Closes the inbox
+func (inbox *Inbox) Close() error {
+	inbox.mu.Lock()
+	defer inbox.mu.Unlock()
+
+	if inbox.active == nil {
+		return nil
+	}
+	err := inbox.active.Close()
+	inbox.active = nil
+	return err
+}
+
+// Syncs a directory so the files created or renamed in it are durable
+func syncDir(dir string) error {
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer dirFile.Close()
+	dirFile.Sync()
+	return nil
+}
@@ -0,0 +1,241 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+func openTestInbox(t *testing.T, dir string, opts InboxOptions) *Inbox {
+	t.Helper()
+	inbox, err := OpenInbox(dir, opts)
+	if err != nil {
+		t.Fatalf("OpenInbox() error = %v", err)
+	}
+	t.Cleanup(func() { inbox.Close() })
+	return inbox
+}
+
+func appendRecords(t *testing.T, inbox *Inbox, jtis ...string) {
+	t.Helper()
+	records := make([]InboxRecord, len(jtis))
+	for i, jti := range jtis {
+		records[i] = InboxRecord{JTI: jti, SET: "set-" + jti}
+	}
+	err := inbox.Append(records)
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+}
+
+func TestInboxRecoversFromPartialWrite(t *testing.T) {
+	dir := t.TempDir()
+	inbox := openTestInbox(t, dir, InboxOptions{})
+	appendRecords(t, inbox, "a", "b")
+	inbox.Close()
+
+	// A crash in the middle of a write leaves a partial record behind
+	segment := filepath.Join(dir, "00000000000000000000.log")
+	appendLine(t, segment, `{"offset":2,"jti":"c","se`)
+
+	inbox = openTestInbox(t, dir, InboxOptions{})
+	appendRecords(t, inbox, "d")
+
+	records, err := inbox.Read(0, 10)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	var jtis []string
+	for i, record := range records {
+		if record.Offset != uint64(i) {
+			t.Fatalf("record %s has offset %d, want %d", record.JTI, record.Offset, i)
+		}
+		jtis = append(jtis, record.JTI)
+	}
+	if len(jtis) != 3 || jtis[2] != "d" {
+		t.Fatalf("read %v, want [a b d]", jtis)
+	}
+}
+
+func TestInboxReplaysUncommittedRecordsAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	inbox := openTestInbox(t, dir, InboxOptions{})
+	appendRecords(t, inbox, "a", "b", "c")
+
+	// The consumer crashes while handling b
+	ctx, cancel := context.WithCancel(context.Background())
+	var handled []string
+	err := inbox.Consume(ctx, "test", func(ctx context.Context, record InboxRecord) error {
+		handled = append(handled, record.JTI)
+		if record.JTI == "b" {
+			cancel()
+			return errors.New("crashed")
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Consume() error = %v, want context.Canceled", err)
+	}
+	inbox.Close()
+
+	inbox = openTestInbox(t, dir, InboxOptions{})
+	committed, err := inbox.Committed("test")
+	if err != nil || committed != 1 {
+		t.Fatalf("Committed() = %d, %v, want 1", committed, err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	handled = nil
+	err = inbox.Consume(ctx, "test", func(ctx context.Context, record InboxRecord) error {
+		handled = append(handled, record.JTI)
+		if record.JTI == "c" {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Consume() error = %v, want context.Canceled", err)
+	}
+	if len(handled) != 2 || handled[0] != "b" || handled[1] != "c" {
+		t.Fatalf("replayed %v, want [b c]", handled)
+	}
+}
+
+func TestInboxCompactsConsumedSegments(t *testing.T) {
+	tests := []struct {
+		name      string
+		committed uint64
+		want      int
+	}{
+		{"nothing committed", 0, 3},
+		{"first segment committed", 1, 2},
+		{"everything committed", 3, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inbox := openTestInbox(t, t.TempDir(), InboxOptions{SegmentSize: 1})
+			appendRecords(t, inbox, "a")
+			appendRecords(t, inbox, "b")
+			appendRecords(t, inbox, "c")
+
+			err := inbox.Commit("test", test.committed)
+			if err != nil {
+				t.Fatalf("Commit() error = %v", err)
+			}
+			err = inbox.Compact()
+			if err != nil {
+				t.Fatalf("Compact() error = %v", err)
+			}
+			if len(inbox.segments) != test.want {
+				t.Fatalf("%d segments left, want %d", len(inbox.segments), test.want)
+			}
+		})
+	}
+}
+
+func TestConsumeInboxDeadLettersUnparseableSets(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	transmitter.add("good", testSet(t, "good", nil))
+	transmitter.add("malformed", "not a SET")
+
+	var mu sync.Mutex
+	var handled []string
+	store, err := NewFileDeadLetterStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTestReceiver(t, transmitter, ReceiverConfig{
+		Inbox:           openTestInbox(t, t.TempDir(), InboxOptions{}),
+		DeadLetterStore: store,
+		Handler: func(ctx context.Context, event events.SsfEvent) error {
+			info, _ := EventInfoFromContext(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			handled = append(handled, info.JTI)
+			return nil
+		},
+	})
+
+	waitFor(t, func() bool {
+		letters, _ := store.List()
+		mu.Lock()
+		defer mu.Unlock()
+		return len(letters) == 1 && len(handled) == 1
+	})
+
+	letter, err := store.Get("malformed")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if letter.SET != "not a SET" || len(letter.Attempts) != 1 {
+		t.Fatalf("dead letter = %+v, want the raw SET and its parse error", letter)
+	}
+	if handled[0] != "good" {
+		t.Fatalf("handled %v, want [good]", handled)
+	}
+}
+
+// Waits up to 5 seconds for the condition to hold
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConsumeCommitsOffsetsPerBatch(t *testing.T) {
+	inbox := openTestInbox(t, t.TempDir(), InboxOptions{})
+	appendRecords(t, inbox, "a", "b", "c")
+
+	var mu sync.Mutex
+	var committedWhileHandling []uint64
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- inbox.Consume(ctx, "test", func(ctx context.Context, record InboxRecord) error {
+			committed, err := inbox.Committed("test")
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			committedWhileHandling = append(committedWhileHandling, committed)
+			mu.Unlock()
+			if record.JTI == "e" {
+				return errors.New("failed")
+			}
+			return nil
+		})
+	}()
+
+	committed := func(want uint64) func() bool {
+		return func() bool {
+			got, err := inbox.Committed("test")
+			return err == nil && got == want
+		}
+	}
+	waitFor(t, committed(3))
+	mu.Lock()
+	got := append([]uint64{}, committedWhileHandling...)
+	mu.Unlock()
+	if len(got) != 3 || got[0] != 0 || got[1] != 0 || got[2] != 0 {
+		t.Fatalf("offsets committed while handling the batch = %v, want [0 0 0]", got)
+	}
+
+	// The records handled before a failure are committed before the retry
+	appendRecords(t, inbox, "d", "e")
+	waitFor(t, committed(4))
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Consume() error = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,368 @@
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultJwksRefreshInterval is how long the transmitter's signing keys are
+// used before they are fetched again
+const DefaultJwksRefreshInterval = time.Hour
+
+// minJwksRefreshInterval bounds how often the keys are fetched again when a
+// SET is signed with an unknown key
+const minJwksRefreshInterval = time.Minute
+
+// Returned, wrapped, when a SET can't be verified because the transmitter's
+// signing keys couldn't be fetched. Unlike an invalid signature this is
+// transient, so the SET is left unacknowledged and verified again when it
+// is redelivered
+var ErrSigningKeysUnavailable = errors.New("transmitter signing keys are unavailable")
+
+// A set of public keys, parsed from a JSON Web Key Set
+type KeySet struct {
+	keys map[string]crypto.PublicKey
+}
+
+// Parses a JSON Web Key Set. Keys that are not meant for signatures or use
+// an unsupported key type are skipped
+func ParseJwks(data []byte) (*KeySet, error) {
+	var jwks struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	err := json.Unmarshal(data, &jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keySet := &KeySet{keys: map[string]crypto.PublicKey{}}
+	for _, raw := range jwks.Keys {
+		kid, key, err := parseJwk(raw)
+		if err != nil || key == nil {
+			continue
+		}
+		keySet.keys[kid] = key
+	}
+
+	if len(keySet.keys) == 0 {
+		return nil, errors.New("JWKS does not contain any usable signing keys")
+	}
+	return keySet, nil
+}
+
+// Reads and parses the JSON Web Key Set in the file at the given path
+func LoadJwksFile(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJwks(data)
+}
+
+// Returns the key with the given key id. When kid is empty and the set
+// contains a single key, that key is returned
+func (keySet *KeySet) Key(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(keySet.keys) == 1 {
+		for _, key := range keySet.keys {
+			return key, true
+		}
+	}
+	key, found := keySet.keys[kid]
+	return key, found
+}
+
+// Returns the number of keys in the set
+func (keySet *KeySet) Len() int {
+	return len(keySet.keys)
+}
+
+// Parses a single JSON Web Key, returning a nil key for keys that can't be
+// used to verify signatures
+func parseJwk(raw json.RawMessage) (string, crypto.PublicKey, error) {
+	var jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	err := json.Unmarshal(raw, &jwk)
+	if err != nil {
+		return "", nil, err
+	}
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return jwk.Kid, nil, nil
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeJwkInt(jwk.N)
+		if err != nil {
+			return "", nil, err
+		}
+		e, err := decodeJwkInt(jwk.E)
+		if err != nil {
+			return "", nil, err
+		}
+		// Larger exponents would overflow, and are rejected by crypto/rsa
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > math.MaxInt32 || e.Bit(0) == 0 {
+			return "", nil, fmt.Errorf("invalid RSA exponent %s", e)
+		}
+		return jwk.Kid, &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		var point ecdh.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve, point = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, point = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, point = elliptic.P521(), ecdh.P521()
+		default:
+			return jwk.Kid, nil, nil
+		}
+		x, err := decodeJwkInt(jwk.X)
+		if err != nil {
+			return "", nil, err
+		}
+		y, err := decodeJwkInt(jwk.Y)
+		if err != nil {
+			return "", nil, err
+		}
+		err = checkEcPoint(curve, point, x, y)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid %s key: %w", jwk.Crv, err)
+		}
+		return jwk.Kid, &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return jwk.Kid, nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return "", nil, fmt.Errorf("invalid Ed25519 key size %d", len(x))
+		}
+		return jwk.Kid, ed25519.PublicKey(x), nil
+	}
+	return jwk.Kid, nil, nil
+}
+
+// Checks that the coordinates are those of a point on the curve, other
+// than the point at infinity
+func checkEcPoint(curve elliptic.Curve, point ecdh.Curve, x *big.Int, y *big.Int) error {
+	size := (curve.Params().BitSize + 7) / 8
+	if x.BitLen() > size*8 || y.BitLen() > size*8 {
+		return errors.New("coordinates are too large")
+	}
+
+	// The uncompressed SEC 1 encoding, which crypto/ecdh validates
+	encoded := make([]byte, 1+2*size)
+	encoded[0] = 4
+	x.FillBytes(encoded[1 : 1+size])
+	y.FillBytes(encoded[1+size:])
+	_, err := point.NewPublicKey(encoded)
+	if err != nil {
+		return errors.New("point is not on the curve")
+	}
+	return nil
+}
+
+func decodeJwkInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// Caches the signing keys published at a transmitter's jwks_uri.
+//
+// The keys are fetched again once they are older than the refresh
+// interval, and when a SET is signed with a key that isn't cached, so
+// rotated keys are picked up without waiting for the next refresh
+type JwksCache struct {
+	refreshInterval time.Duration
+
+	mu          sync.RWMutex
+	uri         string
+	keys        *KeySet
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	lastErr     error
+}
+
+// Creates a cache for the keys published at the given JWKS uri. A
+// refreshInterval of 0 uses DefaultJwksRefreshInterval
+func NewJwksCache(uri string, refreshInterval time.Duration) *JwksCache {
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultJwksRefreshInterval
+	}
+	return &JwksCache{uri: uri, refreshInterval: refreshInterval}
+}
+
+// Returns the key with the given key id, fetching the keys first when they
+// are stale or the key isn't cached.
+//
+// Returns an error wrapping ErrSigningKeysUnavailable when the keys
+// couldn't be fetched, or when the key isn't cached and the last fetch
+// failed, since the key may have been published since
+func (cache *JwksCache) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	cache.mu.RLock()
+	keys, fetchedAt, attemptedAt := cache.keys, cache.fetchedAt, cache.attemptedAt
+	cache.mu.RUnlock()
+
+	if keys != nil && time.Since(fetchedAt) < cache.refreshInterval {
+		if key, found := keys.Key(kid); found {
+			return key, nil
+		}
+	}
+
+	// Stale keys and unknown key ids trigger a refresh, at most once per
+	// minute. The cached keys are still used when the refresh fails
+	if attemptedAt.IsZero() || time.Since(attemptedAt) >= minJwksRefreshInterval {
+		err := cache.Refresh(ctx)
+		if err != nil && keys == nil {
+			return nil, fmt.Errorf("%w: %w", ErrSigningKeysUnavailable, err)
+		}
+	}
+
+	cache.mu.RLock()
+	keys, lastErr := cache.keys, cache.lastErr
+	cache.mu.RUnlock()
+	if keys == nil {
+		if lastErr == nil {
+			lastErr = errors.New("keys were not fetched yet")
+		}
+		return nil, fmt.Errorf("%w: %w", ErrSigningKeysUnavailable, lastErr)
+	}
+
+	if key, found := keys.Key(kid); found {
+		return key, nil
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("%w: no cached signing key with kid %q: %w", ErrSigningKeysUnavailable, kid, lastErr)
+	}
+	return nil, fmt.Errorf("no signing key with kid %q", kid)
+}
+
+// Fetches the keys from the JWKS uri, replacing the cached keys
+func (cache *JwksCache) Refresh(ctx context.Context) error {
+	cache.mu.Lock()
+	uri := cache.uri
+	cache.attemptedAt = time.Now()
+	cache.mu.Unlock()
+
+	keys, err := fetchJwks(ctx, uri)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.lastErr = err
+	if err != nil {
+		return err
+	}
+	cache.keys = keys
+	cache.fetchedAt = time.Now()
+	return nil
+}
+
+// Changes the uri the keys are fetched from. The cached keys are kept until
+// the next refresh
+func (cache *JwksCache) SetUri(uri string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.uri != uri {
+		cache.uri = uri
+		cache.attemptedAt = time.Time{}
+	}
+}
+
+// Reports the state of the cached keys
+func (cache *JwksCache) Status() JwksStatus {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	status := JwksStatus{Uri: cache.uri, FetchedAt: cache.fetchedAt}
+	if cache.keys != nil {
+		status.Keys = cache.keys.Len()
+		status.Fresh = time.Since(cache.fetchedAt) < 2*cache.refreshInterval
+	}
+	if cache.lastErr != nil {
+		status.Error = cache.lastErr.Error()
+	}
+	return status
+}
+
+// The state of a JwksCache
+type JwksStatus struct {
+	// Uri defines where the keys are fetched from
+	Uri string `json:"uri"`
+
+	// Keys defines how many keys are cached
+	Keys int `json:"keys"`
+
+	// FetchedAt defines when the keys were last fetched successfully
+	FetchedAt time.Time `json:"fetched_at,omitempty"`
+
+	// Fresh reports whether the keys were fetched recently enough to be
+	// trusted, within twice the refresh interval
+	Fresh bool `json:"fresh"`
+
+	// Error defines the error of the last fetch, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+func fetchJwks(ctx context.Context, uri string) (*KeySet, error) {
+	if uri == "" {
+		return nil, errors.New("transmitter does not publish a jwks_uri")
+	}
+
+	ctx, cancel := withRequestTimeout(ctx, 0)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, newTransmitterError(response, body)
+	}
+	return ParseJwks(body)
+}
@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// redactedLogValue replaces the values of log attributes that may carry
+// credentials or subject PII
+const redactedLogValue = "[REDACTED]"
+
+// sensitiveLogKeys are the attribute keys whose values are redacted from the
+// receiver's logs, compared case insensitively
+var sensitiveLogKeys = map[string]bool{
+	"authorization":        true,
+	"authorization_header": true,
+	"authorization_token":  true,
+	"token":                true,
+	"access_token":         true,
+	"subject":              true,
+	"sub_id":               true,
+	"sub":                  true,
+	"email":                true,
+	"phone_number":         true,
+}
+
+// Returns the logger used by a receiver for the given transmitter. The
+// configured logger is wrapped so sensitive attributes are redacted, and a
+// nil logger discards everything
+func newReceiverLogger(logger *slog.Logger, transmitter string) *slog.Logger {
+	if logger == nil {
+		return slog.New(discardHandler{})
+	}
+	return slog.New(redactingHandler{logger.Handler()}).With(slog.String("transmitter", transmitter))
+}
+
+// A slog.Handler that redacts the values of sensitive attributes before
+// passing records on to the wrapped handler
+type redactingHandler struct {
+	slog.Handler
+}
+
+func (handler redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return handler.Handler.Handle(ctx, redacted)
+}
+
+func (handler redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = redactAttr(attr)
+	}
+	return redactingHandler{handler.Handler.WithAttrs(redacted)}
+}
+
+func (handler redactingHandler) WithGroup(name string) slog.Handler {
+	return redactingHandler{handler.Handler.WithGroup(name)}
+}
+
+func redactAttr(attr slog.Attr) slog.Attr {
+	attr.Value = attr.Value.Resolve()
+	if sensitiveLogKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, redactedLogValue)
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		group := attr.Value.Group()
+		redacted := make([]any, len(group))
+		for i, member := range group {
+			redacted[i] = redactAttr(member)
+		}
+		return slog.Group(attr.Key, redacted...)
+	}
+	return attr
+}
+
+// A slog.Handler that discards every record
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool   { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error  { return nil }
+func (handler discardHandler) WithAttrs([]slog.Attr) slog.Handler { return handler }
+func (handler discardHandler) WithGroup(string) slog.Handler      { return handler }
@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// A slog.LogValuer resolving to a subject identifier
+type testSubject struct{}
+
+func (testSubject) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("format", "email"), slog.String("email", "user@example.com"))
+}
+
+func TestReceiverLoggerRedactsSensitiveAttributes(t *testing.T) {
+	tests := []struct {
+		name   string
+		log    func(logger *slog.Logger)
+		secret string
+		kept   string
+	}{
+		{
+			name:   "token",
+			log:    func(logger *slog.Logger) { logger.Info("polling", slog.String("token", "secret-token")) },
+			secret: "secret-token",
+		},
+		{
+			name:   "authorization header, any case",
+			log:    func(logger *slog.Logger) { logger.Info("pushed", "Authorization", "Bearer secret-token") },
+			secret: "secret-token",
+		},
+		{
+			name:   "email subject",
+			log:    func(logger *slog.Logger) { logger.Info("event", slog.String("email", "user@example.com")) },
+			secret: "user@example.com",
+		},
+		{
+			name: "subject inside a group",
+			log: func(logger *slog.Logger) {
+				logger.Info("event", slog.Group("event", slog.String("type", "session-revoked"), slog.Group("sub_id", slog.String("email", "user@example.com"))))
+			},
+			secret: "user@example.com",
+			kept:   "session-revoked",
+		},
+		{
+			name: "nested group member",
+			log: func(logger *slog.Logger) {
+				logger.Info("event", slog.Group("outer", slog.Group("inner", slog.String("phone_number", "+15555550100"))))
+			},
+			secret: "+15555550100",
+		},
+		{
+			name:   "LogValuer resolving to a group",
+			log:    func(logger *slog.Logger) { logger.Info("event", slog.Any("target", testSubject{})) },
+			secret: "user@example.com",
+			kept:   "format",
+		},
+		{
+			name:   "With attrs",
+			log:    func(logger *slog.Logger) { logger.With("access_token", "secret-token").Info("polling") },
+			secret: "secret-token",
+		},
+		{
+			name: "With attrs inside WithGroup",
+			log: func(logger *slog.Logger) {
+				logger.WithGroup("request").With(slog.String("authorization_header", "Bearer secret-token")).Info("polling", "sub", "user-1")
+			},
+			secret: "secret-token",
+		},
+		{
+			name:   "record attrs inside WithGroup",
+			log:    func(logger *slog.Logger) { logger.WithGroup("request").Info("polling", "sub", "user-1") },
+			secret: "user-1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			logger := newReceiverLogger(slog.New(slog.NewJSONHandler(&output, nil)), "https://tr.example.com")
+			test.log(logger)
+
+			logged := output.String()
+			if strings.Contains(logged, test.secret) {
+				t.Fatalf("logged %s, want %q redacted", logged, test.secret)
+			}
+			if !strings.Contains(logged, redactedLogValue) {
+				t.Fatalf("logged %s, want %s", logged, redactedLogValue)
+			}
+			if test.kept != "" && !strings.Contains(logged, test.kept) {
+				t.Fatalf("logged %s, want %q kept", logged, test.kept)
+			}
+			if !strings.Contains(logged, "https://tr.example.com") {
+				t.Fatalf("logged %s, want the transmitter kept", logged)
+			}
+		})
+	}
+}
+
+func TestReceiverLoggerWithoutLoggerDiscards(t *testing.T) {
+	logger := newReceiverLogger(nil, "https://tr.example.com")
+	if logger.Enabled(context.Background(), slog.LevelError) {
+		t.Fatal("Enabled() = true, want a nil logger to discard everything")
+	}
+}
@@ -0,0 +1,410 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+// Configures a ReceiverManager
+type ManagerConfig struct {
+	// Handler is called for the events of every receiver run by the
+	// manager. EventInfoFromContext returns the name of the receiver the
+	// event was received by, and the issuer of its SET, or the receiver's
+	// TransmitterUrl when the SET has no iss claim
+	//
+	// Required
+	Handler Handler
+
+	// Middleware defines the middlewares wrapping Handler for every
+	// receiver, the first one being the outermost. The Middleware of each
+	// receiver's ReceiverConfig wraps these
+	//
+	// Optional
+	Middleware []Middleware
+
+	// Logger defines the logger of the receivers that aren't configured
+	// with their own, with the name of the receiver attached
+	//
+	// Optional, defaults to discarding the logs
+	Logger *slog.Logger
+}
+
+// Runs several receivers, typically one per transmitter, and passes the
+// events of all of them to a single Handler.
+//
+// Each receiver is added with its own ReceiverConfig, except for its
+// Handler which is the manager's. Receivers can be added and removed while
+// the manager is running, and are shut down together by Shutdown
+type ReceiverManager struct {
+	handler Handler
+	logger  *slog.Logger
+
+	mu        sync.RWMutex
+	receivers map[string]*managedReceiver
+	closed    bool
+}
+
+// A receiver run by a manager
+type managedReceiver struct {
+	// receiver is nil while the receiver is being configured
+	receiver SsfReceiver
+
+	// pushPath defines the path the receiver's pushed SETs are received
+	// at, empty for polling receivers
+	pushPath string
+}
+
+// The health of the receivers run by a manager, as reported by Health
+type ManagerHealth struct {
+	// Healthy reports whether all the receivers are healthy
+	Healthy bool `json:"healthy"`
+
+	// Ready reports whether there is at least one receiver, and all the
+	// receivers are ready
+	Ready bool `json:"ready"`
+
+	// Receivers defines the health of each receiver, by name
+	Receivers map[string]Health `json:"receivers"`
+}
+
+// Creates a manager without receivers
+func NewReceiverManager(cfg ManagerConfig) (*ReceiverManager, error) {
+	if cfg.Handler == nil {
+		return nil, errors.New("Manager Config - missing required field Handler")
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.New(discardHandler{})
+	}
+	return &ReceiverManager{
+		handler:   Chain(cfg.Handler, cfg.Middleware...),
+		logger:    logger,
+		receivers: map[string]*managedReceiver{},
+	}, nil
+}
+
+// Configures a receiver and starts it under the given name, passing its
+// events to the manager's Handler. cfg must not have a Handler.
+//
+// Polling starts right away. A push receiver's SETs are accepted once the
+// manager's PushHandler is served at its PushEndpointUrl, which routes them
+// by their request path, the receiver's PushPath. Until the receiver is
+// configured the PushHandler answers 503, so the transmitter retries the
+// verification event sent when the stream is created
+func (manager *ReceiverManager) Add(name string, cfg ReceiverConfig) error {
+	if name == "" {
+		return errors.New("receiver name is required")
+	}
+	if cfg.Handler != nil {
+		return fmt.Errorf("receiver %s: events are passed to the manager's Handler, ReceiverConfig.Handler must not be set", name)
+	}
+
+	entry := &managedReceiver{}
+	if cfg.PushEndpointUrl != "" {
+		entry.pushPath = cfg.PushPath
+		if entry.pushPath == "" {
+			pushUrl, err := url.Parse(cfg.PushEndpointUrl)
+			if err != nil {
+				return fmt.Errorf("receiver %s: invalid PushEndpointUrl: %w", name, err)
+			}
+			entry.pushPath = pushUrl.Path
+		}
+		if entry.pushPath == "" {
+			entry.pushPath = "/"
+		}
+		if !strings.HasPrefix(entry.pushPath, "/") {
+			return fmt.Errorf("receiver %s: PushPath %s must start with /", name, entry.pushPath)
+		}
+	}
+
+	// The name is reserved while the receiver is configured, which takes
+	// a few requests to the transmitter
+	manager.mu.Lock()
+	err := manager.reserve(name, entry)
+	manager.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	cfg.Handler = manager.receiverHandler(name, cfg.TransmitterUrl)
+	if cfg.Logger == nil {
+		cfg.Logger = manager.logger.With(slog.String("receiver", name))
+	}
+	receiver, err := ConfigureSsfReceiver(cfg)
+
+	manager.mu.Lock()
+	if err != nil {
+		delete(manager.receivers, name)
+		manager.mu.Unlock()
+		return fmt.Errorf("receiver %s: %w", name, err)
+	}
+	closed := manager.closed
+	if closed {
+		// Shutdown skipped the receiver while it was configured
+		delete(manager.receivers, name)
+	} else {
+		entry.receiver = receiver
+	}
+	manager.mu.Unlock()
+
+	if closed {
+		return errors.Join(
+			fmt.Errorf("receiver %s: manager has been shut down", name),
+			receiver.Shutdown(context.Background()))
+	}
+	manager.logger.Info("receiver added", slog.String("receiver", name))
+	return nil
+}
+
+// Checks that a receiver can be added under the name, and reserves it.
+// Must be called with mu held
+func (manager *ReceiverManager) reserve(name string, entry *managedReceiver) error {
+	if manager.closed {
+		return errors.New("manager has been shut down")
+	}
+	if _, found := manager.receivers[name]; found {
+		return fmt.Errorf("receiver %s already exists", name)
+	}
+	if entry.pushPath != "" {
+		for otherName, other := range manager.receivers {
+			if other.pushPath == entry.pushPath {
+				return fmt.Errorf("receiver %s: push path %s is already used by receiver %s", name, entry.pushPath, otherName)
+			}
+		}
+	}
+	manager.receivers[name] = entry
+	return nil
+}
+
+// Adds all the given receivers, by name, configuring them concurrently.
+// If any of them fails to start, the others are shut down again and the
+// errors are returned
+func (manager *ReceiverManager) Start(cfgs map[string]ReceiverConfig) error {
+	var mu sync.Mutex
+	var started []string
+	var errs []error
+	var wg sync.WaitGroup
+	for name, cfg := range cfgs {
+		wg.Add(1)
+		go func(name string, cfg ReceiverConfig) {
+			defer wg.Done()
+			err := manager.Add(name, cfg)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				started = append(started, name)
+			}
+		}(name, cfg)
+	}
+	wg.Wait()
+	if len(errs) == 0 {
+		return nil
+	}
+
+	for _, name := range started {
+		errs = append(errs, manager.Remove(context.Background(), name))
+	}
+	return errors.Join(errs...)
+}
+
+// Shuts the named receiver down, see SsfReceiver.Shutdown, and removes it
+// from the manager. A receiver that is still being configured can't be
+// removed
+func (manager *ReceiverManager) Remove(ctx context.Context, name string) error {
+	manager.mu.Lock()
+	entry, found := manager.receivers[name]
+	if !found {
+		manager.mu.Unlock()
+		return fmt.Errorf("receiver %s not found", name)
+	}
+	if entry.receiver == nil {
+		manager.mu.Unlock()
+		return fmt.Errorf("receiver %s is starting", name)
+	}
+	delete(manager.receivers, name)
+	manager.mu.Unlock()
+
+	err := entry.receiver.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("receiver %s: %w", name, err)
+	}
+	manager.logger.Info("receiver removed", slog.String("receiver", name))
+	return nil
+}
+
+// Returns the named receiver, if it is running
+func (manager *ReceiverManager) Receiver(name string) (SsfReceiver, bool) {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+
+	entry, found := manager.receivers[name]
+	if !found || entry.receiver == nil {
+		return nil, false
+	}
+	return entry.receiver, true
+}
+
+// Returns the names of the receivers, including those being configured,
+// in alphabetical order
+func (manager *ReceiverManager) Names() []string {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+
+	names := make([]string, 0, len(manager.receivers))
+	for name := range manager.receivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Shuts all the receivers down concurrently, see SsfReceiver.Shutdown.
+// Receivers can't be added once the manager has been shut down
+func (manager *ReceiverManager) Shutdown(ctx context.Context) error {
+	manager.mu.Lock()
+	manager.closed = true
+	running := map[string]SsfReceiver{}
+	for name, entry := range manager.receivers {
+		if entry.receiver != nil {
+			running[name] = entry.receiver
+			delete(manager.receivers, name)
+		}
+	}
+	manager.mu.Unlock()
+
+	errs := make(chan error, len(running))
+	var wg sync.WaitGroup
+	for name, receiver := range running {
+		wg.Add(1)
+		go func(name string, receiver SsfReceiver) {
+			defer wg.Done()
+			err := receiver.Shutdown(ctx)
+			if err != nil {
+				errs <- fmt.Errorf("receiver %s: %w", name, err)
+			}
+		}(name, receiver)
+	}
+	wg.Wait()
+	close(errs)
+
+	var shutdownErrs []error
+	for err := range errs {
+		shutdownErrs = append(shutdownErrs, err)
+	}
+	return errors.Join(shutdownErrs...)
+}
+
+// Reports the health of every receiver, fetched concurrently. Receivers
+// being configured are healthy but not ready
+func (manager *ReceiverManager) Health(ctx context.Context) ManagerHealth {
+	manager.mu.RLock()
+	entries := make(map[string]SsfReceiver, len(manager.receivers))
+	for name, entry := range manager.receivers {
+		entries[name] = entry.receiver
+	}
+	manager.mu.RUnlock()
+
+	health := ManagerHealth{Healthy: true, Ready: len(entries) > 0, Receivers: map[string]Health{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, receiver := range entries {
+		wg.Add(1)
+		go func(name string, receiver SsfReceiver) {
+			defer wg.Done()
+			receiverHealth := Health{Healthy: true, Problems: []string{"receiver is starting"}}
+			if receiver != nil {
+				receiverHealth = receiver.Health(ctx)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			health.Receivers[name] = receiverHealth
+			health.Healthy = health.Healthy && receiverHealth.Healthy
+			health.Ready = health.Ready && receiverHealth.Ready
+		}(name, receiver)
+	}
+	wg.Wait()
+	return health
+}
+
+// Returns an http.Handler reporting the health of all the receivers as
+// JSON, like SsfReceiver.HealthHandler. Requests to a path ending in
+// /readyz respond with 200 when all the receivers are ready, any other
+// path responds with 200 when they are all healthy
+func (manager *ReceiverManager) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		health := manager.Health(r.Context())
+
+		ok := health.Healthy
+		if strings.HasSuffix(r.URL.Path, "/readyz") {
+			ok = health.Ready
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if ok {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(health)
+	})
+}
+
+// Returns an http.Handler passing pushed SETs to the push receiver whose
+// PushPath, or the path of its PushEndpointUrl, is the request's path. It
+// answers 503 for receivers being configured, and 404 for paths no
+// receiver is pushed to
+func (manager *ReceiverManager) PushHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		manager.mu.RLock()
+		var target *managedReceiver
+		for _, entry := range manager.receivers {
+			if entry.pushPath != "" && entry.pushPath == r.URL.Path {
+				target = entry
+				break
+			}
+		}
+		var receiver SsfReceiver
+		if target != nil {
+			receiver = target.receiver
+		}
+		manager.mu.RUnlock()
+
+		switch {
+		case target == nil:
+			writePushError(w, http.StatusNotFound, "invalid_request", "no receiver is served at this path")
+		case receiver == nil:
+			writePushError(w, http.StatusServiceUnavailable, "invalid_request", "receiver is starting")
+		default:
+			receiver.PushHandler().ServeHTTP(w, r)
+		}
+	})
+}
+
+// Returns the handler of a receiver, passing its events to the manager's
+// handler with the receiver's name and issuer in their EventInfo
+func (manager *ReceiverManager) receiverHandler(name string, transmitterUrl string) Handler {
+	return func(ctx context.Context, event events.SsfEvent) error {
+		info, _ := EventInfoFromContext(ctx)
+		info.Receiver = name
+		if info.Issuer == "" {
+			info.Issuer = transmitterUrl
+		}
+		return manager.handler(ContextWithEventInfo(ctx, info), event)
+	}
+}
@@ -0,0 +1,256 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+func newTestManager(t *testing.T) *ReceiverManager {
+	t.Helper()
+	manager, err := NewReceiverManager(ManagerConfig{
+		Handler: func(ctx context.Context, event events.SsfEvent) error { return nil },
+	})
+	if err != nil {
+		t.Fatalf("NewReceiverManager() error = %v", err)
+	}
+	t.Cleanup(func() { manager.Shutdown(context.Background()) })
+	return manager
+}
+
+// Returns the configuration of a receiver polling the fake transmitter,
+// without a Handler so it can be added to a manager
+func managedReceiverConfig(transmitter *fakeTransmitter) ReceiverConfig {
+	return ReceiverConfig{
+		TransmitterUrl:          transmitter.url(),
+		TransmitterPollUrl:      transmitter.url() + "/poll",
+		AuthorizationToken:      "token",
+		EventsRequested:         []events.EventType{events.SessionRevoked},
+		MetadataRefreshInterval: -1,
+	}
+}
+
+func TestManagerAddsAndRemovesConcurrently(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	manager := newTestManager(t)
+
+	const count = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*count)
+	for i := 0; i < count; i++ {
+		wg.Add(2)
+		name := fmt.Sprintf("receiver-%d", i)
+		go func() {
+			defer wg.Done()
+			errs <- manager.Add(name, managedReceiverConfig(transmitter))
+		}()
+		// Readers run while the receivers are being configured
+		go func() {
+			defer wg.Done()
+			manager.Names()
+			manager.Receiver(name)
+			manager.Health(context.Background())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if names := manager.Names(); len(names) != count {
+		t.Fatalf("Names() = %v, want %d receivers", names, count)
+	}
+
+	errs = make(chan error, count)
+	for _, name := range manager.Names() {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			errs <- manager.Remove(context.Background(), name)
+		}(name)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Remove() error = %v", err)
+		}
+	}
+	if names := manager.Names(); len(names) != 0 {
+		t.Fatalf("Names() = %v after removing all the receivers", names)
+	}
+}
+
+func TestManagerAddsANameOnce(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	manager := newTestManager(t)
+
+	const count = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- manager.Add("receiver", managedReceiverConfig(transmitter))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	added := 0
+	for err := range errs {
+		if err == nil {
+			added++
+		}
+	}
+	if added != 1 {
+		t.Fatalf("Add() succeeded %d times for the same name, want once", added)
+	}
+	if transmitter.streamRequests("POST") != 1 {
+		t.Fatalf("%d streams were created, want 1", transmitter.streamRequests("POST"))
+	}
+}
+
+func TestManagerShutdownDuringAdd(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	transmitter.createGate = make(chan struct{})
+	manager := newTestManager(t)
+
+	const count = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			errs <- manager.Add(name, managedReceiverConfig(transmitter))
+		}(fmt.Sprintf("receiver-%d", i))
+	}
+	waitFor(t, func() bool { return transmitter.waitingCreates() == count })
+
+	// The receivers are still being configured when the manager shuts
+	// down, so Add shuts them down itself
+	err := manager.Shutdown(context.Background())
+	close(transmitter.createGate)
+	wg.Wait()
+	close(errs)
+	if err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	for err := range errs {
+		if err == nil {
+			t.Fatal("Add() of a receiver configured during Shutdown succeeded")
+		}
+	}
+
+	if names := manager.Names(); len(names) != 0 {
+		t.Fatalf("Names() = %v after Shutdown", names)
+	}
+	if err := manager.Add("late", managedReceiverConfig(transmitter)); err == nil {
+		t.Fatal("Add() after Shutdown succeeded")
+	}
+}
+
+// Pushes a SET to the handler at the given path
+func pushSetTo(handler http.Handler, path string, set string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(set))
+	req.Header.Set("Content-Type", "application/secevent+jwt")
+	req.Header.Set("Authorization", testPushAuthorization)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestManagerPushHandler(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	var mu sync.Mutex
+	var handledBy []string
+	manager, err := NewReceiverManager(ManagerConfig{
+		Handler: func(ctx context.Context, event events.SsfEvent) error {
+			info, _ := EventInfoFromContext(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			handledBy = append(handledBy, info.Receiver)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewReceiverManager() error = %v", err)
+	}
+	t.Cleanup(func() { manager.Shutdown(context.Background()) })
+
+	pushConfig := func(pushUrl string, pushPath string) ReceiverConfig {
+		cfg := managedReceiverConfig(transmitter)
+		cfg.TransmitterPollUrl = ""
+		cfg.PushEndpointUrl = pushUrl
+		cfg.PushPath = pushPath
+		cfg.PushAuthorizationHeader = testPushAuthorization
+		return cfg
+	}
+	// A proxy forwards the public push URL of alpha to another path
+	alpha := pushConfig("https://proxy.example.com/public/alpha", "/ssf/alpha")
+	beta := pushConfig("https://receiver.example.com/ssf/beta", "")
+	if err := manager.Add("alpha", alpha); err != nil {
+		t.Fatalf("Add(alpha) error = %v", err)
+	}
+	if err := manager.Add("beta", beta); err != nil {
+		t.Fatalf("Add(beta) error = %v", err)
+	}
+
+	jti := 0
+	push := func(path string, wantStatus int, wantReceiver string) {
+		t.Helper()
+		jti++
+		mu.Lock()
+		before := len(handledBy)
+		mu.Unlock()
+
+		response := pushSetTo(manager.PushHandler(), path, testSet(t, fmt.Sprintf("jti-%d", jti), nil))
+		if response.Code != wantStatus {
+			t.Fatalf("push to %s answered %d %s, want %d", path, response.Code, response.Body, wantStatus)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		handled := handledBy[before:]
+		if wantReceiver == "" && len(handled) != 0 {
+			t.Fatalf("push to %s was handled by %v, want it not handled", path, handled)
+		}
+		if wantReceiver != "" && (len(handled) != 1 || handled[0] != wantReceiver) {
+			t.Fatalf("push to %s was handled by %v, want %s", path, handled, wantReceiver)
+		}
+	}
+
+	push("/ssf/alpha", http.StatusAccepted, "alpha")
+	push("/public/alpha", http.StatusNotFound, "")
+	push("/ssf/beta", http.StatusAccepted, "beta")
+	push("/ssf/gamma", http.StatusNotFound, "")
+
+	// A push path is served by a single receiver
+	if err := manager.Add("gamma", pushConfig("https://receiver.example.com/ssf/gamma", "/ssf/beta")); err == nil {
+		t.Fatal("Add(gamma) accepted the push path of beta")
+	}
+	if err := manager.Add("gamma", pushConfig("https://receiver.example.com/ssf/gamma", "ssf/gamma")); err == nil {
+		t.Fatal("Add(gamma) accepted a relative push path")
+	}
+
+	if err := manager.Remove(context.Background(), "alpha"); err != nil {
+		t.Fatalf("Remove(alpha) error = %v", err)
+	}
+	push("/ssf/alpha", http.StatusNotFound, "")
+	push("/ssf/beta", http.StatusAccepted, "beta")
+
+	if err := manager.Add("alpha", alpha); err != nil {
+		t.Fatalf("Add(alpha) again error = %v", err)
+	}
+	push("/ssf/alpha", http.StatusAccepted, "alpha")
+}
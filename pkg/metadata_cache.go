@@ -0,0 +1,223 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMetadataRefreshInterval is how often the transmitter metadata is
+// refreshed when the transmitter does not send any caching headers
+const DefaultMetadataRefreshInterval = time.Hour
+
+// minMetadataRefreshInterval bounds how often the cache will hit the
+// transmitter, regardless of what the caching headers ask for
+const minMetadataRefreshInterval = 30 * time.Second
+
+// Caches a transmitter's configuration metadata and keeps it up to date.
+//
+// The cache honors the Cache-Control max-age, Expires and ETag headers
+// returned by the transmitter. When started it refreshes the metadata in
+// the background and calls the registered change hooks whenever the
+// metadata returned by the transmitter differs from the cached copy
+type TransmitterMetadataCache struct {
+	// issuer defines the transmitter issuer the metadata is fetched for
+	issuer string
+
+	// refreshInterval defines how long the metadata is considered fresh
+	// when the transmitter doesn't send any caching headers
+	refreshInterval time.Duration
+
+	// requestTimeout bounds each metadata request, 0 uses
+	// DefaultRequestTimeout
+	requestTimeout time.Duration
+
+	mu        sync.RWMutex
+	config    *TransmitterConfig
+	etag      string
+	expiresAt time.Time
+	onChange  []func(previous *TransmitterConfig, current *TransmitterConfig)
+
+	// stop and done are used to stop the background refresh routine
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Creates a metadata cache for the given transmitter issuer. A
+// refreshInterval of 0 uses DefaultMetadataRefreshInterval
+func NewTransmitterMetadataCache(issuer string, refreshInterval time.Duration) *TransmitterMetadataCache {
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultMetadataRefreshInterval
+	}
+	return &TransmitterMetadataCache{issuer: issuer, refreshInterval: refreshInterval}
+}
+
+// Returns the cached metadata, fetching it from the transmitter first if
+// nothing is cached yet or the cached copy has expired
+func (cache *TransmitterMetadataCache) Get(ctx context.Context) (*TransmitterConfig, error) {
+	cache.mu.RLock()
+	config, expiresAt := cache.config, cache.expiresAt
+	cache.mu.RUnlock()
+
+	if config != nil && time.Now().Before(expiresAt) {
+		return config, nil
+	}
+
+	_, err := cache.Refresh(ctx)
+	if err != nil {
+		if config != nil {
+			// Serve the stale copy rather than failing
+			return config, nil
+		}
+		return nil, err
+	}
+
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	return cache.config, nil
+}
+
+// Caches the given metadata as already expired, so it is served until the
+// next successful refresh replaces it
+func (cache *TransmitterMetadataCache) seed(config *TransmitterConfig) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.config = config
+	cache.expiresAt = time.Now()
+}
+
+// Registers a hook that is called with the previous and current metadata
+// every time a refresh returns metadata that differs from the cached copy
+func (cache *TransmitterMetadataCache) OnChange(hook func(previous *TransmitterConfig, current *TransmitterConfig)) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.onChange = append(cache.onChange, hook)
+}
+
+// Fetches the metadata from the transmitter, revalidating the cached copy
+// with its ETag when there is one.
+//
+// Returns whether the metadata changed
+func (cache *TransmitterMetadataCache) Refresh(ctx context.Context) (bool, error) {
+	cache.mu.RLock()
+	etag := cache.etag
+	cache.mu.RUnlock()
+
+	config, header, err := fetchTransmitterConfig(ctx, cache.issuer, etag, cache.requestTimeout)
+	if err != nil {
+		return false, err
+	}
+
+	cache.mu.Lock()
+	cache.expiresAt = time.Now().Add(cache.freshnessLifetime(header))
+	if config == nil {
+		// Not modified, only the expiry is updated
+		cache.mu.Unlock()
+		return false, nil
+	}
+
+	previous := cache.config
+	cache.config = config
+	cache.etag = header.Get("ETag")
+	hooks := append([]func(previous *TransmitterConfig, current *TransmitterConfig){}, cache.onChange...)
+	cache.mu.Unlock()
+
+	if previous == nil || reflect.DeepEqual(previous, config) {
+		return false, nil
+	}
+
+	for _, hook := range hooks {
+		hook(previous, config)
+	}
+	return true, nil
+}
+
+// Starts refreshing the metadata in the background. Each refresh is
+// scheduled for when the cached copy expires
+func (cache *TransmitterMetadataCache) Start() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.stop != nil {
+		return
+	}
+
+	cache.stop = make(chan struct{})
+	cache.done = make(chan struct{})
+	go cache.refreshLoop(cache.stop, cache.done)
+}
+
+// Stops the background refresh routine started with Start
+func (cache *TransmitterMetadataCache) Stop() {
+	cache.mu.Lock()
+	stop, done := cache.stop, cache.done
+	cache.stop, cache.done = nil, nil
+	cache.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+func (cache *TransmitterMetadataCache) refreshLoop(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	for {
+		cache.mu.RLock()
+		wait := time.Until(cache.expiresAt)
+		cache.mu.RUnlock()
+		if wait < minMetadataRefreshInterval {
+			wait = minMetadataRefreshInterval
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), minMetadataRefreshInterval)
+		_, err := cache.Refresh(ctx)
+		cancel()
+		if err != nil {
+			// Keep serving the cached copy and try again shortly
+			cache.mu.Lock()
+			cache.expiresAt = time.Now().Add(minMetadataRefreshInterval)
+			cache.mu.Unlock()
+		}
+	}
+}
+
+// Determines how long a response stays fresh from its Cache-Control and
+// Expires headers, falling back to the cache's refresh interval
+func (cache *TransmitterMetadataCache) freshnessLifetime(header http.Header) time.Duration {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if directive == "no-cache" || directive == "no-store" {
+			return 0
+		}
+		if value, found := strings.CutPrefix(directive, "max-age="); found {
+			seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+			if err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return time.Until(expiresAt)
+	}
+
+	return cache.refreshInterval
+}
@@ -0,0 +1,163 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A transmitter serving configuration metadata with an ETag, answering
+// 304 when the receiver already has the current version
+type fakeMetadataServer struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	jwksUri  string
+	version  int
+	header   http.Header
+	requests []string
+}
+
+func newFakeMetadataServer(t *testing.T) *fakeMetadataServer {
+	metadata := &fakeMetadataServer{version: 1, header: http.Header{}}
+	metadata.server = httptest.NewServer(http.HandlerFunc(metadata.serveHTTP))
+	metadata.jwksUri = metadata.server.URL + "/jwks"
+	t.Cleanup(metadata.server.Close)
+	return metadata
+}
+
+func (metadata *fakeMetadataServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	metadata.mu.Lock()
+	defer metadata.mu.Unlock()
+	metadata.requests = append(metadata.requests, r.Header.Get("If-None-Match"))
+
+	for name, values := range metadata.header {
+		w.Header()[name] = values
+	}
+	etag := `"v` + strconv.Itoa(metadata.version) + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	json.NewEncoder(w).Encode(TransmitterConfig{Issuer: metadata.server.URL, JwksUri: metadata.jwksUri})
+}
+
+// Changes the metadata, or only its version when jwksUri is unchanged
+func (metadata *fakeMetadataServer) update(jwksUri string) {
+	metadata.mu.Lock()
+	defer metadata.mu.Unlock()
+	metadata.version++
+	metadata.jwksUri = jwksUri
+}
+
+// Returns the If-None-Match header of every request so far
+func (metadata *fakeMetadataServer) revalidations() []string {
+	metadata.mu.Lock()
+	defer metadata.mu.Unlock()
+	return append([]string{}, metadata.requests...)
+}
+
+func TestMetadataCacheRevalidatesWithETag(t *testing.T) {
+	metadata := newFakeMetadataServer(t)
+	cache := NewTransmitterMetadataCache(metadata.server.URL, 0)
+
+	first, err := cache.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	changed, err := cache.Refresh(context.Background())
+	if err != nil || changed {
+		t.Fatalf("Refresh() = %v, %v, want unchanged", changed, err)
+	}
+	second, err := cache.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if second != first {
+		t.Fatal("Get() returned new metadata after a 304, want the cached copy")
+	}
+
+	if got := metadata.revalidations(); len(got) != 2 || got[0] != "" || got[1] != `"v1"` {
+		t.Fatalf("If-None-Match headers = %q, want none then \"v1\"", got)
+	}
+}
+
+func TestMetadataCacheFreshnessLifetime(t *testing.T) {
+	cache := NewTransmitterMetadataCache("https://tr.example.com", time.Hour)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"no caching headers", http.Header{}, time.Hour},
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=600"}}, 10 * time.Minute},
+		{"quoted max-age", http.Header{"Cache-Control": {`max-age="60"`}}, time.Minute},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache"}}, 0},
+		{"no-store wins over max-age", http.Header{"Cache-Control": {"no-store, max-age=600"}}, 0},
+		{"max-age wins over Expires", http.Header{"Cache-Control": {"max-age=60"}, "Expires": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}}, time.Minute},
+		{"invalid Expires", http.Header{"Expires": {"0"}}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := cache.freshnessLifetime(test.header); got != test.want {
+				t.Fatalf("freshnessLifetime() = %s, want %s", got, test.want)
+			}
+		})
+	}
+
+	expires := http.Header{"Expires": {time.Now().Add(30 * time.Minute).UTC().Format(http.TimeFormat)}}
+	if got := cache.freshnessLifetime(expires); got < 29*time.Minute || got > 30*time.Minute {
+		t.Fatalf("freshnessLifetime() = %s, want about 30m from Expires", got)
+	}
+}
+
+func TestMetadataCacheServesFreshCopyWithoutRequests(t *testing.T) {
+	metadata := newFakeMetadataServer(t)
+	metadata.header.Set("Cache-Control", "max-age=3600")
+	cache := NewTransmitterMetadataCache(metadata.server.URL, 0)
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.Get(context.Background()); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+	}
+	if got := len(metadata.revalidations()); got != 1 {
+		t.Fatalf("transmitter got %d requests, want 1 while the metadata is fresh", got)
+	}
+}
+
+func TestMetadataCacheCallsOnChangeOnRealChanges(t *testing.T) {
+	metadata := newFakeMetadataServer(t)
+	cache := NewTransmitterMetadataCache(metadata.server.URL, 0)
+
+	var changes []string
+	cache.OnChange(func(previous *TransmitterConfig, current *TransmitterConfig) {
+		changes = append(changes, previous.JwksUri+" -> "+current.JwksUri)
+	})
+	if _, err := cache.Get(context.Background()); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	oldJwksUri := metadata.jwksUri
+
+	// A new ETag with the same metadata isn't a change
+	metadata.update(oldJwksUri)
+	if changed, err := cache.Refresh(context.Background()); err != nil || changed {
+		t.Fatalf("Refresh() = %v, %v, want unchanged", changed, err)
+	}
+
+	metadata.update(metadata.server.URL + "/keys")
+	if changed, err := cache.Refresh(context.Background()); err != nil || !changed {
+		t.Fatalf("Refresh() = %v, %v, want changed", changed, err)
+	}
+
+	want := oldJwksUri + " -> " + metadata.server.URL + "/keys"
+	if len(changes) != 1 || changes[0] != want {
+		t.Fatalf("OnChange calls = %q, want [%q]", changes, want)
+	}
+}
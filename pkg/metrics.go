@@ -0,0 +1,131 @@
+package pkg
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+// The reasons a SET is rejected, as reported to Metrics.SetRejected
+const (
+	// The SET is not a well formed JWT
+	SetRejectedMalformed = "malformed"
+
+	// The SET does not contain an events claim
+	SetRejectedMissingEvents = "missing_events"
+
+	// One of the SET's events is unknown or cannot be decoded
+	SetRejectedInvalidEvent = "invalid_event"
+)
+
+// Receives the receiver's measurements. Each method maps to a single
+// counter or histogram, so it can be backed by Prometheus or OpenTelemetry
+// instruments directly, for instance:
+//
+//	EventReceived      -> ssf_events_received_total{event_type}
+//	SetRejected        -> ssf_set_rejections_total{reason}
+//	AcksSent           -> ssf_acks_sent_total
+//	PollFailed         -> ssf_poll_errors_total{status_code}
+//	ObservePollLatency -> ssf_poll_latency_seconds
+//	ObserveEventAge    -> ssf_event_age_seconds{event_type}
+//
+// The methods are called from the receiver's goroutines and must be safe
+// for concurrent use. They should return quickly, since they are called
+// while polling
+type Metrics interface {
+	// Counts an event received from the transmitter, by its event type
+	// URI
+	EventReceived(eventType string)
+
+	// Counts a SET that was rejected, by one of the SetRejected reasons
+	SetRejected(reason string)
+
+	// Counts SETs acknowledged with the transmitter
+	AcksSent(count int)
+
+	// Counts a failed poll request, by the HTTP status code returned by
+	// the transmitter, or 0 when no response was received
+	PollFailed(statusCode int)
+
+	// Records how long a poll request took, including failed ones
+	ObservePollLatency(latency time.Duration)
+
+	// Records how long ago an event happened when it was received, from
+	// its event_timestamp or the SET's iat claim
+	ObserveEventAge(eventType string, age time.Duration)
+}
+
+// A Metrics that discards every measurement
+type nopMetrics struct{}
+
+func (nopMetrics) EventReceived(string)                  {}
+func (nopMetrics) SetRejected(string)                    {}
+func (nopMetrics) AcksSent(int)                          {}
+func (nopMetrics) PollFailed(int)                        {}
+func (nopMetrics) ObservePollLatency(time.Duration)      {}
+func (nopMetrics) ObserveEventAge(string, time.Duration) {}
+
+// An error returned when a SET cannot be parsed, carrying the reason it
+// was rejected
+type setParseError struct {
+	reason string
+	err    error
+}
+
+func (e *setParseError) Error() string {
+	return e.err.Error()
+}
+
+func (e *setParseError) Unwrap() error {
+	return e.err
+}
+
+// Returns the SetRejected reason for an error returned by parseSsfEventSet
+func rejectionReason(err error) string {
+	var parseErr *setParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.reason
+	}
+	return SetRejectedMalformed
+}
+
+// Logs and counts a SET that could not be parsed
+func (receiver *SsfReceiverImplementation) rejectSet(jti string, err error) {
+	reason := rejectionReason(err)
+	receiver.metrics.SetRejected(reason)
+	receiver.logger.Warn("failed to parse SET",
+		slog.String("jti", jti),
+		slog.String("reason", reason),
+		slog.Any("error", err))
+}
+
+// Logs and measures the events of a received SET
+func (receiver *SsfReceiverImplementation) recordReceivedEvents(jti string, method string, claims map[string]interface{}, ssfEvents []events.SsfEvent) {
+	now := time.Now()
+	for _, ssfEvent := range ssfEvents {
+		eventType := ssfEvent.GetEventUri()
+		receiver.metrics.EventReceived(eventType)
+		if happenedAt := eventTime(ssfEvent, claims); !happenedAt.IsZero() {
+			receiver.metrics.ObserveEventAge(eventType, now.Sub(happenedAt))
+		}
+
+		receiver.logger.Debug("received event",
+			slog.String("jti", jti),
+			slog.String("event_type", eventType),
+			slog.String("delivery", method))
+	}
+}
+
+// Returns when the event happened, from its event_timestamp or else the
+// SET's iat claim, or the zero time if neither is set
+func eventTime(ssfEvent events.SsfEvent, claims map[string]interface{}) time.Time {
+	if timestamp := ssfEvent.GetTimestamp(); timestamp > 0 {
+		return time.Unix(timestamp, 0)
+	}
+	if iat, ok := claims["iat"].(float64); ok && iat > 0 {
+		return time.Unix(int64(iat), 0)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A Metrics recording every call, formatted as "Method(argument)". The
+// durations observed are left out since they vary between runs
+type recordingMetrics struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (metrics *recordingMetrics) record(method string, argument any) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	metrics.calls = append(metrics.calls, fmt.Sprintf("%s(%v)", method, argument))
+}
+
+func (metrics *recordingMetrics) EventReceived(eventType string) {
+	metrics.record("EventReceived", eventType)
+}
+
+func (metrics *recordingMetrics) SetRejected(reason string) {
+	metrics.record("SetRejected", reason)
+}
+
+func (metrics *recordingMetrics) AcksSent(count int) {
+	metrics.record("AcksSent", count)
+}
+
+func (metrics *recordingMetrics) PollFailed(statusCode int) {
+	metrics.record("PollFailed", statusCode)
+}
+
+func (metrics *recordingMetrics) ObservePollLatency(latency time.Duration) {
+	metrics.record("ObservePollLatency", "")
+}
+
+func (metrics *recordingMetrics) ObserveEventAge(eventType string, age time.Duration) {
+	metrics.record("ObserveEventAge", eventType)
+}
+
+// Returns the calls recorded since the last call to take
+func (metrics *recordingMetrics) take() []string {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	calls := metrics.calls
+	metrics.calls = nil
+	return calls
+}
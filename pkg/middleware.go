@@ -0,0 +1,124 @@
+package pkg
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+// Wraps a Handler with cross-cutting behavior such as logging, metrics or
+// filtering. A middleware may call the next handler, skip it, or change
+// the error it returns
+type Middleware func(next Handler) Handler
+
+// Wraps the handler with the given middlewares. The first middleware is
+// the outermost one, so it sees each event first and each error last
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Returns a middleware that converts a panic in the rest of the chain into
+// a *PanicError, reported as the failure of that event only
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, event events.SsfEvent) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = &PanicError{Value: r, Stack: debug.Stack()}
+				}
+			}()
+			return next(ctx, event)
+		}
+	}
+}
+
+// Returns a middleware that logs every handled event with its JTI, type and
+// handling duration, at the error level when handling failed
+func Logging(logger *slog.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, event events.SsfEvent) error {
+			start := time.Now()
+			err := next(ctx, event)
+
+			attrs := []slog.Attr{
+				slog.String("event_type", event.GetEventUri()),
+				slog.Duration("duration", time.Since(start)),
+			}
+			if info, ok := EventInfoFromContext(ctx); ok {
+				attrs = append(attrs, slog.String("jti", info.JTI), slog.String("delivery", info.DeliveryMethod))
+			}
+
+			if err != nil {
+				attrs = append(attrs, slog.Any("error", err))
+				logger.LogAttrs(ctx, slog.LevelError, "event handler failed", attrs...)
+			} else {
+				logger.LogAttrs(ctx, slog.LevelInfo, "event handled", attrs...)
+			}
+			return err
+		}
+	}
+}
+
+// Returns a middleware that reports how long the rest of the chain took to
+// handle each event, along with its result
+func Timing(observe func(event events.SsfEvent, duration time.Duration, err error)) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, event events.SsfEvent) error {
+			start := time.Now()
+			err := next(ctx, event)
+			observe(event, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// Describes the SET an event being handled was received in
+type EventInfo struct {
+	// JTI defines the unique id of the SET
+	JTI string
+
+	// Txn defines the transaction id of the SET, if any
+	Txn string
+
+	// Issuer defines the issuer of the SET
+	Issuer string
+
+	// DeliveryMethod defines how the SET was received, either "poll" or
+	// "push"
+	DeliveryMethod string
+
+	// Receiver defines the name of the receiver in its ReceiverManager,
+	// empty for receivers that aren't run by a manager
+	Receiver string
+}
+
+type eventInfoKey struct{}
+
+// Returns a copy of ctx carrying the given EventInfo
+func ContextWithEventInfo(ctx context.Context, info EventInfo) context.Context {
+	return context.WithValue(ctx, eventInfoKey{}, info)
+}
+
+// Returns the EventInfo of the event being handled, available to handlers
+// and middlewares
+func EventInfoFromContext(ctx context.Context) (EventInfo, bool) {
+	info, ok := ctx.Value(eventInfoKey{}).(EventInfo)
+	return info, ok
+}
+
+// Builds the EventInfo of a SET from its claims
+func eventInfoFromClaims(claims map[string]interface{}, jti string, deliveryMethod string) EventInfo {
+	info := EventInfo{JTI: jti, DeliveryMethod: deliveryMethod}
+	if info.JTI == "" {
+		info.JTI, _ = claims["jti"].(string)
+	}
+	info.Txn, _ = claims["txn"].(string)
+	info.Issuer, _ = claims["iss"].(string)
+	return info
+}
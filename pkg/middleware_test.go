@@ -0,0 +1,169 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+// Returns a middleware recording when it sees the event and the error
+func tracingMiddleware(name string, trace *[]string) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, event events.SsfEvent) error {
+			*trace = append(*trace, name+" in")
+			err := next(ctx, event)
+			*trace = append(*trace, name+" out")
+			return err
+		}
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	tests := []struct {
+		name        string
+		middlewares []string
+		want        []string
+	}{
+		{"no middleware", nil, []string{"handler"}},
+		{"one", []string{"a"}, []string{"a in", "handler", "a out"}},
+		{"first is outermost", []string{"a", "b", "c"}, []string{"a in", "b in", "c in", "handler", "c out", "b out", "a out"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var trace []string
+			var middlewares []Middleware
+			for _, name := range test.middlewares {
+				middlewares = append(middlewares, tracingMiddleware(name, &trace))
+			}
+			handler := Chain(func(ctx context.Context, event events.SsfEvent) error {
+				trace = append(trace, "handler")
+				return nil
+			}, middlewares...)
+
+			if err := handler(context.Background(), testEvent("user@example.com", 0)); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if strings.Join(trace, ", ") != strings.Join(test.want, ", ") {
+				t.Fatalf("trace = %v, want %v", trace, test.want)
+			}
+		})
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	handlerErr := errors.New("handler failed")
+	tests := []struct {
+		name      string
+		handler   Handler
+		wantErr   error
+		wantPanic interface{}
+	}{
+		{"success", func(ctx context.Context, event events.SsfEvent) error { return nil }, nil, nil},
+		{"error is kept", func(ctx context.Context, event events.SsfEvent) error { return handlerErr }, handlerErr, nil},
+		{"panic", func(ctx context.Context, event events.SsfEvent) error { panic("boom") }, nil, "boom"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Chain(test.handler, Recovery())(context.Background(), testEvent("user@example.com", 0))
+			if test.wantPanic == nil {
+				if err != test.wantErr {
+					t.Fatalf("handler error = %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+
+			var panicErr *PanicError
+			if !errors.As(err, &panicErr) {
+				t.Fatalf("handler error = %v, want a *PanicError", err)
+			}
+			if panicErr.Value != test.wantPanic || len(panicErr.Stack) == 0 {
+				t.Fatalf("PanicError = %v with %d bytes of stack, want %v with a stack", panicErr.Value, len(panicErr.Stack), test.wantPanic)
+			}
+		})
+	}
+}
+
+func TestLogging(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantLevel string
+		wantMsg   string
+	}{
+		{"success", nil, "INFO", "event handled"},
+		{"failure", errors.New("handler failed"), "ERROR", "event handler failed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&output, nil))
+			handler := Chain(func(ctx context.Context, event events.SsfEvent) error { return test.err }, Logging(logger))
+
+			ctx := ContextWithEventInfo(context.Background(), EventInfo{JTI: "jti-1", DeliveryMethod: "poll"})
+			event := testEvent("user@example.com", 0)
+			if err := handler(ctx, event); err != test.err {
+				t.Fatalf("handler error = %v, want %v", err, test.err)
+			}
+
+			var record map[string]interface{}
+			if err := json.Unmarshal(output.Bytes(), &record); err != nil {
+				t.Fatalf("log output %q: %v", output.String(), err)
+			}
+			want := map[string]interface{}{
+				"level":      test.wantLevel,
+				"msg":        test.wantMsg,
+				"event_type": event.GetEventUri(),
+				"jti":        "jti-1",
+				"delivery":   "poll",
+			}
+			for key, value := range want {
+				if record[key] != value {
+					t.Fatalf("logged %s = %v, want %v", key, record[key], value)
+				}
+			}
+			if _, found := record["duration"]; !found {
+				t.Fatal("duration wasn't logged")
+			}
+			if _, found := record["error"]; found != (test.err != nil) {
+				t.Fatalf("logged error = %v, want it logged only on failure", record["error"])
+			}
+		})
+	}
+}
+
+func TestTiming(t *testing.T) {
+	handlerErr := errors.New("handler failed")
+	var observed []error
+	var durations []time.Duration
+	var types []events.EventType
+	handler := Chain(func(ctx context.Context, event events.SsfEvent) error {
+		time.Sleep(10 * time.Millisecond)
+		if event.(*events.SessionRevokedEvent).EventTimestamp == 1 {
+			return handlerErr
+		}
+		return nil
+	}, Timing(func(event events.SsfEvent, duration time.Duration, err error) {
+		types = append(types, event.GetType())
+		durations = append(durations, duration)
+		observed = append(observed, err)
+	}))
+
+	handler(context.Background(), testEvent("user@example.com", 0))
+	handler(context.Background(), testEvent("user@example.com", 1))
+
+	if len(observed) != 2 || observed[0] != nil || observed[1] != handlerErr {
+		t.Fatalf("observed errors %v, want nil then %v", observed, handlerErr)
+	}
+	for i, duration := range durations {
+		if duration < 10*time.Millisecond || types[i] != events.SessionRevoked {
+			t.Fatalf("observed %v after %s, want a session revoked event after at least 10ms", types[i], duration)
+		}
+	}
+}
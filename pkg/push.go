@@ -0,0 +1,138 @@
+package pkg
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+const TransmitterPushRFC = "urn:ietf:rfc:8935"
+
+// maxPushedSetSize bounds the size of a SET accepted by the push endpoint
+const maxPushedSetSize = 1 << 20
+
+// Returns an http.Handler that receives SETs pushed by the transmitter, as
+// defined by RFC 8935. It must be served at the PushEndpointUrl the
+// receiver was configured with.
+//
+// The events of each pushed SET are passed to the receiver's Handler, or to
+// its PollCallback if no Handler is configured. The SET is accepted once
+// they have been handled, and the transmitter is asked to retry it when
+// the handler fails, or when the SET can't be verified because the
+// transmitter's signing keys are unavailable. SETs that were already
+// processed are accepted without being handled again
+func (receiver *SsfReceiverImplementation) PushHandler() http.Handler {
+	return http.HandlerFunc(receiver.handlePush)
+}
+
+func (receiver *SsfReceiverImplementation) handlePush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if receiver.pushAuthorizationHeader != "" {
+		authorization := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(authorization), []byte(receiver.pushAuthorizationHeader)) != 1 {
+			writePushError(w, http.StatusUnauthorized, "authentication_failed", "invalid authorization header")
+			return
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/secevent+jwt" {
+		writePushError(w, http.StatusBadRequest, "invalid_request", "content type must be application/secevent+jwt")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPushedSetSize+1))
+	if err != nil {
+		writePushError(w, http.StatusBadRequest, "invalid_request", "unable to read request body")
+		return
+	}
+	if len(body) > maxPushedSetSize {
+		writePushError(w, http.StatusBadRequest, "invalid_request", "SET is too large")
+		return
+	}
+
+	// The span continues the transmitter's trace when the request's trace
+	// context was extracted into its context, see ssfotel.PushHandler
+	ctx, span := receiver.startSpan(r.Context(), SpanPush)
+	defer func() { endSpan(span, err) }()
+
+	set := strings.TrimSpace(string(body))
+	info, ssfEvents, err := receiver.parseSet(ctx, "", set, "push")
+	if errors.Is(err, ErrSigningKeysUnavailable) {
+		// The transmitter retries the SET later
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		setErr := setErrorFor(err)
+		writePushError(w, http.StatusBadRequest, setErr.Err, setErr.Description)
+		return
+	}
+
+	setSpanEventInfo(span, info)
+	if receiver.isDuplicate(info.JTI) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	err = receiver.handlePushedEvents(ContextWithEventInfo(contextWithSet(ctx, set), info), ssfEvents)
+	var setErr *SetError
+	if errors.As(err, &setErr) {
+		// Rejected by the handler, the transmitter doesn't retry it
+		receiver.logger.Warn("pushed SET rejected by the handler", slog.String("jti", info.JTI), slog.Any("error", err))
+		writePushError(w, http.StatusBadRequest, setErr.Err, setErr.Description)
+		return
+	}
+	if err != nil {
+		receiver.logger.Warn("pushed SET handling failed", slog.String("jti", info.JTI), slog.Any("error", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	receiver.markProcessed(info.JTI)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Passes the events of a pushed SET to the configured handler. Without a
+// handler they are sent on the events channel once Events has been called,
+// or passed to the poll callback
+func (receiver *SsfReceiverImplementation) handlePushedEvents(ctx context.Context, ssfEvents []events.SsfEvent) error {
+	if receiver.handler == nil {
+		if deliveries := receiver.deliveryChannel(); deliveries != nil {
+			info, _ := EventInfoFromContext(ctx)
+			return receiver.deliverPushed(ctx, deliveries, info, setFromContext(ctx), ssfEvents)
+		}
+		if callback := receiver.callback(); callback != nil {
+			callback(ssfEvents)
+		}
+		return nil
+	}
+
+	for _, ssfEvent := range ssfEvents {
+		err := callHandler(ctx, receiver.handler, ssfEvent)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes an RFC 8935 error response
+func writePushError(w http.ResponseWriter, status int, errorCode string, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(SetError{Err: errorCode, Description: description})
+}
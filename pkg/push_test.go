@@ -0,0 +1,138 @@
+package pkg
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+const testPushAuthorization = "Bearer push-secret"
+
+// Configures a push receiver whose handler records the JTIs of the events
+// it handles, and fails for the JTIs in failing
+func newTestPushReceiver(t *testing.T, transmitter *fakeTransmitter, cfg ReceiverConfig, failing map[string]error) (*SsfReceiverImplementation, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var handled []string
+	cfg.PushEndpointUrl = "https://receiver.example.com/ssf/push"
+	cfg.Handler = func(ctx context.Context, event events.SsfEvent) error {
+		info, _ := EventInfoFromContext(ctx)
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, info.JTI)
+		return failing[info.JTI]
+	}
+	receiver := newTestReceiver(t, transmitter, cfg)
+	return receiver, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, handled...)
+	}
+}
+
+func TestPushHandler(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	receiver, handled := newTestPushReceiver(t, transmitter, ReceiverConfig{
+		PushAuthorizationHeader: testPushAuthorization,
+		DedupStore:              NewMemoryDedupStore(time.Hour, 0),
+	}, map[string]error{
+		"failing":  errors.New("database unavailable"),
+		"rejected": &SetError{Err: "invalid_request", Description: "unsupported subject"},
+	})
+	authorized := http.Header{"Authorization": {testPushAuthorization}}
+
+	tests := []struct {
+		name        string
+		method      string
+		set         string
+		header      http.Header
+		wantStatus  int
+		wantErr     string
+		wantHandled []string
+	}{
+		{"wrong method", http.MethodGet, "", authorized, http.StatusMethodNotAllowed, "", nil},
+		{"missing authorization", http.MethodPost, testSet(t, "unauthorized", nil), nil, http.StatusUnauthorized, "authentication_failed", nil},
+		{"wrong authorization", http.MethodPost, testSet(t, "unauthorized", nil), http.Header{"Authorization": {"Bearer guess"}}, http.StatusUnauthorized, "authentication_failed", nil},
+		{"wrong content type", http.MethodPost, testSet(t, "json", nil), http.Header{"Authorization": {testPushAuthorization}, "Content-Type": {"application/json"}}, http.StatusBadRequest, "invalid_request", nil},
+		{"too large", http.MethodPost, strings.Repeat("a", maxPushedSetSize+1), authorized, http.StatusBadRequest, "invalid_request", nil},
+		{"malformed", http.MethodPost, "not-a-set", authorized, http.StatusBadRequest, "invalid_request", nil},
+		{"accepted", http.MethodPost, testSet(t, "jti-1", nil), authorized, http.StatusAccepted, "", []string{"jti-1"}},
+		{"duplicate", http.MethodPost, testSet(t, "jti-1", nil), authorized, http.StatusAccepted, "", nil},
+		{"handler failure", http.MethodPost, testSet(t, "failing", nil), authorized, http.StatusInternalServerError, "", []string{"failing"}},
+		{"handler failure is retried", http.MethodPost, testSet(t, "failing", nil), authorized, http.StatusInternalServerError, "", []string{"failing"}},
+		{"rejected by the handler", http.MethodPost, testSet(t, "rejected", nil), authorized, http.StatusBadRequest, "invalid_request", []string{"rejected"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := len(handled())
+			req := httptest.NewRequest(test.method, "/ssf/push", strings.NewReader(test.set))
+			req.Header.Set("Content-Type", "application/secevent+jwt")
+			for name, values := range test.header {
+				req.Header[name] = values
+			}
+			response := httptest.NewRecorder()
+			receiver.PushHandler().ServeHTTP(response, req)
+
+			if response.Code != test.wantStatus {
+				t.Fatalf("push answered %d %s, want %d", response.Code, response.Body, test.wantStatus)
+			}
+			if test.wantErr != "" {
+				var setErr SetError
+				if err := json.Unmarshal(response.Body.Bytes(), &setErr); err != nil || setErr.Err != test.wantErr || setErr.Description == "" {
+					t.Fatalf("push answered %s, want an %s error", response.Body, test.wantErr)
+				}
+				if contentType := response.Header().Get("Content-Type"); contentType != "application/json" {
+					t.Fatalf("error Content-Type = %q, want application/json", contentType)
+				}
+			}
+			if got := handled()[before:]; strings.Join(got, ",") != strings.Join(test.wantHandled, ",") {
+				t.Fatalf("handled %v, want %v", got, test.wantHandled)
+			}
+		})
+	}
+}
+
+func TestPushHandlerVerifiesSignatures(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	key := newTestSigningKey(t)
+	receiver, handled := newTestPushReceiver(t, transmitter, ReceiverConfig{VerifySignatures: true}, nil)
+	issuer := jwt.MapClaims{"iss": transmitter.url()}
+	set := signTestSet(t, jwt.SigningMethodES256, key, "jti-1", issuer, "key-1")
+
+	// The transmitter's keys can't be fetched yet, so it is asked to retry
+	if response := pushSet(receiver.PushHandler(), set, nil); response.Code != http.StatusServiceUnavailable {
+		t.Fatalf("push answered %d while the keys are unavailable, want 503", response.Code)
+	}
+
+	transmitter.mu.Lock()
+	transmitter.jwks = testJwks(t, map[string]*ecdsa.PrivateKey{"key-1": key})
+	transmitter.mu.Unlock()
+	if err := receiver.jwks.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	forged := signTestSet(t, jwt.SigningMethodES256, newTestSigningKey(t), "forged", issuer, "key-1")
+	response := pushSet(receiver.PushHandler(), forged, nil)
+	var setErr SetError
+	json.Unmarshal(response.Body.Bytes(), &setErr)
+	if response.Code != http.StatusBadRequest || setErr.Err != "invalid_key" {
+		t.Fatalf("push of a forged SET answered %d %s, want 400 invalid_key", response.Code, response.Body)
+	}
+
+	if response := pushSet(receiver.PushHandler(), set, nil); response.Code != http.StatusAccepted {
+		t.Fatalf("push answered %d %s, want 202", response.Code, response.Body)
+	}
+	if got := handled(); len(got) != 1 || got[0] != "jti-1" {
+		t.Fatalf("handled %v, want [jti-1]", got)
+	}
+}
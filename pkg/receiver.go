@@ -1,75 +1,225 @@
 package pkg
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"time"
 
 	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
-
-	"github.com/golang-jwt/jwt/v5"
 )
 
 const TransmitterConfigMetadataPath = "/.well-known/ssf-configuration"
 const TransmitterPollRFC = "urn:ietf:rfc:8936"
 
+// DefaultLongPollTimeout is how long a long poll request is held open when
+// the receiver is not configured with a LongPollTimeout
+const DefaultLongPollTimeout = 60 * time.Second
+
+// minLongPollInterval defines how long a long poll must take before the
+// next one is sent right away
+const minLongPollInterval = time.Second
+
+// DefaultMaxPollsPerCycle is how many poll requests a poll cycle makes at
+// most while draining the transmitter's queue, when the receiver is not
+// configured with a MaxPollsPerCycle
+const DefaultMaxPollsPerCycle = 100
+
 // Initializes the SSF Receiver based on the specified configuration.
 //
+// When configured with a StateStore holding the state of a previous run
+// against the same transmitter, the receiver resumes that run's stream
+// instead of creating a new one, as long as the stream still exists. A
+// resumed stream is updated when the requested events or push endpoint
+// changed, and replaced when the delivery method changed.
+//
 // Returns an error if any process of configuring the receiver, registering
 // it with the transmitter, or setting up the poll interval failed
 func ConfigureSsfReceiver(cfg ReceiverConfig) (SsfReceiver, error) {
-	if cfg.TransmitterUrl == "" || cfg.TransmitterPollUrl == "" || len(cfg.EventsRequested) == 0 || cfg.AuthorizationToken == "" {
+	if cfg.TransmitterUrl == "" || len(cfg.EventsRequested) == 0 || cfg.AuthorizationToken == "" {
 		return nil, errors.New("Receiver Config - missing required field")
 	}
 
-	transmitterUrl, err := url.Parse(cfg.TransmitterUrl)
-	if err != nil {
-		return nil, err
+	if cfg.TransmitterPollUrl == "" && cfg.PushEndpointUrl == "" {
+		return nil, errors.New("Receiver Config - one of TransmitterPollUrl or PushEndpointUrl is required")
 	}
 
-	baseUrl := transmitterUrl.Host
-	trailingPath := transmitterUrl.Path
-
-	transmitterConfigEndpoint := "https://" + baseUrl + TransmitterConfigMetadataPath
-	if trailingPath != "/" {
-		transmitterConfigEndpoint += trailingPath
+	state, err := loadReceiverState(cfg.StateStore, cfg.TransmitterUrl)
+	if err != nil {
+		return nil, err
 	}
 
-	transmitterCfg, err := makeTransmitterConfigRequest(transmitterConfigEndpoint)
+	logger := newReceiverLogger(cfg.Logger, cfg.TransmitterUrl)
+	metadata := NewTransmitterMetadataCache(cfg.TransmitterUrl, cfg.MetadataRefreshInterval)
+	metadata.requestTimeout = cfg.RequestTimeout
+	transmitterCfg, err := metadata.Get(context.Background())
 	if err != nil {
-		return nil, err
+		if state == nil || state.TransmitterConfig == nil {
+			logger.Error("transmitter discovery failed", slog.Any("error", err))
+			return nil, err
+		}
+		// Resume with the saved metadata until the transmitter can be
+		// reached again by the background refresh
+		logger.Warn("transmitter discovery failed, using the saved configuration", slog.Any("error", err))
+		transmitterCfg = state.TransmitterConfig
+		metadata.seed(transmitterCfg)
+	} else {
+		logger.Info("discovered transmitter configuration",
+			slog.String("configuration_endpoint", transmitterCfg.ConfigurationEndpoint),
+			slog.Any("delivery_methods", transmitterCfg.DeliveryMethodsSupported))
 	}
 
 	if transmitterCfg.ConfigurationEndpoint == "" {
 		return nil, errors.New("Given transmitter doesn't specify the configuration endpoint")
 	}
 
-	streamId, err := makeCreateStreamRequest(transmitterCfg.ConfigurationEndpoint, cfg)
+	client := newTransmitterClient(cfg)
+	streamId := ""
+	if state != nil {
+		streamId, err = resumeStream(client, transmitterCfg.ConfigurationEndpoint, state.StreamId, cfg, logger)
+		if err != nil {
+			return nil, err
+		}
+		if streamId != "" {
+			logger.Info("resumed stream", slog.String("stream_id", streamId))
+		} else {
+			// The pending acknowledgements belong to the previous stream
+			state = nil
+		}
+	}
+
+	if streamId == "" {
+		streamId, err = makeCreateStreamRequest(client, transmitterCfg.ConfigurationEndpoint, cfg)
+		if err != nil {
+			logger.Error("stream creation failed", slog.Any("error", err))
+			return nil, err
+		}
+		logger.Info("created stream", slog.String("stream_id", streamId))
+	}
+
+	receiver := SsfReceiverImplementation{
+		transmitterUrl:          cfg.TransmitterUrl,
+		transmitterPollUrl:      cfg.TransmitterPollUrl,
+		eventsRequested:         events.EventTypeArrayToEventUriArray(cfg.EventsRequested),
+		authorizationToken:      cfg.AuthorizationToken,
+		client:                  client,
+		handler:                 cfg.Handler,
+		pushAuthorizationHeader: cfg.PushAuthorizationHeader,
+		dedup:                   cfg.DedupStore,
+		stateStore:              cfg.StateStore,
+		inbox:                   cfg.Inbox,
+		pollInterval:            300,
+		maxEvents:               10,
+		maxPollsPerCycle:        DefaultMaxPollsPerCycle,
+		deliveryBufferSize:      DefaultDeliveryBufferSize,
+		longPoll:                cfg.LongPoll,
+		longPollTimeout:         DefaultLongPollTimeout,
+		streamId:                streamId,
+		metadata:                metadata,
+		shutdownAction:          cfg.ShutdownAction,
+		pollWake:                make(chan struct{}, 1),
+		pushEndpointUrl:         cfg.PushEndpointUrl,
+		audience:                cfg.Audience,
+		logger:                  logger.With(slog.String("stream_id", streamId)),
+		metrics:                 nopMetrics{},
+		tracer:                  nopTracer{},
+		staleAfter:              cfg.HealthStaleAfter,
+		maxPollFailures:         cfg.HealthMaxPollFailures,
+	}
+	if cfg.Metrics != nil {
+		receiver.metrics = cfg.Metrics
+	}
+	if cfg.Tracer != nil {
+		receiver.tracer = cfg.Tracer
+	}
+	receiver.applyTransmitterConfig(transmitterCfg)
+
+	if cfg.VerifySignatures {
+		receiver.jwks = NewJwksCache(transmitterCfg.JwksUri, cfg.JwksRefreshInterval)
+		err = receiver.jwks.Refresh(context.Background())
+		if err != nil {
+			// SETs are rejected until the keys can be fetched
+			logger.Warn("failed to fetch the transmitter's signing keys", slog.Any("error", err))
+		}
+	}
+
+	if state != nil {
+		receiver.pendingAcks = state.PendingAcks
+		receiver.pendingSetErrors = state.PendingSetErrors
+		receiver.lastPollTime = state.LastPollTime
+	}
+	err = receiver.saveState()
 	if err != nil {
 		return nil, err
 	}
 
-	receiver := SsfReceiverImplementation{
-		transmitterUrl:       cfg.TransmitterUrl,
-		transmitterPollUrl:   cfg.TransmitterPollUrl,
-		eventsRequested:      events.EventTypeArrayToEventUriArray(cfg.EventsRequested),
-		authorizationToken:   cfg.AuthorizationToken,
-		transmitterStatusUrl: transmitterCfg.StatusEndpoint,
-		pollInterval:         300,
-		streamId:             streamId,
-		configurationUrl:     transmitterCfg.ConfigurationEndpoint,
+	metadata.OnChange(func(previous *TransmitterConfig, current *TransmitterConfig) {
+		receiver.logger.Info("transmitter configuration changed",
+			slog.String("configuration_endpoint", current.ConfigurationEndpoint))
+		receiver.applyTransmitterConfig(current)
+		if receiver.jwks != nil {
+			receiver.jwks.SetUri(current.JwksUri)
+		}
+		receiver.saveState()
+		if cfg.OnMetadataChange != nil {
+			cfg.OnMetadataChange(previous, current)
+		}
+	})
+	if cfg.MetadataRefreshInterval >= 0 {
+		metadata.Start()
 	}
+
 	if cfg.PollInterval != 0 {
 		receiver.pollInterval = cfg.PollInterval
 	}
+	if cfg.MaxEvents != 0 {
+		receiver.maxEvents = cfg.MaxEvents
+	}
+	if cfg.DeliveryBufferSize != 0 {
+		receiver.deliveryBufferSize = cfg.DeliveryBufferSize
+	}
+	if cfg.MaxPollsPerCycle != 0 {
+		receiver.maxPollsPerCycle = cfg.MaxPollsPerCycle
+	}
+	if cfg.LongPollTimeout != 0 {
+		receiver.longPollTimeout = cfg.LongPollTimeout
+	}
+
+	if receiver.handler != nil {
+		middleware := cfg.Middleware
+		if cfg.Tracer != nil {
+			middleware = append([]Middleware{receiver.tracingMiddleware()}, middleware...)
+		}
+		receiver.handler = Chain(receiver.handler, middleware...)
+
+		if cfg.DeadLetterStore != nil {
+			receiver.deadLetters = cfg.DeadLetterStore
+			receiver.maxAttempts = DefaultMaxAttempts
+			if cfg.MaxAttempts > 0 {
+				receiver.maxAttempts = cfg.MaxAttempts
+			}
+			receiver.replayHandler = receiver.handler
+			receiver.handler = receiver.deadLetterMiddleware()(receiver.handler)
+		}
+	}
 
-	if cfg.PollCallback != nil {
+	if cfg.PushEndpointUrl != "" {
+		receiver.pollCallback = cfg.PollCallback
+	} else if cfg.Handler != nil && cfg.Inbox != nil {
+		receiver.startWorker(receiver.consumeInbox)
+		receiver.InitPollInterval()
+	} else if cfg.Handler != nil {
+		receiver.dispatcher = NewDispatcher(cfg.HandlerWorkers, receiver.deliveryBufferSize, receiver.handler)
+		deliveries := receiver.Events()
+		receiver.startWorker(func(ctx context.Context) error {
+			receiver.dispatchDeliveries(deliveries)
+			return nil
+		})
+	} else if cfg.PollCallback != nil {
 		receiver.pollCallback = cfg.PollCallback
 		receiver.InitPollInterval()
 	}
@@ -77,66 +227,60 @@ func ConfigureSsfReceiver(cfg ReceiverConfig) (SsfReceiver, error) {
 	return &receiver, nil
 }
 
-// Makes the Transmitter Configuration Metadata request to determine
-// the transmitter's configuration url for creating a stream
-func makeTransmitterConfigRequest(url string) (*TransmitterConfig, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
+// Updates the receiver's transmitter endpoints from the given metadata
+func (receiver *SsfReceiverImplementation) applyTransmitterConfig(transmitterCfg *TransmitterConfig) {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	receiver.transmitterConfig = transmitterCfg
+	receiver.transmitterStatusUrl = transmitterCfg.StatusEndpoint
+	receiver.configurationUrl = transmitterCfg.ConfigurationEndpoint
+}
 
-	response, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer response.Body.Close()
+// Returns the transmitter's current status url
+func (receiver *SsfReceiverImplementation) statusUrl() string {
+	receiver.mu.RLock()
+	defer receiver.mu.RUnlock()
+	return receiver.transmitterStatusUrl
+}
 
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return nil, err
-	}
+// Returns the transmitter's current configuration url
+func (receiver *SsfReceiverImplementation) configUrl() string {
+	receiver.mu.RLock()
+	defer receiver.mu.RUnlock()
+	return receiver.configurationUrl
+}
 
-	var configMetadata TransmitterConfig
-	err = json.Unmarshal(body, &configMetadata)
-	if err != nil {
-		return nil, err
-	}
+// Returns the most recently discovered transmitter configuration metadata
+func (receiver *SsfReceiverImplementation) GetTransmitterConfig() *TransmitterConfig {
+	receiver.mu.RLock()
+	defer receiver.mu.RUnlock()
+	return receiver.transmitterConfig
+}
 
-	return &configMetadata, nil
+// Returns the delivery method the receiver's stream uses
+func streamDelivery(cfg ReceiverConfig) SsfDelivery {
+	if cfg.PushEndpointUrl == "" {
+		return SsfDelivery{Method: TransmitterPollRFC}
+	}
+	return SsfDelivery{
+		Method:              TransmitterPushRFC,
+		EndpointUrl:         cfg.PushEndpointUrl,
+		AuthorizationHeader: cfg.PushAuthorizationHeader,
+	}
 }
 
 // Makes the Create Stream Request to the transmitter
-func makeCreateStreamRequest(url string, cfg ReceiverConfig) (string, error) {
-	client := &http.Client{}
-
-	delivery := SsfDelivery{Method: TransmitterPollRFC}
+func makeCreateStreamRequest(client *transmitterClient, url string, cfg ReceiverConfig) (string, error) {
 	createStreamRequest := CreateStreamReq{
-		Delivery:        delivery,
+		Delivery:        streamDelivery(cfg),
 		EventsRequested: events.EventTypeArrayToEventUriArray(cfg.EventsRequested),
 	}
 
-	requestBody, err := json.Marshal(createStreamRequest)
-	if err != nil {
-		return "", err
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	body, err := client.send(context.Background(), "POST", url, createStreamRequest, http.StatusOK, http.StatusCreated)
 	if err != nil {
 		return "", err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+cfg.AuthorizationToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	response, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-
-	defer response.Body.Close()
-
-	body, err := ioutil.ReadAll(response.Body)
 	type Stream struct {
 		StreamId string `json:"stream_id"`
 	}
@@ -147,162 +291,419 @@ func makeCreateStreamRequest(url string, cfg ReceiverConfig) (string, error) {
 		return "", err
 	}
 
+	if stream.StreamId == "" {
+		return "", errors.New("transmitter did not return a stream id")
+	}
+
 	return stream.StreamId, nil
 }
 
 // Initializes the poll interval for the receiver that will intermittently
-// send SSF Events to the specified callback function
+// send SSF Events to the specified callback function.
+//
+// Each poll cycle keeps polling while the transmitter reports that more
+// events are available, up to the receiver's max polls per cycle. When the
+// receiver is configured for long polling the next cycle starts right away
+// instead of waiting for the poll interval. When polling fails the next
+// cycle is delayed according to the receiver's retry policy, honoring any
+// Retry-After sent by the transmitter.
+//
+// Push receivers don't poll, so this does nothing when the receiver is
+// configured with a PushEndpointUrl
 func (receiver *SsfReceiverImplementation) InitPollInterval() {
-	// Create a channel to listen for quit signals
-	receiver.terminate = make(chan bool)
-
-	// Start a Goroutine to run the request on a schedule
-	go func() {
-		for {
-			select {
-			case <-receiver.terminate:
-				return
-			default:
-				println("Polling for Events")
-				events, err := receiver.PollEvents()
-				if err == nil {
-					receiver.pollCallback(events)
-				} else {
-					// TODO: What to do on error?
-					panic(err)
-				}
-				time.Sleep(time.Duration(receiver.pollInterval) * time.Second)
+	receiver.lifecycleMu.Lock()
+	defer receiver.lifecycleMu.Unlock()
+	if receiver.pollDone != nil || receiver.stopped || receiver.pushEndpointUrl != "" {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	receiver.pollCancel = cancel
+	receiver.pollDone = make(chan struct{})
+	receiver.pollStartedAt = time.Now()
+	go receiver.pollLoop(ctx, receiver.pollDone)
+}
+
+// Runs poll cycles until the context is cancelled
+func (receiver *SsfReceiverImplementation) pollLoop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	failures, emptyPolls := 0, 0
+	for {
+		var backoff time.Duration
+		cycleCtx, span := receiver.startSpan(ctx, SpanPollCycle)
+		start := time.Now()
+		received, err := receiver.pollCycle(cycleCtx)
+		endSpan(span, err)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			failures = 0
+			backoff, emptyPolls = receiver.longPollBackoff(received, time.Since(start), emptyPolls)
+		} else {
+			failures++
+			backoff = receiver.client.retryPolicy.Backoff(failures, err)
+			receiver.logger.Warn("poll cycle failed",
+				slog.Any("error", err),
+				slog.Int("failures", failures),
+				slog.Duration("backoff", backoff))
+		}
+
+		if !receiver.waitForNextCycle(ctx, backoff) {
+			return
+		}
+	}
+}
+
+// Returns how long to wait before the next long poll. A transmitter that
+// ignores returnImmediately=false answers right away without events; it is
+// then polled with a backoff doubling from minLongPollInterval up to the
+// poll interval, rather than back to back. Returns the updated count of
+// such responses in a row
+func (receiver *SsfReceiverImplementation) longPollBackoff(received int, elapsed time.Duration, emptyPolls int) (time.Duration, int) {
+	if !receiver.longPoll || received > 0 || elapsed >= minLongPollInterval {
+		return 0, 0
+	}
+
+	emptyPolls++
+	receiver.mu.RLock()
+	pollInterval := time.Duration(receiver.pollInterval) * time.Second
+	receiver.mu.RUnlock()
+	policy := RetryPolicy{InitialBackoff: minLongPollInterval, MaxBackoff: max(pollInterval, minLongPollInterval)}
+	return policy.Backoff(emptyPolls, nil), emptyPolls
+}
+
+// Waits until the next poll cycle is due, returning false if the context
+// is done first. Unless the last cycle failed, the wait is recomputed when
+// the poll interval is changed while waiting
+func (receiver *SsfReceiverImplementation) waitForNextCycle(ctx context.Context, backoff time.Duration) bool {
+	start := time.Now()
+	for {
+		wait := backoff
+		if backoff == 0 && !receiver.longPoll {
+			receiver.mu.RLock()
+			wait = time.Duration(receiver.pollInterval) * time.Second
+			receiver.mu.RUnlock()
+		}
+
+		timer := time.NewTimer(max(wait-time.Since(start), 0))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+			return true
+		case <-receiver.pollWake:
+			timer.Stop()
+		}
+	}
+}
+
+// Stops the poll loop, returning a channel that is closed once it has
+// returned, or nil if it wasn't running
+func (receiver *SsfReceiverImplementation) stopPolling() <-chan struct{} {
+	receiver.lifecycleMu.Lock()
+	defer receiver.lifecycleMu.Unlock()
+
+	cancel, done := receiver.pollCancel, receiver.pollDone
+	receiver.pollCancel, receiver.pollDone = nil, nil
+	if cancel != nil {
+		cancel()
+	}
+	return done
+}
+
+// Returns the callback the polled events are passed to
+func (receiver *SsfReceiverImplementation) callback() func(events []events.SsfEvent) {
+	receiver.mu.RLock()
+	defer receiver.mu.RUnlock()
+	return receiver.pollCallback
+}
+
+// Polls the transmitter until it reports that no more events are available
+// or the receiver's max polls per cycle is reached. The SETs of every
+// response are appended to the inbox when the receiver has one, otherwise
+// their events are sent on the events channel once Events has been called,
+// or passed to the poll callback.
+//
+// Returns how many SETs were received
+func (receiver *SsfReceiverImplementation) pollCycle(ctx context.Context) (int, error) {
+	received := 0
+	for i := 0; i < receiver.maxPollsPerCycle; i++ {
+		var moreAvailable bool
+		if receiver.inbox != nil {
+			batch, err := receiver.fetch(ctx)
+			if err != nil {
+				return received, err
 			}
+
+			received += len(batch.sets)
+			err = receiver.appendToInbox(ctx, batch)
+			if err != nil {
+				return received, err
+			}
+			moreAvailable = batch.moreAvailable
+		} else if deliveries := receiver.deliveryChannel(); deliveries != nil {
+			batch, err := receiver.fetch(ctx)
+			if err != nil {
+				return received, err
+			}
+
+			received += len(batch.sets)
+			err = receiver.deliver(ctx, deliveries, batch)
+			if err != nil {
+				return received, err
+			}
+			moreAvailable = batch.moreAvailable
+		} else {
+			response, err := receiver.poll(ctx)
+			if err != nil {
+				return received, err
+			}
+
+			received += len(response.Events)
+			if callback := receiver.callback(); callback != nil {
+				callback(response.Events)
+			}
+			moreAvailable = response.MoreAvailable
+		}
+
+		if !moreAvailable {
+			return received, nil
 		}
-	}()
+	}
+	return received, nil
 }
 
-// TODO: Not Yet Implemented
+// Replaces the receiver's poll callback and poll interval, in seconds, while
+// it is running. A pollInterval of 0 keeps the current interval.
+//
+// Polling is started if it isn't running yet, so a receiver configured
+// without a PollCallback can start polling once the application is ready.
+// Setting a nil callback stops polling, unless the events are consumed by
+// a Handler or from the Events channel. Push receivers only replace the
+// callback the pushed events are passed to
 func (receiver *SsfReceiverImplementation) ConfigureCallback(callback func(events []events.SsfEvent), pollInterval int) error {
+	if pollInterval < 0 {
+		return errors.New("poll interval cannot be negative")
+	}
+
+	receiver.lifecycleMu.Lock()
+	stopped := receiver.stopped
+	receiver.lifecycleMu.Unlock()
+	if stopped {
+		return errors.New("receiver has been shut down")
+	}
+
+	receiver.mu.Lock()
+	receiver.pollCallback = callback
+	if pollInterval > 0 {
+		receiver.pollInterval = pollInterval
+	}
+	receiver.mu.Unlock()
+
+	if receiver.pushEndpointUrl != "" {
+		return nil
+	}
+
+	if callback == nil && receiver.handler == nil && receiver.deliveryChannel() == nil {
+		// Not waiting for the loop to return, since the callback may be
+		// the one reconfiguring the receiver
+		receiver.stopPolling()
+		return nil
+	}
+
+	receiver.InitPollInterval()
+	select {
+	case receiver.pollWake <- struct{}{}:
+	default:
+	}
 	return nil
 }
 
 // Polls the transmitter for all available SSF Events, returning them as a list
 // for use
 func (receiver *SsfReceiverImplementation) PollEvents() ([]events.SsfEvent, error) {
-	client := &http.Client{}
-	pollRequest := PollTransmitterRequest{Acknowledgements: []string{}, MaxEvents: 10, ReturnImmediately: true}
-	requestBody, err := json.Marshal(pollRequest)
-	if err != nil {
-		return []events.SsfEvent{}, err
-	}
-
-	req, err := http.NewRequest("POST", receiver.transmitterPollUrl, bytes.NewBuffer(requestBody))
+	response, err := receiver.poll(context.Background())
 	if err != nil {
 		return []events.SsfEvent{}, err
 	}
+	return response.Events, nil
+}
 
-	req.Header.Set("Authorization", "Bearer "+receiver.authorizationToken)
-	req.Header.Set("Content-Type", "application/json")
+// Makes a single poll request to the transmitter, returning the events
+// along with whether the transmitter has more events available
+func (receiver *SsfReceiverImplementation) Poll() (*PollResponse, error) {
+	return receiver.poll(context.Background())
+}
 
-	response, err := client.Do(req)
+// Makes a single poll request to the transmitter and acknowledges the
+// returned events. SETs that were already processed are acknowledged but
+// their events are not returned again. SETs that cannot be parsed are
+// reported to the transmitter as invalid with the next poll request, and
+// the events of the other SETs are still returned, in the order the SETs
+// were issued. SETs that can't be verified because the transmitter's
+// signing keys are unavailable are left unacknowledged
+func (receiver *SsfReceiverImplementation) poll(ctx context.Context) (*PollResponse, error) {
+	batch, err := receiver.fetch(ctx)
 	if err != nil {
-		return []events.SsfEvent{}, err
+		return nil, err
 	}
 
-	defer response.Body.Close()
+	acks := map[string]string{}
+	newSets := []string{}
+	var ssfEvents []events.SsfEvent
+	for _, jti := range orderedSets(batch.sets) {
+		set := batch.sets[jti]
+		if receiver.isDuplicate(jti) {
+			acks[jti] = set
+			continue
+		}
 
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		return []events.SsfEvent{}, err
+		_, setEvents, err := receiver.parseSet(ctx, jti, set, "poll")
+		if errors.Is(err, ErrSigningKeysUnavailable) {
+			// Left unacknowledged, so it is redelivered
+			continue
+		}
+		if err != nil {
+			receiver.queueSetError(jti, setErrorFor(err))
+			continue
+		}
+		acks[jti] = set
+		newSets = append(newSets, jti)
+		ssfEvents = append(ssfEvents, setEvents...)
 	}
 
-	if response.StatusCode != 200 && response.StatusCode != 202 {
-		return []events.SsfEvent{}, err
+	if len(acks) > 0 {
+		err = acknowledgeEvents(ctx, &acks, receiver)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	type SsfEventSets struct {
-		Sets map[string]string `json:"sets"`
+	for _, jti := range newSets {
+		receiver.markProcessed(jti)
 	}
+	return &PollResponse{Events: ssfEvents, MoreAvailable: batch.moreAvailable}, nil
+}
 
-	var ssfEventsSets SsfEventSets
-	err = json.Unmarshal(body, &ssfEventsSets)
+// The SETs returned by a single poll request, keyed by JTI
+type pollBatch struct {
+	sets          map[string]string
+	moreAvailable bool
+}
+
+// Makes a single poll request to the transmitter, sending along any pending
+// acknowledgements and SET errors, and returns the raw SETs without
+// acknowledging them.
+//
+// When long polling, the request is held open for at most the receiver's
+// long poll timeout, after which an empty batch is returned
+func (receiver *SsfReceiverImplementation) fetch(ctx context.Context) (*pollBatch, error) {
+	pollCtx := ctx
+	if receiver.longPoll {
+		var cancel context.CancelFunc
+		pollCtx, cancel = context.WithTimeout(ctx, receiver.longPollTimeout)
+		defer cancel()
+	}
+
+	acks, setErrors := receiver.takePendingAcks()
+	pollRequest := PollTransmitterRequest{
+		Acknowledgements:  acks,
+		SetErrors:         setErrors,
+		MaxEvents:         receiver.maxEvents,
+		ReturnImmediately: !receiver.longPoll,
+	}
+	pollCtx, span := receiver.startSpan(pollCtx, SpanPoll)
+	span.SetAttribute(AttributeAckCount, len(acks))
+	start := time.Now()
+	body, err := receiver.client.send(pollCtx, "POST", receiver.transmitterPollUrl, pollRequest, http.StatusOK, http.StatusAccepted)
+	latency := time.Since(start)
+	defer func() { endSpan(span, err) }()
+	receiver.metrics.ObservePollLatency(latency)
 	if err != nil {
-		return []events.SsfEvent{}, nil
+		// The transmitter may not have seen the acknowledgements, so they
+		// are sent again with the next poll request
+		receiver.restorePendingAcks(acks, setErrors)
+		receiver.saveStateIfChanged()
+		if receiver.longPoll && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			receiver.logger.Debug("long poll timed out", slog.Duration("latency", latency))
+			receiver.recordPollResult(nil)
+			return &pollBatch{sets: map[string]string{}}, nil
+		}
+		if ctx.Err() == nil {
+			receiver.recordPollResult(err)
+			receiver.metrics.PollFailed(TransmitterStatusCode(err))
+			receiver.logger.Warn("poll request failed",
+				slog.Any("error", err),
+				slog.Int("status", TransmitterStatusCode(err)),
+				slog.Duration("latency", latency))
+		}
+		return nil, err
 	}
 
-	if len(ssfEventsSets.Sets) > 0 {
-		err = acknowledgeEvents(&ssfEventsSets.Sets, receiver)
-		if err != nil {
-			return []events.SsfEvent{}, nil
-		}
+	if len(acks) > 0 {
+		receiver.metrics.AcksSent(len(acks))
 	}
-	events, err := parseSsfEventSets(&ssfEventsSets.Sets)
-	return events, err
-}
 
-// Cleans up the resources used by the Receiver and deletes the Receiver's
-// stream from the transmitter
-func (receiver *SsfReceiverImplementation) DeleteReceiver() {
-	receiver.terminate <- true
+	receiver.recordPollResult(nil)
+	// Failing to save the state only means the acknowledgements that were
+	// just sent could be sent again after a restart
+	receiver.saveStateIfChanged()
 
-	client := &http.Client{}
-	req, err := http.NewRequest("DELETE", receiver.configurationUrl+"?stream_id="+receiver.streamId, nil)
+	var ssfEventsSets PollTransmitterResponse
+	err = json.Unmarshal(body, &ssfEventsSets)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	req.Header.Set("Authorization", receiver.authorizationToken)
-
-	_, err = client.Do(req)
-	if err != nil {
-		panic(err)
+	if ssfEventsSets.Sets == nil {
+		ssfEventsSets.Sets = map[string]string{}
 	}
+
+	span.SetAttribute(AttributeEventCount, len(ssfEventsSets.Sets))
+	span.SetAttribute(AttributeMoreAvailable, ssfEventsSets.MoreAvailable)
+	receiver.logger.Debug("polled transmitter",
+		slog.Int("count", len(ssfEventsSets.Sets)),
+		slog.Bool("more_available", ssfEventsSets.MoreAvailable),
+		slog.Int("acks", len(acks)),
+		slog.Int("set_errors", len(setErrors)),
+		slog.Duration("latency", latency))
+	return &pollBatch{sets: ssfEventsSets.Sets, moreAvailable: ssfEventsSets.MoreAvailable}, nil
 }
 
 func (receiver *SsfReceiverImplementation) EnableStream() (StreamStatus, error) {
-	if receiver.transmitterStatusUrl == "" {
+	if receiver.statusUrl() == "" {
 		return 0, errors.New("configured receiver does not have transmitter stream url")
 	}
 	return receiver.sendStatusUpdateRequest(StreamEnabled)
 }
 
 func (receiver *SsfReceiverImplementation) PauseStream() (StreamStatus, error) {
-	if receiver.transmitterStatusUrl == "" {
+	if receiver.statusUrl() == "" {
 		return 0, errors.New("configured receiver does not have transmitter stream url")
 	}
 	return receiver.sendStatusUpdateRequest(StreamPaused)
 }
 
 func (receiver *SsfReceiverImplementation) DisableStream() (StreamStatus, error) {
-	if receiver.transmitterStatusUrl == "" {
+	if receiver.statusUrl() == "" {
 		return 0, errors.New("configured receiver does not have transmitter stream url")
 	}
 	return receiver.sendStatusUpdateRequest(StreamDisabled)
 }
 
 func (receiver *SsfReceiverImplementation) sendStatusUpdateRequest(streamStatus StreamStatus) (StreamStatus, error) {
-	client := &http.Client{}
 	updateStreamRequest := UpdateStreamRequest{StreamId: receiver.streamId, Status: EnumToStringStatusMap[streamStatus]}
-	requestBody, err := json.Marshal(updateStreamRequest)
-	if err != nil {
-		return 0, err
-	}
-
-	req, err := http.NewRequest("POST", receiver.transmitterStatusUrl, bytes.NewBuffer(requestBody))
+	body, err := receiver.client.send(context.Background(), "POST", receiver.statusUrl(), updateStreamRequest, http.StatusOK, http.StatusAccepted)
 	if err != nil {
+		receiver.logger.Warn("stream status update failed",
+			slog.String("status", updateStreamRequest.Status),
+			slog.Any("error", err))
 		return 0, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+receiver.authorizationToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	response, err := client.Do(req)
-	if err != nil {
-		return 0, err
-	}
-
-	defer response.Body.Close()
-
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		return 0, err
-	}
 	type StatusResponse struct {
 		Status string `json:"status"`
 		Reason string `json:"reason,omitempty"`
@@ -313,35 +714,36 @@ func (receiver *SsfReceiverImplementation) sendStatusUpdateRequest(streamStatus
 	if err != nil {
 		return 0, err
 	}
+
+	receiver.logger.Info("stream status updated",
+		slog.String("status", statusResponse.Status),
+		slog.String("requested_status", updateStreamRequest.Status))
 	return StatusEnumMap[statusResponse.Status], nil
 }
 
 func (receiver *SsfReceiverImplementation) GetStreamStatus() (StreamStatus, error) {
-	if receiver.transmitterStatusUrl == "" {
+	return receiver.getStreamStatus(context.Background(), true)
+}
+
+// Fetches the stream status from the transmitter. Failed requests are
+// retried according to the retry policy when retry is set
+func (receiver *SsfReceiverImplementation) getStreamStatus(ctx context.Context, retry bool) (StreamStatus, error) {
+	if receiver.statusUrl() == "" {
 		return 0, errors.New("transmitter does not support stream status")
 	}
 
-	client := &http.Client{}
-	streamUrl := fmt.Sprintf("%s?stream_id=%s", receiver.transmitterStatusUrl, receiver.streamId)
-	req, err := http.NewRequest("GET", streamUrl, nil)
-	if err != nil {
-		return 0, err
+	streamUrl := fmt.Sprintf("%s?stream_id=%s", receiver.statusUrl(), url.QueryEscape(receiver.streamId))
+	var body []byte
+	var err error
+	if retry {
+		body, err = receiver.client.send(ctx, "GET", streamUrl, nil, http.StatusOK)
+	} else {
+		body, err = receiver.client.sendOnce(ctx, "GET", streamUrl, nil, []int{http.StatusOK})
 	}
-
-	req.Header.Set("Authorization", "Bearer "+receiver.authorizationToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	response, err := client.Do(req)
 	if err != nil {
 		return 0, err
 	}
 
-	defer response.Body.Close()
-
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		return 0, err
-	}
 	type StatusResponse struct {
 		Status string `json:"status"`
 	}
@@ -349,7 +751,7 @@ func (receiver *SsfReceiverImplementation) GetStreamStatus() (StreamStatus, erro
 	var statusResponse StatusResponse
 	err = json.Unmarshal(body, &statusResponse)
 	if err != nil {
-		return 0, nil
+		return 0, err
 	}
 
 	return StatusEnumMap[statusResponse.Status], nil
@@ -357,7 +759,7 @@ func (receiver *SsfReceiverImplementation) GetStreamStatus() (StreamStatus, erro
 
 // Method to acknowledge a list of JTI's (unique ids for each SSF Event) with the
 // transmitter so the events are re-transmitted
-func acknowledgeEvents(sets *map[string]string, receiver *SsfReceiverImplementation) error {
+func acknowledgeEvents(ctx context.Context, sets *map[string]string, receiver *SsfReceiverImplementation) error {
 	ackList := make([]string, len(*sets))
 	i := 0
 	for jti := range *sets {
@@ -365,54 +767,50 @@ func acknowledgeEvents(sets *map[string]string, receiver *SsfReceiverImplementat
 		i++
 	}
 
-	client := &http.Client{}
+	ctx, span := receiver.startSpan(ctx, SpanAck)
+	span.SetAttribute(AttributeAckCount, len(ackList))
 	pollRequest := PollTransmitterRequest{Acknowledgements: ackList, MaxEvents: 0, ReturnImmediately: true}
-	requestBody, err := json.Marshal(pollRequest)
+	_, err := receiver.client.send(ctx, "POST", receiver.transmitterPollUrl, pollRequest, http.StatusOK, http.StatusAccepted)
+	endSpan(span, err)
 	if err != nil {
+		receiver.logger.Warn("acknowledgement failed", slog.Int("count", len(ackList)), slog.Any("error", err))
 		return err
 	}
 
-	req, err := http.NewRequest("POST", receiver.transmitterPollUrl, bytes.NewBuffer(requestBody))
+	receiver.metrics.AcksSent(len(ackList))
+	receiver.logger.Debug("acknowledged events", slog.Int("count", len(ackList)))
+	return nil
+}
+
+// Parses a single SET, returning its claims and the SSF Events it contains
+func parseSsfEventSet(set string) (map[string]interface{}, []events.SsfEvent, error) {
+	claims, err := decodeSet(set)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+receiver.authorizationToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	_, err = client.Do(req)
+	ssfEvents, err := eventsFromClaims(claims)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-
-	return nil
+	return claims, ssfEvents, nil
 }
 
-// Parses a list of JTI:JWT pairings, return a list of the SSF Events from the JWT's
-func parseSsfEventSets(sets *map[string]string) ([]events.SsfEvent, error) {
-	var ssfEventsList []events.SsfEvent
+// Returns the SSF Events contained in the claims of a SET
+func eventsFromClaims(claims map[string]interface{}) ([]events.SsfEvent, error) {
+	ssfEvents, ok := claims["events"].(map[string]interface{})
+	if !ok {
+		return nil, &setParseError{reason: SetRejectedMissingEvents, err: errors.New("SET is missing the events claim")}
+	}
 
-	for _, set := range *sets {
-		token, err := jwt.Parse(set, func(token *jwt.Token) (interface{}, error) { return jwt.UnsafeAllowNoneSignatureType, nil })
+	var ssfEventsList []events.SsfEvent
+	for eventType, eventSubject := range ssfEvents {
+		ssfEvent, err := events.EventStructFromEvent(eventType, eventSubject, claims)
 		if err != nil {
-			return []events.SsfEvent{}, err
+			return nil, &setParseError{reason: SetRejectedInvalidEvent, err: err}
 		}
 
-		token.Claims.GetSubject()
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			return []events.SsfEvent{}, errors.New("Can't get JWT Claims")
-		}
-
-		ssfEvents := claims["events"].(map[string]interface{})
-		for eventType, eventSubject := range ssfEvents {
-			ssfEvent, err := events.EventStructFromEvent(eventType, eventSubject, claims)
-			if err != nil {
-				return []events.SsfEvent{}, err
-			}
-
-			ssfEventsList = append(ssfEventsList, ssfEvent)
-		}
+		ssfEventsList = append(ssfEventsList, ssfEvent)
 	}
 
 	return ssfEventsList, nil
@@ -1,6 +1,11 @@
 package pkg
 
-import events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+import (
+	"log/slog"
+	"time"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
 
 type ReceiverConfig struct {
 	// TransmitterUrl defines the URL for the transmitter that
@@ -15,9 +20,36 @@ type ReceiverConfig struct {
 	//
 	// Note - Must be a subpath of TransmitterUrl
 	//
-	// Required
+	// Required, unless PushEndpointUrl is set
 	TransmitterPollUrl string
 
+	// PushEndpointUrl defines the URL the transmitter will push SSF
+	// events to. When set, the stream is created with push delivery
+	// (RFC 8935) instead of poll delivery, and the receiver's
+	// PushHandler must be served at this URL.
+	//
+	// Optional
+	PushEndpointUrl string
+
+	// PushPath defines the request path the ReceiverManager's PushHandler
+	// routes to the receiver, for when it differs from the path of
+	// PushEndpointUrl, for instance behind a proxy rewriting paths
+	//
+	// Note - This field will not be used if PushEndpointUrl isn't set, or
+	// if the receiver isn't run by a ReceiverManager
+	//
+	// Optional, defaults to the path of PushEndpointUrl
+	PushPath string
+
+	// PushAuthorizationHeader defines the Authorization header the
+	// transmitter must send with every pushed SET. Pushed SETs without
+	// it are rejected
+	//
+	// Note - This field will not be used if PushEndpointUrl isn't set
+	//
+	// Optional
+	PushAuthorizationHeader string
+
 	// TransmitterStreamUrl defines the URL that the receiver will use
 	// to update/get the stream status.
 	//
@@ -52,11 +84,240 @@ type ReceiverConfig struct {
 	// Optional
 	PollCallback func(events []events.SsfEvent)
 
+	// DeliveryBufferSize defines how many events the channel returned by
+	// Events can hold before the poll loop stops polling and waits for
+	// consumers to catch up
+	//
+	// Optional, defaults to 100
+	DeliveryBufferSize int
+
+	// Handler is called for every received SSF Event on a pool of
+	// HandlerWorkers workers. Events for the same subject are handled in
+	// order, one at a time, while events for different subjects are
+	// handled in parallel. An event is acknowledged with the transmitter
+	// once the handler returns nil; when the handler returns an error or
+	// panics the event is left unacknowledged so it is redelivered.
+	//
+	// Note - Polling starts automatically when Handler is configured, and
+	// PollCallback is not used. For push delivery, the events of each
+	// pushed SET are passed to Handler before the SET is accepted
+	//
+	// Optional
+	Handler Handler
+
+	// Middleware wraps Handler with cross-cutting behavior, such as the
+	// Recovery, Logging and Timing middlewares. The middlewares run for
+	// every parsed event, for both poll and push delivery, and the first
+	// middleware is the outermost one
+	//
+	// Note - This field will not be used if the Handler isn't configured
+	//
+	// Optional
+	Middleware []Middleware
+
+	// HandlerWorkers defines the number of workers Handler is called on
+	//
+	// Note - This field will not be used if the Handler isn't configured
+	//
+	// Optional, defaults to 4
+	HandlerWorkers int
+
+	// DedupStore remembers the JTIs of the SETs the receiver processed.
+	// When set, SETs redelivered by the transmitter within the store's
+	// window are acknowledged without their events being handled again.
+	// See NewMemoryDedupStore and NewFileDedupStore
+	//
+	// Optional
+	DedupStore DedupStore
+
+	// StateStore persists the receiver's stream id, the discovered
+	// transmitter metadata, the pending acknowledgements and SET errors,
+	// and the last poll time. When it holds the state of a previous run,
+	// the receiver resumes that run's stream instead of creating a new one.
+	// The resumed stream is updated when EventsRequested or
+	// PushEndpointUrl changed, and replaced when the delivery method
+	// changed. See NewFileStateStore
+	//
+	// Optional
+	StateStore StateStore
+
+	// DeadLetterStore stores the events Handler failed on MaxAttempts
+	// times. Those events are then acknowledged, so a poison event doesn't
+	// hold up the events after it or get redelivered forever. Dead letters
+	// keep the raw SET, the event, the errors and their timestamps, and
+	// can be replayed with ReplayDeadLetter. See NewFileDeadLetterStore
+	//
+	// Note - Attempts are counted in memory, so they start over when the
+	// receiver restarts. This field will not be used if the Handler isn't
+	// configured
+	//
+	// Optional, defaults to leaving failed events unacknowledged
+	DeadLetterStore DeadLetterStore
+
+	// MaxAttempts defines how many times Handler is called for an event
+	// before the event is dead-lettered
+	//
+	// Note - The attempts are counted in memory, since the receiver last
+	// started, see DeadLetterStore. This field will not be used if the
+	// DeadLetterStore isn't configured
+	//
+	// Optional, defaults to DefaultMaxAttempts
+	MaxAttempts int
+
+	// Inbox is a durable on-disk log the polled SETs are appended to and
+	// synced before they are acknowledged with the transmitter. Handler
+	// then consumes the inbox in order, committing its offset after each
+	// SET is handled, so SETs survive restarts without the transmitter
+	// holding them. See OpenInbox
+	//
+	// Note - With an Inbox, Handler is called one SET at a time and
+	// HandlerWorkers is not used
+	//
+	// Optional
+	Inbox *Inbox
+
 	// PollInterval defines, in seconds how often you want the receiver to
 	// poll for SSF events any and pass them to your PollCallback function.
 	//
 	// Note - This field will not be used if the PollCallback isn't configured
 	//
+	// Note - The poll interval is skipped when LongPoll is set
+	//
 	// Optional, defaults to 300 (5 minutes)
 	PollInterval int
+
+	// MaxEvents defines the maximum number of events the transmitter
+	// should return for each poll request
+	//
+	// Optional, defaults to 10
+	MaxEvents int
+
+	// MaxPollsPerCycle defines how many poll requests the automatic poll
+	// loop makes at most in each cycle. Within a cycle the receiver keeps
+	// polling while the transmitter reports that more events are
+	// available, so a backlog drains at up to MaxEvents * MaxPollsPerCycle
+	// events per cycle
+	//
+	// Optional, defaults to 100
+	MaxPollsPerCycle int
+
+	// LongPoll configures poll requests to be sent with returnImmediately
+	// set to false, so the transmitter holds each request open until
+	// events are available. The automatic poll loop then re-polls as soon
+	// as each request returns, delivering events within seconds of them
+	// being transmitted
+	//
+	// Optional, defaults to false (returnImmediately is true)
+	LongPoll bool
+
+	// LongPollTimeout defines how long the receiver waits on a long poll
+	// request before giving up and polling again. Should be longer than
+	// the time the transmitter holds requests open
+	//
+	// Note - This field will not be used if LongPoll isn't set
+	//
+	// Optional, defaults to 60 seconds
+	LongPollTimeout time.Duration
+
+	// MetadataRefreshInterval defines how long the transmitter's
+	// configuration metadata is cached when the transmitter doesn't send
+	// Cache-Control or Expires headers. The metadata is refreshed in the
+	// background and the receiver's endpoints are updated when it changes.
+	//
+	// Note - A negative value disables the background refresh
+	//
+	// Optional, defaults to 1 hour
+	MetadataRefreshInterval time.Duration
+
+	// OnMetadataChange is called with the previous and current metadata
+	// every time the background refresh finds that the transmitter's
+	// configuration metadata changed, after the receiver's endpoints have
+	// been updated
+	//
+	// Optional
+	OnMetadataChange func(previous *TransmitterConfig, current *TransmitterConfig)
+
+	// RetryPolicy defines how idempotent transmitter calls are retried and
+	// how the poll loop backs off when polling fails or the transmitter
+	// throttles the receiver with a 429 or 503
+	//
+	// Optional, defaults to DefaultRetryPolicy
+	RetryPolicy *RetryPolicy
+
+	// RequestTimeout defines how long each request to the transmitter may
+	// take, including discovery. Long poll requests are bounded by
+	// LongPollTimeout instead
+	//
+	// Optional, defaults to DefaultRequestTimeout
+	RequestTimeout time.Duration
+
+	// RateLimit defines the maximum number of requests per second the
+	// receiver will make to the transmitter
+	//
+	// Optional, defaults to 0 (unlimited)
+	RateLimit float64
+
+	// ShutdownAction defines what Shutdown does with the receiver's stream
+	// after the receiver has stopped
+	//
+	// Optional, defaults to ShutdownKeepStream
+	ShutdownAction ShutdownAction
+
+	// Logger defines the logger the receiver logs discovery, stream
+	// changes, polls, acknowledgements and parse failures to. Credentials
+	// and subject identifiers are redacted from the logs
+	//
+	// Optional, defaults to discarding the logs
+	Logger *slog.Logger
+
+	// Metrics defines where the receiver reports the events it receives,
+	// the SETs it rejects, its acknowledgements and its poll requests
+	//
+	// Optional, defaults to discarding the measurements
+	Metrics Metrics
+
+	// Tracer defines the tracer the receiver records its poll cycles,
+	// requests, SET parsing and handler invocations with. The ssfotel
+	// package provides a Tracer backed by OpenTelemetry
+	//
+	// Optional, defaults to recording nothing
+	Tracer Tracer
+
+	// VerifySignatures defines whether the SETs must be signed with one of
+	// the keys published at the transmitter's jwks_uri, and issued by the
+	// transmitter. Unsigned and invalid SETs are rejected. While the keys
+	// can't be fetched, SETs are left unacknowledged so the transmitter
+	// redelivers them
+	//
+	// Optional, defaults to false
+	VerifySignatures bool
+
+	// Audience defines the audience the transmitter issues the receiver's
+	// SETs to. SETs whose aud claim doesn't contain it are rejected, SETs
+	// without an aud claim are accepted
+	//
+	// Note - This field will not be used if VerifySignatures isn't set
+	//
+	// Optional, defaults to not checking the aud claim
+	Audience string
+
+	// JwksRefreshInterval defines how long the transmitter's signing keys
+	// are used before they are fetched again
+	//
+	// Optional, defaults to DefaultJwksRefreshInterval
+	JwksRefreshInterval time.Duration
+
+	// HealthStaleAfter defines how long a polling receiver can go without
+	// a successful poll before Health reports it as unhealthy
+	//
+	// Optional, defaults to three poll intervals, or three long poll
+	// timeouts when long polling, and at least a minute
+	HealthStaleAfter time.Duration
+
+	// HealthMaxPollFailures defines how many poll requests in a row may
+	// fail before Health reports the receiver as not ready, so a single
+	// failed poll doesn't take it out of rotation
+	//
+	// Optional, defaults to DefaultHealthMaxPollFailures
+	HealthMaxPollFailures int
 }
@@ -0,0 +1,140 @@
+package pkg
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+func TestLongPollBackoff(t *testing.T) {
+	tests := []struct {
+		name       string
+		longPoll   bool
+		received   int
+		elapsed    time.Duration
+		emptyPolls int
+		want       time.Duration
+		wantEmpty  int
+	}{
+		{"short polling", false, 0, 0, 0, 0, 0},
+		{"events received", true, 3, 0, 2, 0, 0},
+		{"held open", true, 0, 30 * time.Second, 2, 0, 0},
+		{"first immediate empty response", true, 0, 10 * time.Millisecond, 0, time.Second, 1},
+		{"third immediate empty response", true, 0, 10 * time.Millisecond, 2, 4 * time.Second, 3},
+		{"capped at poll interval", true, 0, 10 * time.Millisecond, 10, 5 * time.Second, 11},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiver := &SsfReceiverImplementation{longPoll: test.longPoll, pollInterval: 5}
+			got, emptyPolls := receiver.longPollBackoff(test.received, test.elapsed, test.emptyPolls)
+			if got != test.want || emptyPolls != test.wantEmpty {
+				t.Fatalf("longPollBackoff() = %s, %d, want %s, %d", got, emptyPolls, test.want, test.wantEmpty)
+			}
+		})
+	}
+}
+
+func TestPollAcksParsedSetsAndRejectsInvalidOnes(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	metrics := &recordingMetrics{}
+	receiver := newTestReceiver(t, transmitter, ReceiverConfig{Metrics: metrics})
+	transmitter.add("good", testSet(t, "good", nil))
+	transmitter.add("malformed", "not a SET")
+
+	response, err := receiver.Poll()
+	if err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	if len(response.Events) != 1 {
+		t.Fatalf("Poll() returned %d events, want 1", len(response.Events))
+	}
+	if acks := transmitter.acknowledged(); len(acks) != 1 || acks[0] != "good" {
+		t.Fatalf("acknowledged %v, want [good]", acks)
+	}
+	want := []string{
+		"AcksSent(1)",
+		"EventReceived(" + sessionRevokedUri + ")",
+		"ObserveEventAge(" + sessionRevokedUri + ")",
+		"ObservePollLatency()",
+		"SetRejected(" + SetRejectedMalformed + ")",
+	}
+	// The SETs of a batch are parsed in no particular order
+	calls := metrics.take()
+	sort.Strings(calls)
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("Metrics calls = %q, want %q", calls, want)
+	}
+
+	// The rejection is reported with the next poll request
+	_, err = receiver.Poll()
+	if err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	rejected := transmitter.rejected()
+	if setErr, found := rejected["malformed"]; !found || setErr.Err != "invalid_request" {
+		t.Fatalf("rejected %v, want malformed reported as invalid_request", rejected)
+	}
+	if _, found := rejected["good"]; found {
+		t.Fatal("good SET was rejected")
+	}
+	if calls := metrics.take(); !reflect.DeepEqual(calls, []string{"ObservePollLatency()"}) {
+		t.Fatalf("Metrics calls = %q, want only the poll latency", calls)
+	}
+
+	// A poll request that gets no response is counted with status 0
+	transmitter.server.Close()
+	if _, err = receiver.Poll(); err == nil {
+		t.Fatal("Poll() succeeded against a closed transmitter")
+	}
+	if calls := metrics.take(); !reflect.DeepEqual(calls, []string{"ObservePollLatency()", "PollFailed(0)"}) {
+		t.Fatalf("Metrics calls = %q, want the poll latency and PollFailed(0)", calls)
+	}
+}
+
+func TestConfigureCallbackWhilePolling(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	receiver := newTestReceiver(t, transmitter, ReceiverConfig{})
+
+	var mu sync.Mutex
+	received := map[string]int{}
+	callback := func(name string) func([]events.SsfEvent) {
+		return func(polled []events.SsfEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			received[name] += len(polled)
+		}
+	}
+	receivedBy := func(name string) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return received[name] > 0
+		}
+	}
+
+	transmitter.add("first", testSet(t, "first", nil))
+	if err := receiver.ConfigureCallback(callback("first"), 1); err != nil {
+		t.Fatalf("ConfigureCallback() error = %v", err)
+	}
+	waitFor(t, receivedBy("first"))
+
+	// Swap the callback from several goroutines while the loop is polling
+	var swaps sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		swaps.Add(1)
+		go func() {
+			defer swaps.Done()
+			if err := receiver.ConfigureCallback(callback("second"), 1); err != nil {
+				t.Errorf("ConfigureCallback() error = %v", err)
+			}
+		}()
+	}
+	swaps.Wait()
+
+	transmitter.add("second", testSet(t, "second", nil))
+	waitFor(t, receivedBy("second"))
+}
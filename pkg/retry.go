@@ -0,0 +1,197 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defines how the receiver retries transmitter calls and backs off the poll
+// loop when the transmitter fails or throttles it.
+//
+// A Retry-After header sent by the transmitter always takes precedence over
+// the computed backoff
+type RetryPolicy struct {
+	// MaxRetries defines how many times an idempotent call (e.g. getting
+	// the stream status) is retried after a throttling, server or network
+	// error. Non idempotent calls are never retried
+	//
+	// Optional, defaults to 3. A negative value disables retries
+	MaxRetries int
+
+	// InitialBackoff defines the backoff before the first retry. The
+	// backoff doubles on every subsequent retry
+	//
+	// Optional, defaults to 1 second
+	InitialBackoff time.Duration
+
+	// MaxBackoff defines the upper bound of the backoff, including any
+	// Retry-After requested by the transmitter
+	//
+	// Optional, defaults to 5 minutes
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is used when the receiver is not configured with a
+// RetryPolicy
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:     3,
+	InitialBackoff: time.Second,
+	MaxBackoff:     5 * time.Minute,
+}
+
+// Fills in the defaults for the unset fields of the policy
+func (policy RetryPolicy) withDefaults() RetryPolicy {
+	if policy.MaxRetries == 0 {
+		policy.MaxRetries = DefaultRetryPolicy.MaxRetries
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = DefaultRetryPolicy.MaxBackoff
+	}
+	return policy
+}
+
+// Returns how long to wait after the given number of consecutive failures,
+// the last of which is err
+func (policy RetryPolicy) Backoff(failures int, err error) time.Duration {
+	policy = policy.withDefaults()
+
+	var transmitterErr *TransmitterError
+	if errors.As(err, &transmitterErr) && transmitterErr.RetryAfter > 0 {
+		if transmitterErr.RetryAfter > policy.MaxBackoff {
+			return policy.MaxBackoff
+		}
+		return transmitterErr.RetryAfter
+	}
+
+	backoff := policy.InitialBackoff
+	for i := 1; i < failures && backoff < policy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > policy.MaxBackoff {
+		return policy.MaxBackoff
+	}
+	return backoff
+}
+
+// Reports whether a failed call is worth retrying: network errors,
+// throttling and temporary server errors are, anything else is not
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var transmitterErr *TransmitterError
+	if !errors.As(err, &transmitterErr) {
+		return true
+	}
+
+	switch transmitterErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// Parses a Retry-After header value, given either in seconds or as an
+// HTTP-date. Returns 0 if the value is missing or invalid
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	retryAt, err := http.ParseTime(value)
+	if err != nil {
+		return 0
+	}
+
+	wait := time.Until(retryAt)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Waits for the given duration, returning early with the context's error if
+// it is done first
+func sleepContext(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Limits the rate of requests made to a transmitter by spacing them out
+// evenly
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// Creates a rate limiter allowing the given number of requests per second,
+// or nil if requestsPerSecond is not positive
+func newRateLimiter(requestsPerSecond float64) *rateLimiter {
+	if requestsPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / requestsPerSecond)}
+}
+
+// Blocks until the next request is allowed to be made. Cancelled calls
+// don't use up the rate: no slot is reserved when ctx is already done, and
+// the reserved slot is given back when ctx is done while waiting for it
+func (limiter *rateLimiter) wait(ctx context.Context) error {
+	if limiter == nil {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	limiter.mu.Lock()
+	now := time.Now()
+	if limiter.next.Before(now) {
+		limiter.next = now
+	}
+	slot := limiter.next
+	wait := slot.Sub(now)
+	limiter.next = slot.Add(limiter.interval)
+	limiter.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	err := sleepContext(ctx, wait)
+	if err != nil {
+		// The slot can only be given back when no later call reserved the
+		// one after it
+		limiter.mu.Lock()
+		if limiter.next.Equal(slot.Add(limiter.interval)) {
+			limiter.next = slot
+		}
+		limiter.mu.Unlock()
+	}
+	return err
+}
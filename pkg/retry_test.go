@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"empty", "", 0},
+		{"seconds", "120", 2 * time.Minute},
+		{"seconds with spaces", " 5 ", 5 * time.Second},
+		{"negative seconds", "-1", 0},
+		{"invalid", "soon", 0},
+		{"past date", "Wed, 21 Oct 2015 07:28:00 GMT", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parseRetryAfter(test.value); got != test.want {
+				t.Fatalf("parseRetryAfter(%q) = %s, want %s", test.value, got, test.want)
+			}
+		})
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(future); got < 59*time.Minute || got > time.Hour {
+		t.Fatalf("parseRetryAfter(%q) = %s, want about an hour", future, got)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	tests := []struct {
+		name     string
+		failures int
+		err      error
+		want     time.Duration
+	}{
+		{"first failure", 1, errors.New("network"), time.Second},
+		{"doubles", 3, errors.New("network"), 4 * time.Second},
+		{"capped", 10, errors.New("network"), 10 * time.Second},
+		{"retry after", 1, &TransmitterError{StatusCode: 429, RetryAfter: 7 * time.Second}, 7 * time.Second},
+		{"retry after capped", 1, &TransmitterError{StatusCode: 503, RetryAfter: time.Hour}, 10 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := policy.Backoff(test.failures, test.err); got != test.want {
+				t.Fatalf("Backoff(%d) = %s, want %s", test.failures, got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"network error", errors.New("connection refused"), true},
+		{"throttled", &TransmitterError{StatusCode: 429}, true},
+		{"unavailable", &TransmitterError{StatusCode: 503}, true},
+		{"bad request", &TransmitterError{StatusCode: 400}, false},
+		{"not found", &TransmitterError{StatusCode: 404}, false},
+		{"cancelled", context.Canceled, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isRetryable(test.err); got != test.want {
+				t.Fatalf("isRetryable(%v) = %v, want %v", test.err, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterSkipsCancelledCalls(t *testing.T) {
+	limiter := newRateLimiter(10)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := limiter.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	next := limiter.next
+	for i := 0; i < 5; i++ {
+		if err := limiter.wait(cancelled); !errors.Is(err, context.Canceled) {
+			t.Fatalf("wait with a cancelled context returned %v", err)
+		}
+	}
+	if !limiter.next.Equal(next) {
+		t.Fatalf("cancelled calls reserved slots: next moved by %s", limiter.next.Sub(next))
+	}
+}
+
+func TestRateLimiterGivesBackAbortedSlot(t *testing.T) {
+	limiter := newRateLimiter(1)
+	if err := limiter.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	next := limiter.next
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wait returned %v, want the deadline error", err)
+	}
+	if !limiter.next.Equal(next) {
+		t.Fatalf("aborted wait kept its slot: next moved by %s", limiter.next.Sub(next))
+	}
+}
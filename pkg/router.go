@@ -0,0 +1,118 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+// Routes SSF Events to the handlers registered for their event type.
+//
+// A router is used as the receiver's Handler, so the same routes apply to
+// events received by polling and by push delivery:
+//
+//	router := pkg.NewRouter()
+//	router.OnSessionRevoked(func(ctx context.Context, event *events.SessionRevokedEvent) error {
+//		return revokeSessions(event.Subject)
+//	})
+//	receiverConfig.Handler = router.Handle
+//
+// Events without a registered handler are passed to the fallback handler.
+// Without a fallback they are acknowledged and dropped
+type Router struct {
+	mu       sync.RWMutex
+	routes   map[events.EventType]Handler
+	fallback Handler
+}
+
+// Creates an empty router
+func NewRouter() *Router {
+	return &Router{routes: map[events.EventType]Handler{}}
+}
+
+// Registers the handler for the given event type, replacing any handler
+// previously registered for it
+func (router *Router) Route(eventType events.EventType, handler Handler) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	router.routes[eventType] = handler
+}
+
+// Registers the handler called for events without a registered handler
+func (router *Router) Fallback(handler Handler) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	router.fallback = handler
+}
+
+// Passes the event to the handler registered for its type, or to the
+// fallback handler. Has the signature of a Handler
+func (router *Router) Handle(ctx context.Context, event events.SsfEvent) error {
+	router.mu.RLock()
+	handler, found := router.routes[event.GetType()]
+	if !found {
+		handler = router.fallback
+	}
+	router.mu.RUnlock()
+
+	if handler == nil {
+		return nil
+	}
+	return handler(ctx, event)
+}
+
+// Registers a handler for the event type of T, called with the event
+// already converted to T, e.g.
+//
+//	pkg.Handle(router, func(ctx context.Context, event *events.CredentialChangeEvent) error { ... })
+//
+// T must be one of the event structs of the ssf_events package
+func Handle[T events.SsfEvent](router *Router, handler func(ctx context.Context, event T) error) {
+	// The event structs return their type without dereferencing the
+	// receiver, so the type can be read from a nil T
+	var zero T
+	router.Route(zero.GetType(), func(ctx context.Context, event events.SsfEvent) error {
+		typedEvent, ok := event.(T)
+		if !ok {
+			return fmt.Errorf("cannot convert event of type %T to %T", event, zero)
+		}
+		return handler(ctx, typedEvent)
+	})
+}
+
+// Registers the handler for session revoked events
+func (router *Router) OnSessionRevoked(handler func(ctx context.Context, event *events.SessionRevokedEvent) error) {
+	Handle(router, handler)
+}
+
+// Registers the handler for credential change events
+func (router *Router) OnCredentialChange(handler func(ctx context.Context, event *events.CredentialChangeEvent) error) {
+	Handle(router, handler)
+}
+
+// Registers the handler for device compliance change events
+func (router *Router) OnDeviceCompliance(handler func(ctx context.Context, event *events.DeviceComplianceEvent) error) {
+	Handle(router, handler)
+}
+
+// Registers the handler for assurance level change events
+func (router *Router) OnAssuranceLevelChange(handler func(ctx context.Context, event *events.AssuranceLevelChangeEvent) error) {
+	Handle(router, handler)
+}
+
+// Registers the handler for token claims change events
+func (router *Router) OnTokenClaimsChange(handler func(ctx context.Context, event *events.TokenClaimsChangeEvent) error) {
+	Handle(router, handler)
+}
+
+// Registers the handler for verification events
+func (router *Router) OnVerification(handler func(ctx context.Context, event *events.VerificationEvent) error) {
+	Handle(router, handler)
+}
+
+// Registers the handler for stream updated events
+func (router *Router) OnStreamUpdated(handler func(ctx context.Context, event *events.StreamUpdatedEvent) error) {
+	Handle(router, handler)
+}
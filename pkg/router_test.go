@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+func TestRouterRoutesByEventType(t *testing.T) {
+	var routed []string
+	router := NewRouter()
+	router.OnSessionRevoked(func(ctx context.Context, event *events.SessionRevokedEvent) error {
+		routed = append(routed, "session revoked "+event.Subject["email"].(string))
+		return nil
+	})
+	Handle(router, func(ctx context.Context, event *events.CredentialChangeEvent) error {
+		routed = append(routed, "credential change")
+		return errors.New("credential store unavailable")
+	})
+
+	tests := []struct {
+		name     string
+		event    events.SsfEvent
+		fallback Handler
+		want     string
+		wantErr  bool
+	}{
+		{"On helper", testEvent("user@example.com", 0), nil, "session revoked user@example.com", false},
+		{"generic Handle", &events.CredentialChangeEvent{}, nil, "credential change", true},
+		{"unrouted without fallback", &events.DeviceComplianceEvent{}, nil, "", false},
+		{"unrouted with fallback", &events.DeviceComplianceEvent{}, func(ctx context.Context, event events.SsfEvent) error {
+			routed = append(routed, "fallback "+event.GetEventUri())
+			return nil
+		}, "fallback " + (&events.DeviceComplianceEvent{}).GetEventUri(), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			routed = nil
+			router.Fallback(test.fallback)
+			err := router.Handle(context.Background(), test.event)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Handle() error = %v, wantErr %v", err, test.wantErr)
+			}
+			got := ""
+			if len(routed) > 0 {
+				got = routed[0]
+			}
+			if len(routed) > 1 || got != test.want {
+				t.Fatalf("Handle() routed to %q, want %q", routed, test.want)
+			}
+		})
+	}
+}
+
+func TestRouterRouteReplacesHandler(t *testing.T) {
+	router := NewRouter()
+	calls := map[string]int{}
+	router.Route(events.SessionRevoked, func(ctx context.Context, event events.SsfEvent) error {
+		calls["first"]++
+		return nil
+	})
+	router.Route(events.SessionRevoked, func(ctx context.Context, event events.SsfEvent) error {
+		calls["second"]++
+		return nil
+	})
+
+	if err := router.Handle(context.Background(), testEvent("user@example.com", 0)); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if calls["first"] != 0 || calls["second"] != 1 {
+		t.Fatalf("handlers called %v, want only the second", calls)
+	}
+}
@@ -0,0 +1,194 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Defines what Shutdown does with the receiver's stream
+type ShutdownAction int
+
+const (
+	// The stream is left as is, so the transmitter keeps queueing events
+	// for the receiver until it is restarted
+	ShutdownKeepStream ShutdownAction = iota
+
+	// The stream is paused, so the transmitter holds the events for the
+	// receiver until the stream is enabled again
+	ShutdownPauseStream
+
+	// The stream is deleted from the transmitter
+	ShutdownDeleteStream
+)
+
+// shutdownFlushTimeout defines how long Shutdown spends acknowledging the
+// handled SETs once its context is done
+const shutdownFlushTimeout = 5 * time.Second
+
+// Stops the receiver gracefully. The poll loop is stopped, any in progress
+// poll request is cancelled, and the events that were already received are
+// passed to the handler before the receiver waits for the handler calls in
+// progress to return. The SETs that were handled are then acknowledged with
+// the transmitter, and the stream is paused or deleted according to the
+// receiver's ShutdownAction.
+//
+// When the context is done before the handlers return, Shutdown stops
+// waiting for them, acknowledges the SETs that were already handled and
+// saves the state within shutdownFlushTimeout, and returns the context's
+// error; the SETs the handlers didn't acknowledge are redelivered by the
+// transmitter. The receiver cannot be started again once it has been shut
+// down
+func (receiver *SsfReceiverImplementation) Shutdown(ctx context.Context) error {
+	return receiver.shutdown(ctx, receiver.shutdownAction)
+}
+
+// Shuts the receiver down and deletes the Receiver's stream from the
+// transmitter. Failures are logged, use Delete to handle them
+func (receiver *SsfReceiverImplementation) DeleteReceiver() {
+	err := receiver.Delete(context.Background())
+	if err != nil {
+		receiver.logger.Error("failed to delete receiver", slog.Any("error", err))
+	}
+}
+
+// Shuts the receiver down like Shutdown, then deletes the Receiver's
+// stream from the transmitter
+func (receiver *SsfReceiverImplementation) Delete(ctx context.Context) error {
+	return receiver.shutdown(ctx, ShutdownDeleteStream)
+}
+
+func (receiver *SsfReceiverImplementation) shutdown(ctx context.Context, action ShutdownAction) error {
+	err := receiver.stop(ctx)
+	if err != nil {
+		// The SETs handled so far are still acknowledged, so they aren't
+		// redelivered after a restart
+		flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownFlushTimeout)
+		defer cancel()
+		return errors.Join(err, receiver.flushAcks(flushCtx), receiver.saveState())
+	}
+
+	var errs []error
+	errs = append(errs, receiver.flushAcks(ctx))
+	errs = append(errs, receiver.saveState())
+
+	switch action {
+	case ShutdownPauseStream:
+		_, err = receiver.PauseStream()
+		errs = append(errs, err)
+	case ShutdownDeleteStream:
+		errs = append(errs, receiver.deleteStream(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// Stops the poll loop, the metadata refresh and the routines passing events
+// to the handler, waiting for the handler calls in progress to return
+func (receiver *SsfReceiverImplementation) stop(ctx context.Context) error {
+	receiver.lifecycleMu.Lock()
+	alreadyStopped := receiver.stopped
+	receiver.stopped = true
+	receiver.lifecycleMu.Unlock()
+
+	if alreadyStopped {
+		return nil
+	}
+	receiver.logger.Info("shutting down receiver")
+	receiver.metadata.Stop()
+
+	if pollDone := receiver.stopPolling(); pollDone != nil {
+		err := waitDone(ctx, pollDone)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The poll loop was the only sender, so closing the deliveries channel
+	// lets its consumers drain the buffered deliveries and return
+	if deliveries := receiver.deliveryChannel(); deliveries != nil {
+		close(deliveries)
+	}
+
+	if receiver.stopWorkers != nil {
+		receiver.stopWorkers()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		receiver.workers.Wait()
+		if receiver.dispatcher != nil {
+			receiver.dispatcher.Close()
+		}
+		close(done)
+	}()
+	return waitDone(ctx, done)
+}
+
+// Runs fn in the background until the receiver is shut down. fn must
+// return once its context is cancelled
+func (receiver *SsfReceiverImplementation) startWorker(fn func(ctx context.Context) error) {
+	receiver.lifecycleMu.Lock()
+	defer receiver.lifecycleMu.Unlock()
+
+	if receiver.stopWorkers == nil {
+		var ctx context.Context
+		ctx, receiver.stopWorkers = context.WithCancel(context.Background())
+		receiver.workerCtx = ctx
+	}
+
+	receiver.workers.Add(1)
+	go func(ctx context.Context) {
+		defer receiver.workers.Done()
+		fn(ctx)
+	}(receiver.workerCtx)
+}
+
+// Sends the pending acknowledgements and SET errors to the transmitter
+// without asking for more events
+func (receiver *SsfReceiverImplementation) flushAcks(ctx context.Context) error {
+	acks, setErrors := receiver.takePendingAcks()
+	if len(acks) == 0 && len(setErrors) == 0 {
+		return nil
+	}
+
+	ctx, span := receiver.startSpan(ctx, SpanAck)
+	span.SetAttribute(AttributeAckCount, len(acks))
+	pollRequest := PollTransmitterRequest{Acknowledgements: acks, SetErrors: setErrors, MaxEvents: 0, ReturnImmediately: true}
+	_, err := receiver.client.send(ctx, "POST", receiver.transmitterPollUrl, pollRequest, http.StatusOK, http.StatusAccepted)
+	endSpan(span, err)
+	if err != nil {
+		// Keep them in the saved state so they are sent after a restart
+		receiver.restorePendingAcks(acks, setErrors)
+		receiver.logger.Warn("acknowledgement failed", slog.Int("count", len(acks)), slog.Any("error", err))
+		return err
+	}
+
+	receiver.metrics.AcksSent(len(acks))
+	receiver.logger.Debug("acknowledged events", slog.Int("count", len(acks)), slog.Int("set_errors", len(setErrors)))
+	return nil
+}
+
+// Deletes the receiver's stream from the transmitter
+func (receiver *SsfReceiverImplementation) deleteStream(ctx context.Context) error {
+	_, err := receiver.client.send(ctx, "DELETE", receiver.configUrl()+"?stream_id="+url.QueryEscape(receiver.streamId), nil, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		receiver.logger.Warn("stream deletion failed", slog.Any("error", err))
+		return err
+	}
+
+	receiver.logger.Info("deleted stream")
+	return nil
+}
+
+// Waits for done to be closed or the context to be done
+func waitDone(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+func TestShutdownFlushesAcksWhenHandlersOutliveTheContext(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	transmitter.add("handled", testSet(t, "handled", jwt.MapClaims{"iat": 100}))
+	transmitter.add("stuck", testSet(t, "stuck", jwt.MapClaims{"iat": 200}))
+
+	release := make(chan struct{})
+	defer close(release)
+	var mu sync.Mutex
+	calls := 0
+	store := NewFileStateStore(filepath.Join(t.TempDir(), "state.json"))
+	receiver := newTestReceiver(t, transmitter, ReceiverConfig{
+		StateStore: store,
+		Handler: func(ctx context.Context, event events.SsfEvent) error {
+			mu.Lock()
+			calls++
+			first := calls == 1
+			mu.Unlock()
+			if !first {
+				<-release
+			}
+			return nil
+		},
+	})
+
+	// Both SETs are for the same subject, so the stuck one is handled once
+	// the first one is acknowledged
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls == 2
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := receiver.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() error = %v, want context.DeadlineExceeded", err)
+	}
+
+	if acks := transmitter.acknowledged(); !reflect.DeepEqual(acks, []string{"handled"}) {
+		t.Fatalf("acknowledged %v, want [handled]", acks)
+	}
+	state, err := store.Load()
+	if err != nil || len(state.PendingAcks) != 0 {
+		t.Fatalf("Load() = %+v, %v, want no pending acks", state, err)
+	}
+}
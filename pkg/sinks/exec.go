@@ -0,0 +1,87 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// DefaultExecTimeout is how long an exec sink's command may run for each
+// event before it is killed
+const DefaultExecTimeout = 30 * time.Second
+
+// Configures an ExecSink
+type ExecOptions struct {
+	// Env defines additional environment variables, as "KEY=value", the
+	// command is run with. The daemon's environment is inherited
+	//
+	// Optional
+	Env []string
+
+	// Timeout defines how long the command may run for each event
+	//
+	// Optional, defaults to DefaultExecTimeout
+	Timeout time.Duration
+}
+
+// Runs a command for every event, with the event as JSON on its standard
+// input. The event's id, type, issuer and receiver are also set in the
+// SSF_EVENT_ID, SSF_EVENT_TYPE, SSF_ISSUER and SSF_RECEIVER environment
+// variables. A non-zero exit status means the event wasn't handled
+type ExecSink struct {
+	command []string
+	opts    ExecOptions
+}
+
+// Returns a sink running the given command, the program followed by its
+// arguments, for every event
+func NewExecSink(command []string, opts ExecOptions) (*ExecSink, error) {
+	if len(command) == 0 {
+		return nil, errors.New("exec sink command is empty")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultExecTimeout
+	}
+	return &ExecSink{command: command, opts: opts}, nil
+}
+
+func (sink *ExecSink) Send(ctx context.Context, event Event) error {
+	input, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sink.opts.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, sink.command[0], sink.command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = append(os.Environ(), sink.opts.Env...)
+	cmd.Env = append(cmd.Env,
+		"SSF_EVENT_ID="+event.Id,
+		"SSF_EVENT_TYPE="+event.Type,
+		"SSF_ISSUER="+event.Issuer,
+		"SSF_RECEIVER="+event.Receiver,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		output := bytes.TrimSpace(stderr.Bytes())
+		if len(output) > 512 {
+			output = output[len(output)-512:]
+		}
+		return fmt.Errorf("command %s failed: %w: %s", sink.command[0], err, output)
+	}
+	return nil
+}
+
+// Close does nothing, a command is run for each event
+func (sink *ExecSink) Close() error {
+	return nil
+}
@@ -0,0 +1,192 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultFileMaxSize is the size a file sink's file grows to before it is
+// rotated
+const DefaultFileMaxSize = 100 << 20
+
+// DefaultFileMaxBackups is how many rotated files a file sink keeps
+const DefaultFileMaxBackups = 5
+
+// Writes events as newline delimited JSON to an io.Writer
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Returns a sink writing events as newline delimited JSON to w
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// Returns a sink writing events as newline delimited JSON to the standard
+// output
+func Stdout() *WriterSink {
+	return NewWriterSink(os.Stdout)
+}
+
+func (sink *WriterSink) Send(ctx context.Context, event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	_, err = sink.w.Write(line)
+	return err
+}
+
+// Close does nothing, the writer is owned by the caller
+func (sink *WriterSink) Close() error {
+	return nil
+}
+
+// Configures a FileSink
+type FileOptions struct {
+	// MaxSize defines the size in bytes the file grows to before it is
+	// rotated
+	//
+	// Optional, defaults to DefaultFileMaxSize
+	MaxSize int64
+
+	// MaxBackups defines how many rotated files are kept, named after the
+	// file with a .1, .2, ... suffix, .1 being the most recent
+	//
+	// Optional, defaults to DefaultFileMaxBackups
+	MaxBackups int
+
+	// Sync defines whether the file is synced after every event, so events
+	// survive a crash of the machine once they are acknowledged
+	//
+	// Optional, defaults to false
+	Sync bool
+}
+
+// Appends events as newline delimited JSON to a file, rotating it once it
+// reaches its maximum size
+type FileSink struct {
+	path string
+	opts FileOptions
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Opens the file at path for appending, creating it and its directory if
+// needed
+func NewFileSink(path string, opts FileOptions) (*FileSink, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultFileMaxSize
+	}
+	if opts.MaxBackups <= 0 {
+		opts.MaxBackups = DefaultFileMaxBackups
+	}
+
+	sink := &FileSink{path: path, opts: opts}
+	err := sink.open()
+	if err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (sink *FileSink) open() error {
+	err := os.MkdirAll(filepath.Dir(sink.path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(sink.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	sink.file, sink.size = file, info.Size()
+	return nil
+}
+
+func (sink *FileSink) Send(ctx context.Context, event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.file == nil {
+		return errors.New("file sink is closed")
+	}
+
+	if sink.size > 0 && sink.size+int64(len(line)) > sink.opts.MaxSize {
+		err = sink.rotate()
+		if err != nil {
+			return fmt.Errorf("rotating %s: %w", sink.path, err)
+		}
+	}
+
+	n, err := sink.file.Write(line)
+	sink.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if sink.opts.Sync {
+		return sink.file.Sync()
+	}
+	return nil
+}
+
+// Renames the file to .1, shifting the older backups and removing the
+// oldest one, and opens a new file. Must be called with mu held
+func (sink *FileSink) rotate() error {
+	err := sink.file.Close()
+	sink.file = nil
+	if err != nil {
+		return err
+	}
+
+	backup := func(i int) string { return fmt.Sprintf("%s.%d", sink.path, i) }
+	err = os.Remove(backup(sink.opts.MaxBackups))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := sink.opts.MaxBackups - 1; i >= 1; i-- {
+		err = os.Rename(backup(i), backup(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	err = os.Rename(sink.path, backup(1))
+	if err != nil {
+		return err
+	}
+	return sink.open()
+}
+
+func (sink *FileSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.file == nil {
+		return nil
+	}
+	err := sink.file.Close()
+	sink.file = nil
+	return err
+}
@@ -0,0 +1,104 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Returns the ids of the events in a file sink's file
+func readEventIds(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		ids = append(ids, event.Id)
+	}
+	return ids
+}
+
+func TestFileSinkRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events", "events.ndjson")
+	line, err := json.Marshal(Event{Id: "evt-0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every file holds two events
+	sink, err := NewFileSink(path, FileOptions{MaxSize: int64(2 * (len(line) + 1)), MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	for i := 0; i < 7; i++ {
+		err = sink.Send(context.Background(), Event{Id: fmt.Sprintf("evt-%d", i)})
+		if err != nil {
+			t.Fatalf("Send(evt-%d) = %v", i, err)
+		}
+	}
+
+	// The oldest file, evt-0 and evt-1, was removed
+	want := map[string][]string{
+		path:        {"evt-6"},
+		path + ".1": {"evt-4", "evt-5"},
+		path + ".2": {"evt-2", "evt-3"},
+	}
+	for file, ids := range want {
+		if got := readEventIds(t, file); fmt.Sprint(got) != fmt.Sprint(ids) {
+			t.Fatalf("%s holds %v, want %v", filepath.Base(file), got, ids)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("%s.3 exists, want at most 2 backups", filepath.Base(path))
+	}
+}
+
+func TestFileSinkResumesSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	line, err := json.Marshal(Event{Id: "evt-0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := FileOptions{MaxSize: int64(2 * (len(line) + 1))}
+
+	sink, err := NewFileSink(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := sink.Send(context.Background(), Event{Id: fmt.Sprintf("evt-%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reopened file is rotated once it is full, not after another
+	// MaxSize bytes
+	sink, err = NewFileSink(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Send(context.Background(), Event{Id: "evt-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readEventIds(t, path); fmt.Sprint(got) != "[evt-2]" {
+		t.Fatalf("file holds %v, want [evt-2]", got)
+	}
+	if got := readEventIds(t, path+".1"); fmt.Sprint(got) != "[evt-0 evt-1]" {
+		t.Fatalf("backup holds %v, want [evt-0 evt-1]", got)
+	}
+}
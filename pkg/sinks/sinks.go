@@ -0,0 +1,212 @@
+// Package sinks forwards the events received by an SSF receiver to other
+// systems: standard output, files, HTTP webhooks and commands.
+//
+// Every event is converted to an Event, a stable JSON representation of
+// the SsfEvent types, and sent to a Sink. Implement Sink to forward events
+// elsewhere, and use Handler to plug a sink into a receiver:
+//
+//	receiver, err := pkg.ConfigureSsfReceiver(pkg.ReceiverConfig{
+//		...
+//		Handler: sinks.Handler("caep.dev", sinks.Stdout()),
+//	})
+//
+// A handler error leaves the event unacknowledged, so events are delivered
+// to sinks at least once and sinks may see the same event again.
+package sinks
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sgnl-ai/caep.dev-receiver/pkg"
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+// Receives the events of one or more receivers. Send must be safe to call
+// from several goroutines, and must return an error when the event wasn't
+// stored or forwarded, so it is redelivered
+type Sink interface {
+	// Send forwards a single event
+	Send(ctx context.Context, event Event) error
+
+	// Close releases the resources of the sink. Send isn't called after
+	// Close
+	Close() error
+}
+
+// The JSON representation of an event sent to sinks.
+//
+// The shape is stable: fields are only ever added. The event specific
+// fields are in Data, keyed as follows for each event type:
+//
+//	session-revoked           (none)
+//	credential-change         credential_type, change_type
+//	device-compliance-change  previous_status, current_status
+//	assurance-level-change    namespace, current_level, previous_level, change_direction
+//	token-claims-change       claims
+//	verification              state
+//	stream-updated            status, reason
+type Event struct {
+	// Id identifies the event: the JTI of its SET and its type joined by
+	// a "#". Redelivered events have the same Id
+	Id string `json:"id"`
+
+	// Type defines the short name of the event type, the last segment of
+	// its URI, e.g. "session-revoked"
+	Type string `json:"type"`
+
+	// Uri defines the event type URI
+	Uri string `json:"uri"`
+
+	// Receiver defines the name of the receiver the event was received by
+	Receiver string `json:"receiver,omitempty"`
+
+	// Issuer defines the issuer of the SET
+	Issuer string `json:"issuer,omitempty"`
+
+	// JTI defines the unique id of the SET
+	JTI string `json:"jti,omitempty"`
+
+	// Txn defines the transaction id of the SET, if any
+	Txn string `json:"txn,omitempty"`
+
+	// DeliveryMethod defines how the SET was received, "poll" or "push"
+	DeliveryMethod string `json:"delivery_method,omitempty"`
+
+	// IssuedAt defines when the SET was issued, in Unix time
+	IssuedAt int64 `json:"issued_at,omitempty"`
+
+	// EventTimestamp defines when the event happened, in Unix time
+	EventTimestamp int64 `json:"event_timestamp,omitempty"`
+
+	// SubjectFormat defines the format of the subject, e.g. "email", or
+	// "complex" for complex subjects
+	SubjectFormat string `json:"subject_format,omitempty"`
+
+	// Subject defines the subject identifier the event applies to
+	Subject map[string]interface{} `json:"subject,omitempty"`
+
+	// Data defines the event specific fields
+	Data map[string]interface{} `json:"data"`
+
+	// ReceivedAt defines when the receiver handled the event
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// Converts an event to its JSON representation. info describes the SET the
+// event was received in, see pkg.EventInfoFromContext. An empty receiver
+// defaults to the receiver's name in its pkg.ReceiverManager
+func NewEvent(receiver string, info pkg.EventInfo, event events.SsfEvent) Event {
+	if receiver == "" {
+		receiver = info.Receiver
+	}
+	uri := event.GetEventUri()
+	converted := Event{
+		Type:           uri[strings.LastIndex(uri, "/")+1:],
+		Uri:            uri,
+		Receiver:       receiver,
+		Issuer:         info.Issuer,
+		JTI:            info.JTI,
+		Txn:            info.Txn,
+		DeliveryMethod: info.DeliveryMethod,
+		Data:           map[string]interface{}{},
+		ReceivedAt:     time.Now().UTC(),
+	}
+	converted.Id = converted.JTI + "#" + converted.Type
+
+	var claims map[string]interface{}
+	switch event := event.(type) {
+	case *events.VerificationEvent:
+		claims = event.Json
+		converted.Data["state"] = event.State
+	case *events.StreamUpdatedEvent:
+		claims = event.Json
+		converted.Data["status"] = event.Status
+		converted.Data["reason"] = event.Reason
+	case *events.SessionRevokedEvent:
+		claims = event.Json
+	case *events.CredentialChangeEvent:
+		claims = event.Json
+		converted.Data["credential_type"] = string(event.CredentialType)
+		converted.Data["change_type"] = string(event.ChangeType)
+	case *events.DeviceComplianceEvent:
+		claims = event.Json
+		converted.Data["previous_status"] = event.PreviousStatus
+		converted.Data["current_status"] = event.CurrentStatus
+	case *events.AssuranceLevelChangeEvent:
+		claims = event.Json
+		converted.Data["namespace"] = event.Namespace
+		converted.Data["current_level"] = event.CurrentLevel
+		if event.PreviousLevel != nil && *event.PreviousLevel != "" {
+			converted.Data["previous_level"] = *event.PreviousLevel
+		}
+		if event.ChangeDirection != nil && *event.ChangeDirection != "" {
+			converted.Data["change_direction"] = *event.ChangeDirection
+		}
+	case *events.TokenClaimsChangeEvent:
+		claims = event.Json
+		converted.Data["claims"] = event.Claims
+	}
+
+	// Verification and stream updated events apply to the stream, not to
+	// a subject
+	if _, ok := event.(*events.VerificationEvent); !ok {
+		if _, ok := event.(*events.StreamUpdatedEvent); !ok {
+			converted.SubjectFormat = events.SubjectFormatName[event.GetSubjectFormat()]
+			converted.Subject = event.GetSubject()
+			converted.EventTimestamp = event.GetTimestamp()
+		}
+	}
+
+	if iat, ok := claims["iat"].(float64); ok {
+		converted.IssuedAt = int64(iat)
+	}
+	if converted.Issuer == "" {
+		converted.Issuer, _ = claims["iss"].(string)
+	}
+	if converted.JTI == "" {
+		converted.JTI, _ = claims["jti"].(string)
+		converted.Id = converted.JTI + "#" + converted.Type
+	}
+	return converted
+}
+
+// Returns a handler sending every event to the sink, tagged with the name
+// of the receiver. Pass an empty name to tag the events of a
+// pkg.ReceiverManager with the name of the receiver they came from. The
+// event is left unacknowledged when the sink fails
+func Handler(receiver string, sink Sink) pkg.Handler {
+	return func(ctx context.Context, event events.SsfEvent) error {
+		info, _ := pkg.EventInfoFromContext(ctx)
+		return sink.Send(ctx, NewEvent(receiver, info, event))
+	}
+}
+
+// Returns a sink sending every event to all the given sinks. Sending fails
+// when any of the sinks fails, after the event was sent to all of them
+func Fanout(sinks ...Sink) Sink {
+	return fanout(sinks)
+}
+
+type fanout []Sink
+
+func (sinks fanout) Send(ctx context.Context, event Event) error {
+	var errs []error
+	for _, sink := range sinks {
+		err := sink.Send(ctx, event)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (sinks fanout) Close() error {
+	var errs []error
+	for _, sink := range sinks {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,274 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sgnl-ai/caep.dev-receiver/pkg"
+)
+
+// DefaultWebhookTimeout is how long a webhook sink waits for the endpoint
+// to respond
+const DefaultWebhookTimeout = 10 * time.Second
+
+// The headers set on every webhook request
+const (
+	// EventIdHeader carries the Id of the event, so endpoints can ignore
+	// redelivered events
+	EventIdHeader = "X-SSF-Event-Id"
+
+	// TimestampHeader carries the time the request was signed, in Unix
+	// time
+	TimestampHeader = "X-SSF-Timestamp"
+
+	// SignatureHeader carries the signature of the request, see
+	// WebhookSignature. Only set when the sink has a Secret
+	SignatureHeader = "X-SSF-Signature"
+)
+
+// Configures a WebhookSink
+type WebhookOptions struct {
+	// Headers defines additional headers sent with every request, e.g. an
+	// Authorization header
+	//
+	// Optional
+	Headers map[string]string
+
+	// Timeout defines how long to wait for the endpoint to respond, to
+	// each attempt
+	//
+	// Optional, defaults to DefaultWebhookTimeout
+	Timeout time.Duration
+
+	// Client defines the HTTP client the requests are made with
+	//
+	// Optional, defaults to http.DefaultClient
+	Client *http.Client
+
+	// Secret defines the key requests are signed with, see
+	// WebhookSignature
+	//
+	// Optional, requests aren't signed if empty
+	Secret []byte
+
+	// RetryPolicy defines how often, and after how long, a request is
+	// retried after a network error, a 408, a 429 or a 5xx response. Other
+	// responses aren't retried
+	//
+	// Optional, defaults to pkg.DefaultRetryPolicy. A negative MaxRetries
+	// disables retries
+	RetryPolicy pkg.RetryPolicy
+
+	// FailureLogPath defines a file events are appended to, as newline
+	// delimited JSON, once all the attempts to send them failed. Sending
+	// then succeeds, so the event is acknowledged. See FailedDelivery.
+	//
+	// Unlike a pkg.DeadLetterStore, the log can't be listed or replayed
+	// through the receiver, it is meant to be inspected or reprocessed by
+	// hand
+	//
+	// Optional, defaults to none: sending fails and the event is left
+	// unacknowledged
+	FailureLogPath string
+}
+
+// POSTs every event as JSON, in the shape of Event, to an HTTP endpoint.
+// Any 2xx response means the event was accepted.
+//
+// Every request carries the X-SSF-Event-Id and X-SSF-Timestamp headers, and
+// an X-SSF-Signature header when the sink has a Secret. Failed requests are
+// retried with an exponential backoff, then the event is written to the
+// failure log if one is configured
+type WebhookSink struct {
+	url  string
+	opts WebhookOptions
+
+	mu         sync.Mutex
+	failureLog *os.File
+}
+
+// A line of a webhook sink's failure log
+type FailedDelivery struct {
+	// Event defines the event that couldn't be sent
+	Event Event `json:"event"`
+
+	// Url defines the endpoint the event was sent to
+	Url string `json:"url"`
+
+	// Attempts defines how many times sending was attempted
+	Attempts int `json:"attempts"`
+
+	// Error defines the error of the last attempt
+	Error string `json:"error"`
+
+	// FailedAt defines when the last attempt failed
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// Returns a sink POSTing events to the given url. Fails if the failure log
+// can't be opened
+func NewWebhookSink(url string, opts WebhookOptions) (*WebhookSink, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultWebhookTimeout
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	sink := &WebhookSink{url: url, opts: opts}
+	if opts.FailureLogPath != "" {
+		err := os.MkdirAll(filepath.Dir(opts.FailureLogPath), 0o755)
+		if err != nil {
+			return nil, err
+		}
+		sink.failureLog, err = os.OpenFile(opts.FailureLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sink, nil
+}
+
+// Returns the signature of a webhook request: "sha256=" followed by the hex
+// encoded HMAC-SHA256, keyed with secret, of the timestamp header, a "."
+// and the request body. Endpoints should compute it the same way, compare
+// it in constant time, and reject old timestamps to prevent replays
+func WebhookSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (sink *WebhookSink) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	policy := sink.opts.RetryPolicy
+	maxRetries := policy.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = pkg.DefaultRetryPolicy.MaxRetries
+	}
+	attempts := 0
+	for {
+		attempts++
+		var retryable bool
+		retryable, err = sink.post(ctx, event.Id, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempts > maxRetries || ctx.Err() != nil {
+			break
+		}
+
+		timer := time.NewTimer(policy.Backoff(attempts, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+
+	// The event isn't logged when the receiver is stopping, so it is
+	// redelivered
+	if sink.failureLog == nil || ctx.Err() != nil {
+		return err
+	}
+	logErr := sink.logFailure(FailedDelivery{
+		Event:    event,
+		Url:      sink.url,
+		Attempts: attempts,
+		Error:    err.Error(),
+		FailedAt: time.Now().UTC(),
+	})
+	if logErr != nil {
+		return errors.Join(err, fmt.Errorf("writing the failure log: %w", logErr))
+	}
+	return nil
+}
+
+// Makes a single attempt to send the body. Returns whether a failed
+// attempt is worth retrying
+func (sink *WebhookSink) post(ctx context.Context, eventId string, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, sink.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", sink.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range sink.opts.Headers {
+		req.Header.Set(name, value)
+	}
+
+	// The timestamp is signed with the body, and renewed on every attempt
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(EventIdHeader, eventId)
+	req.Header.Set(TimestampHeader, timestamp)
+	if len(sink.opts.Secret) > 0 {
+		req.Header.Set(SignatureHeader, WebhookSignature(sink.opts.Secret, timestamp, body))
+	}
+
+	response, err := sink.opts.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	responseBody, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		retryable := response.StatusCode == http.StatusRequestTimeout ||
+			response.StatusCode == http.StatusTooManyRequests ||
+			response.StatusCode >= 500
+		return retryable, fmt.Errorf("webhook %s responded %d: %s", sink.url, response.StatusCode, bytes.TrimSpace(responseBody))
+	}
+	return false, nil
+}
+
+func (sink *WebhookSink) logFailure(failure FailedDelivery) error {
+	line, err := json.Marshal(failure)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.failureLog == nil {
+		return errors.New("webhook sink is closed")
+	}
+	_, err = sink.failureLog.Write(line)
+	if err != nil {
+		return err
+	}
+	return sink.failureLog.Sync()
+}
+
+// Closes the failure log, if any
+func (sink *WebhookSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.failureLog == nil {
+		return nil
+	}
+	err := sink.failureLog.Close()
+	sink.failureLog = nil
+	return err
+}
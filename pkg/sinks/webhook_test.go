@@ -0,0 +1,130 @@
+package sinks
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sgnl-ai/caep.dev-receiver/pkg"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	// The expected signatures were computed with
+	// printf '<timestamp>.<body>' | openssl dgst -sha256 -hmac '<secret>'
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      string
+		want      string
+	}{
+		{"event", "whsec_test", "1700000000", `{"id":"evt-1"}`, "sha256=5056f09710e0bebdbcd623bb1a7714db4eac94f18745b31b96dd55a69f444e14"},
+		{"empty body", "whsec_test", "1700000000", "", "sha256=5967f3c560522fa40cf2876ebc3c3a08551dd6959aaade3b413460591895bdcc"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := WebhookSignature([]byte(test.secret), test.timestamp, []byte(test.body))
+			if got != test.want {
+				t.Fatalf("WebhookSignature() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWebhookSinkSignsRequests(t *testing.T) {
+	secret := []byte("whsec_test")
+	var mu sync.Mutex
+	var signatureErr string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get(EventIdHeader) != "jti-1#session-revoked" {
+			signatureErr = "unexpected event id " + r.Header.Get(EventIdHeader)
+		}
+		want := WebhookSignature(secret, r.Header.Get(TimestampHeader), body)
+		if got := r.Header.Get(SignatureHeader); got != want {
+			signatureErr = "signature " + got + ", want " + want
+		}
+	}))
+	defer server.Close()
+
+	sink, err := NewWebhookSink(server.URL, WebhookOptions{Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	err = sink.Send(context.Background(), Event{Id: "jti-1#session-revoked", JTI: "jti-1"})
+	if err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if signatureErr != "" {
+		t.Fatal(signatureErr)
+	}
+}
+
+func TestWebhookSinkLogsFailedDeliveries(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "failures", "webhook.ndjson")
+	sink, err := NewWebhookSink(server.URL, WebhookOptions{
+		RetryPolicy:    pkg.RetryPolicy{MaxRetries: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		FailureLogPath: path,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sink.Send(context.Background(), Event{Id: "jti-1#session-revoked", JTI: "jti-1"})
+	if err != nil {
+		t.Fatalf("Send() = %v, want the event to be logged", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if requests != 3 {
+		t.Fatalf("webhook got %d requests, want 3", requests)
+	}
+	mu.Unlock()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var failures []FailedDelivery
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var failure FailedDelivery
+		if err := json.Unmarshal(scanner.Bytes(), &failure); err != nil {
+			t.Fatal(err)
+		}
+		failures = append(failures, failure)
+	}
+	if len(failures) != 1 {
+		t.Fatalf("failure log has %d lines, want 1", len(failures))
+	}
+	if failure := failures[0]; failure.Event.Id != "jti-1#session-revoked" || failure.Url != server.URL || failure.Attempts != 3 || failure.Error == "" {
+		t.Fatalf("failure log line = %+v", failure)
+	}
+}
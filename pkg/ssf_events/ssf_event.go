@@ -3,6 +3,7 @@ package ssf_events
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 type EventType int
@@ -61,6 +62,20 @@ type SsfEvent interface {
 	GetType() EventType
 }
 
+// The name of each subject format, as used in the format member of subject
+// identifiers. Complex subjects don't have a format member
+var SubjectFormatName = map[SubjectFormat]string{
+	Account:                  AccountSubjectFormat,
+	Email:                    EmailSubjectFormat,
+	IssuerAndSubject:         IssuerAndSubjectFormat,
+	Opaque:                   OpaqueSubjectFormat,
+	PhoneNumber:              PhoneNumberSubjectFormat,
+	DecentralizedIdentifier:  DecentralizedIdentifierSubjectFormat,
+	UniqueResourceIdentifier: UniqueResourceIdentifierSubjectFormat,
+	Aliases:                  AliasesSubjectFormat,
+	ComplexSubject:           "complex",
+}
+
 var EventUri = map[EventType]string{
 	SessionRevoked:         "https://schemas.openid.net/secevent/caep/event-type/session-revoked",
 	CredentialChange:       "https://schemas.openid.net/secevent/caep/event-type/credential-change",
@@ -298,3 +313,77 @@ func EventTypeArrayToEventUriArray(events []EventType) []string {
 	}
 	return eventUriArr
 }
+
+// The members each simple subject format requires, as defined by RFC 9493
+var subjectFormatMembers = map[string][]string{
+	AccountSubjectFormat:                  {"uri"},
+	EmailSubjectFormat:                    {"email"},
+	IssuerAndSubjectFormat:                {"iss", "sub"},
+	OpaqueSubjectFormat:                   {"id"},
+	PhoneNumberSubjectFormat:              {"phone_number"},
+	DecentralizedIdentifierSubjectFormat:  {"url"},
+	UniqueResourceIdentifierSubjectFormat: {"uri"},
+}
+
+// Checks that a subject identifier is well formed: its format is known and
+// it has the members its format requires, as defined by RFC 9493. Subjects
+// without a format are complex subjects, whose members are each validated
+func ValidateSubject(subject map[string]interface{}) error {
+	format, found := subject["format"]
+	if !found {
+		if len(subject) == 0 {
+			return errors.New("subject is empty")
+		}
+		for member, value := range subject {
+			memberSubject, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("complex subject member %q is not a subject identifier", member)
+			}
+			err := ValidateSubject(memberSubject)
+			if err != nil {
+				return fmt.Errorf("complex subject member %q: %w", member, err)
+			}
+		}
+		return nil
+	}
+
+	formatString, ok := format.(string)
+	if !ok {
+		return errors.New("subject format is not a string")
+	}
+	if formatString == AliasesSubjectFormat {
+		identifiers, ok := subject["identifiers"].([]interface{})
+		if !ok || len(identifiers) == 0 {
+			return errors.New("aliases subject is missing its identifiers")
+		}
+		for _, identifier := range identifiers {
+			alias, ok := identifier.(map[string]interface{})
+			if !ok {
+				return errors.New("aliases subject contains an identifier that is not an object")
+			}
+			if alias["format"] == AliasesSubjectFormat {
+				return errors.New("aliases subject must not contain aliases identifiers")
+			}
+			err := ValidateSubject(alias)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	members, known := subjectFormatMembers[formatString]
+	if !known {
+		return fmt.Errorf("unknown subject format %q", formatString)
+	}
+	for _, member := range members {
+		value, ok := subject[member].(string)
+		if !ok || value == "" {
+			return fmt.Errorf("%s subject is missing the %q member", formatString, member)
+		}
+	}
+	if formatString == AccountSubjectFormat && !strings.HasPrefix(subject["uri"].(string), "acct:") {
+		return errors.New(`account subject uri must use the "acct:" scheme`)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package ssf_events
+
+import "testing"
+
+func TestValidateSubject(t *testing.T) {
+	email := map[string]interface{}{"format": "email", "email": "user@example.com"}
+	tests := []struct {
+		name    string
+		subject map[string]interface{}
+		wantErr bool
+	}{
+		{"account", map[string]interface{}{"format": "account", "uri": "acct:user@example.com"}, false},
+		{"account without the acct scheme", map[string]interface{}{"format": "account", "uri": "mailto:user@example.com"}, true},
+		{"account without uri", map[string]interface{}{"format": "account"}, true},
+		{"email", email, false},
+		{"email without email", map[string]interface{}{"format": "email"}, true},
+		{"empty email", map[string]interface{}{"format": "email", "email": ""}, true},
+		{"email that isn't a string", map[string]interface{}{"format": "email", "email": 42.0}, true},
+		{"iss_sub", map[string]interface{}{"format": "iss_sub", "iss": "https://idp.example.com", "sub": "user-1"}, false},
+		{"iss_sub without sub", map[string]interface{}{"format": "iss_sub", "iss": "https://idp.example.com"}, true},
+		{"opaque", map[string]interface{}{"format": "opaque", "id": "11112222333344445555"}, false},
+		{"opaque without id", map[string]interface{}{"format": "opaque"}, true},
+		{"phone_number", map[string]interface{}{"format": "phone_number", "phone_number": "+12065550100"}, false},
+		{"phone_number without phone_number", map[string]interface{}{"format": "phone_number", "phone": "+12065550100"}, true},
+		{"did", map[string]interface{}{"format": "did", "url": "did:example:123456"}, false},
+		{"did without url", map[string]interface{}{"format": "did", "uri": "did:example:123456"}, true},
+		{"uri", map[string]interface{}{"format": "uri", "uri": "https://example.com/users/1"}, false},
+		{"uri without uri", map[string]interface{}{"format": "uri"}, true},
+		{"unknown format", map[string]interface{}{"format": "username", "username": "user"}, true},
+		{"format that isn't a string", map[string]interface{}{"format": 1.0}, true},
+		{"empty", map[string]interface{}{}, true},
+		{"aliases", map[string]interface{}{"format": "aliases", "identifiers": []interface{}{email, map[string]interface{}{"format": "opaque", "id": "1"}}}, false},
+		{"aliases without identifiers", map[string]interface{}{"format": "aliases"}, true},
+		{"aliases with no identifiers", map[string]interface{}{"format": "aliases", "identifiers": []interface{}{}}, true},
+		{"nested aliases", map[string]interface{}{"format": "aliases", "identifiers": []interface{}{map[string]interface{}{"format": "aliases", "identifiers": []interface{}{email}}}}, true},
+		{"aliases with an invalid identifier", map[string]interface{}{"format": "aliases", "identifiers": []interface{}{map[string]interface{}{"format": "email"}}}, true},
+		{"aliases with an identifier that isn't an object", map[string]interface{}{"format": "aliases", "identifiers": []interface{}{"user@example.com"}}, true},
+		{"complex", map[string]interface{}{"user": email, "tenant": map[string]interface{}{"format": "opaque", "id": "tenant-1"}}, false},
+		{"complex with an invalid member", map[string]interface{}{"user": email, "tenant": map[string]interface{}{"format": "opaque"}}, true},
+		{"complex with a member that isn't an object", map[string]interface{}{"user": "user@example.com"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateSubject(test.subject)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ValidateSubject(%v) error = %v, wantErr %v", test.subject, err, test.wantErr)
+			}
+		})
+	}
+}
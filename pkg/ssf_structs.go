@@ -1,6 +1,14 @@
 package pkg
 
-import event "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	event "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
 
 // Represents the interface for the SSF receiver with user facing
 // methods
@@ -11,9 +19,23 @@ type SsfReceiver interface {
 	// Events
 	PollEvents() ([]event.SsfEvent, error)
 
-	// Cleans up the Receiver's resources and deletes it from the transmitter
+	// Makes a single poll request to the configured receiver and returns
+	// the available SSF Events along with whether the transmitter has
+	// more events available
+	Poll() (*PollResponse, error)
+
+	// Cleans up the Receiver's resources and deletes it from the
+	// transmitter, logging any failure
 	DeleteReceiver()
 
+	// Cleans up the Receiver's resources and deletes its stream from the
+	// transmitter, returning any failure
+	Delete(ctx context.Context) error
+
+	// Stops the receiver gracefully, waiting for the events being handled
+	// and acknowledging them with the transmitter before returning
+	Shutdown(ctx context.Context) error
+
 	// Get stream status from the transmitter
 	GetStreamStatus() (StreamStatus, error)
 
@@ -25,6 +47,37 @@ type SsfReceiver interface {
 
 	// Disable the stream
 	DisableStream() (StreamStatus, error)
+
+	// Returns a channel that delivers the received SSF Events one at a
+	// time. Each Delivery must be acknowledged with Ack, or Nack'ed so it
+	// is redelivered by the transmitter. Polling is started if it isn't
+	// running yet
+	Events() <-chan Delivery
+
+	// Returns the http.Handler that receives SETs pushed by the
+	// transmitter, for receivers configured with a PushEndpointUrl
+	PushHandler() http.Handler
+
+	// Returns the events the handler failed on MaxAttempts times, for
+	// receivers configured with a DeadLetterStore
+	DeadLetters() ([]DeadLetter, error)
+
+	// Passes a dead-lettered event to the handler again, removing it from
+	// the DeadLetterStore once the handler succeeds
+	ReplayDeadLetter(ctx context.Context, id string) error
+
+	// Returns the most recently discovered transmitter configuration
+	// metadata
+	GetTransmitterConfig() *TransmitterConfig
+
+	// Reports the receiver's health: its last successful poll, consecutive
+	// poll failures, stream status, last SET verification and the
+	// freshness of the transmitter's signing keys
+	Health(ctx context.Context) Health
+
+	// Returns an http.Handler exposing Health as /healthz and /readyz JSON
+	// endpoints
+	HealthHandler() http.Handler
 }
 
 // The struct that contains all the necessary fields and methods for the
@@ -50,16 +103,146 @@ type SsfReceiverImplementation struct {
 	// receiver with the transmitter
 	authorizationToken string
 
+	// client makes the receiver's requests to the transmitter
+	client *transmitterClient
+
 	// pollCallback defines the method the receiver will call to pass
-	// events into when the poll interval is triggered
+	// events into when the poll interval is triggered. Guarded by mu
 	pollCallback func(events []event.SsfEvent)
 
 	// pollInterval defines the interval, in seconds, between every
 	// poll request the receiver will make to the transmitter. After
 	// each poll request, the available SSF events will be passed in
-	// a function call to pollCallback
+	// a function call to pollCallback. Guarded by mu
 	pollInterval int
 
+	// pollWake wakes the poll loop up when the poll interval changes
+	pollWake chan struct{}
+
+	// pushEndpointUrl defines the url the transmitter pushes SETs to,
+	// empty for poll receivers
+	pushEndpointUrl string
+
+	// logger defines the logger the receiver logs to, with the
+	// transmitter and stream id attached
+	logger *slog.Logger
+
+	// metrics receives the receiver's measurements
+	metrics Metrics
+
+	// tracer records the receiver's work as trace spans
+	tracer Tracer
+
+	// jwks caches the transmitter's signing keys, nil if the receiver
+	// doesn't verify SET signatures
+	jwks *JwksCache
+
+	// audience defines the audience the SETs must be issued to, empty if
+	// the aud claim isn't checked
+	audience string
+
+	// pollStartedAt defines when the poll loop was started
+	pollStartedAt time.Time
+
+	// pollFailures and lastPollError define how many poll requests failed
+	// in a row and the last error. Guarded by mu
+	pollFailures  int
+	lastPollError error
+
+	// lastVerification defines the result of verifying the most recent
+	// SET. Guarded by mu
+	lastVerification *VerificationResult
+
+	// staleAfter defines how long the receiver can go without a
+	// successful poll before it is reported as unhealthy
+	staleAfter time.Duration
+
+	// maxPollFailures defines how many poll requests in a row may fail
+	// before the receiver is reported as not ready
+	maxPollFailures int
+
+	// streamStatus, streamStatusErr and streamStatusCheckedAt cache the
+	// stream status reported by Health. Guarded by mu
+	streamStatus          StreamStatus
+	streamStatusErr       error
+	streamStatusCheckedAt time.Time
+
+	// maxEvents defines the maximum number of events requested in each
+	// poll request
+	maxEvents int
+
+	// deliveries defines the channel returned by Events, nil until Events
+	// is first called
+	deliveries chan Delivery
+
+	// deliveryBufferSize defines the capacity of the deliveries channel
+	deliveryBufferSize int
+
+	// handler defines the method the receiver passes each received
+	// event to, nil if the receiver has no handler
+	handler Handler
+
+	// pushAuthorizationHeader defines the Authorization header the
+	// transmitter must send with pushed SETs
+	pushAuthorizationHeader string
+
+	// dedup remembers the JTIs of the processed SETs, nil if duplicates
+	// are not detected
+	dedup DedupStore
+
+	// stateStore persists the receiver's state, nil if the state isn't
+	// persisted
+	stateStore StateStore
+
+	// savedState and savedStateAt define the state last saved to the
+	// state store and when. Guarded by stateMu
+	savedState   *ReceiverState
+	savedStateAt time.Time
+	stateMu      sync.Mutex
+
+	// lastPollTime defines when the transmitter was last polled
+	// successfully
+	lastPollTime time.Time
+
+	// inbox stores the polled SETs durably before they are acknowledged,
+	// nil if the receiver has no inbox
+	inbox *Inbox
+
+	// dispatcher dispatches the received events to the configured
+	// handler, nil if the receiver has no handler
+	dispatcher *Dispatcher
+
+	// deadLetters stores the events the handler failed on maxAttempts
+	// times, nil if events are never dead-lettered
+	deadLetters DeadLetterStore
+	maxAttempts int
+
+	// attempts tracks the failed attempts to handle events until they are
+	// dead-lettered
+	attempts attemptTracker
+
+	// replayHandler defines the handler dead letters are replayed with,
+	// the handler without the dead-lettering middleware
+	replayHandler Handler
+
+	// pendingAcks and pendingSetErrors contain the acknowledgements and
+	// SET errors to send with the next poll request
+	pendingAcks      []string
+	pendingSetErrors map[string]SetError
+	ackMu            sync.Mutex
+
+	// maxPollsPerCycle defines how many poll requests each poll cycle
+	// makes at most while the transmitter has more events available
+	maxPollsPerCycle int
+
+	// longPoll defines whether poll requests ask the transmitter to hold
+	// the request open until events are available
+	longPoll bool
+
+	// longPollTimeout defines how long a long poll request is held open
+	// before the receiver gives up and polls again
+	longPollTimeout time.Duration
+
 	// configurationUrl defines the transmitter's configuration url
 	configurationUrl string
 
@@ -67,8 +250,36 @@ type SsfReceiverImplementation struct {
 	// transmitter
 	streamId string
 
-	// terminate is used to stop the push interval routine
-	terminate chan bool
+	// pollCancel and pollDone are used to stop the poll loop routine
+	pollCancel context.CancelFunc
+	pollDone   chan struct{}
+
+	// workerCtx, stopWorkers and workers are used to stop the routines
+	// passing the received events to the handler
+	workerCtx   context.Context
+	stopWorkers context.CancelFunc
+	workers     sync.WaitGroup
+
+	// shutdownAction defines what Shutdown does with the stream
+	shutdownAction ShutdownAction
+
+	// stopped is set once the receiver has been shut down
+	stopped bool
+
+	// lifecycleMu guards starting and stopping the receiver's routines
+	lifecycleMu sync.Mutex
+
+	// metadata caches the transmitter's configuration metadata and
+	// refreshes it in the background
+	metadata *TransmitterMetadataCache
+
+	// transmitterConfig defines the most recently discovered transmitter
+	// configuration metadata
+	transmitterConfig *TransmitterConfig
+
+	// mu guards the fields that are updated when the transmitter
+	// metadata changes
+	mu sync.RWMutex
 }
 
 // Struct used to read a Transmitter's configuration
@@ -78,8 +289,13 @@ type TransmitterConfig struct {
 	DeliveryMethodsSupported []string                 `json:"delivery_methods_supported,omitempty"`
 	ConfigurationEndpoint    string                   `json:"configuration_endpoint,omitempty"`
 	StatusEndpoint           string                   `json:"status_endpoint,omitempty"`
+	AddSubjectEndpoint       string                   `json:"add_subject_endpoint,omitempty"`
+	RemoveSubjectEndpoint    string                   `json:"remove_subject_endpoint,omitempty"`
+	VerificationEndpoint     string                   `json:"verification_endpoint,omitempty"`
+	CriticalSubjectMembers   []string                 `json:"critical_subject_members,omitempty"`
 	SpecVersion              string                   `json:"spec_version,omitempty"`
 	AuthorizationSchemes     []map[string]interface{} `json:"authorization_schemes,omitempty"`
+	DefaultSubjects          string                   `json:"default_subjects,omitempty"`
 }
 
 // Struct used to make a Create Stream request for the receiver
@@ -91,15 +307,49 @@ type CreateStreamReq struct {
 
 // Struct that defines the deliver method for the Create Stream Request
 type SsfDelivery struct {
-	Method string `json:"method"`
+	Method              string `json:"method"`
+	EndpointUrl         string `json:"endpoint_url,omitempty"`
+	AuthorizationHeader string `json:"authorization_header,omitempty"`
 }
 
 // Struct to make a request to poll SSF Events to the
 // configured transmitter
 type PollTransmitterRequest struct {
-	Acknowledgements  []string `json:"ack"`
-	MaxEvents         int      `json:"maxEvents,omitempty"`
-	ReturnImmediately bool     `json:"returnImmediately"`
+	Acknowledgements  []string            `json:"ack"`
+	SetErrors         map[string]SetError `json:"setErrs,omitempty"`
+	MaxEvents         int                 `json:"maxEvents"`
+	ReturnImmediately bool                `json:"returnImmediately"`
+}
+
+// Struct that contains the SETs returned by the transmitter in response to
+// a poll request
+type PollTransmitterResponse struct {
+	Sets          map[string]string `json:"sets"`
+	MoreAvailable bool              `json:"moreAvailable"`
+}
+
+// Struct used to report to the transmitter that a SET was rejected by the
+// receiver, as defined by RFC 8936. The error codes are defined by RFC 8935:
+// the receiver reports "invalid_key" for a bad signature, "invalid_issuer"
+// for a SET of another issuer and "invalid_request" for any other problem
+type SetError struct {
+	Err         string `json:"err"`
+	Description string `json:"description"`
+}
+
+func (e *SetError) Error() string {
+	return e.Err + ": " + e.Description
+}
+
+// Struct that contains the result of a single poll request
+type PollResponse struct {
+	// Events defines the SSF Events returned by the transmitter
+	Events []event.SsfEvent
+
+	// MoreAvailable defines whether the transmitter has more events
+	// available than it returned, as reported by the RFC 8936
+	// moreAvailable member
+	MoreAvailable bool
 }
 
 // Struct to make a request to update the stream status
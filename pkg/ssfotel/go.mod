@@ -0,0 +1,18 @@
+module github.com/sgnl-ai/caep.dev-receiver/pkg/ssfotel
+
+go 1.21
+
+require (
+	github.com/sgnl-ai/caep.dev-receiver v0.0.0-00010101000000-000000000000
+	go.opentelemetry.io/otel v1.21.0
+	go.opentelemetry.io/otel/trace v1.21.0
+)
+
+require (
+	github.com/go-logr/logr v1.3.0 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/golang-jwt/jwt/v5 v5.0.0 // indirect
+	go.opentelemetry.io/otel/metric v1.21.0 // indirect
+)
+
+replace github.com/sgnl-ai/caep.dev-receiver => ../..
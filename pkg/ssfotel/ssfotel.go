@@ -0,0 +1,103 @@
+// Package ssfotel records the spans of an SSF receiver with OpenTelemetry.
+//
+// It is a separate module so the receiver itself doesn't depend on
+// OpenTelemetry. Configure the receiver with a Tracer created by NewTracer,
+// and wrap its push handler with PushHandler so pushed SETs continue the
+// transmitter's trace:
+//
+//	receiver, err := pkg.ConfigureSsfReceiver(pkg.ReceiverConfig{
+//		...
+//		Tracer: ssfotel.NewTracer(nil),
+//	})
+//	http.Handle("/ssf/push", ssfotel.PushHandler(receiver.PushHandler(), nil))
+package ssfotel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/sgnl-ai/caep.dev-receiver/pkg"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The instrumentation scope the receiver's spans are recorded under
+const ScopeName = "github.com/sgnl-ai/caep.dev-receiver/pkg/ssfotel"
+
+// A pkg.Tracer that records the receiver's spans with an OpenTelemetry
+// tracer
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// Creates a Tracer recording spans with the given tracer provider. A nil
+// provider uses the global tracer provider
+func NewTracer(provider trace.TracerProvider) *Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return &Tracer{tracer: provider.Tracer(ScopeName)}
+}
+
+func (tracer *Tracer) Start(ctx context.Context, name string) (context.Context, pkg.Span) {
+	kind := trace.SpanKindInternal
+	switch name {
+	case pkg.SpanPoll, pkg.SpanAck:
+		kind = trace.SpanKindClient
+	case pkg.SpanPush:
+		kind = trace.SpanKindServer
+	case pkg.SpanHandle:
+		kind = trace.SpanKindConsumer
+	}
+
+	ctx, span := tracer.tracer.Start(ctx, name, trace.WithSpanKind(kind))
+	return ctx, spanAdapter{span}
+}
+
+// Adapts an OpenTelemetry span to pkg.Span
+type spanAdapter struct {
+	span trace.Span
+}
+
+func (adapter spanAdapter) SetAttribute(key string, value interface{}) {
+	switch value := value.(type) {
+	case string:
+		adapter.span.SetAttributes(attribute.String(key, value))
+	case int:
+		adapter.span.SetAttributes(attribute.Int(key, value))
+	case int64:
+		adapter.span.SetAttributes(attribute.Int64(key, value))
+	case bool:
+		adapter.span.SetAttributes(attribute.Bool(key, value))
+	default:
+		adapter.span.SetAttributes(attribute.String(key, fmt.Sprint(value)))
+	}
+}
+
+func (adapter spanAdapter) RecordError(err error) {
+	adapter.span.RecordError(err)
+	adapter.span.SetStatus(codes.Error, err.Error())
+}
+
+func (adapter spanAdapter) End() {
+	adapter.span.End()
+}
+
+// Wraps the receiver's push handler so the trace context sent by the
+// transmitter is extracted from the request headers, making the receiver's
+// push span a child of the transmitter's span. A nil propagator uses the
+// global text map propagator
+func PushHandler(next http.Handler, propagator propagation.TextMapPropagator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := propagator
+		if p == nil {
+			p = otel.GetTextMapPropagator()
+		}
+		ctx := p.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
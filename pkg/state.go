@@ -0,0 +1,329 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+// Contains the receiver state that survives restarts
+type ReceiverState struct {
+	// TransmitterUrl defines the transmitter the state belongs to
+	TransmitterUrl string `json:"transmitter_url"`
+
+	// StreamId defines the Id of the receiver's stream
+	StreamId string `json:"stream_id"`
+
+	// TransmitterConfig defines the most recently discovered transmitter
+	// configuration metadata
+	TransmitterConfig *TransmitterConfig `json:"transmitter_config,omitempty"`
+
+	// PendingAcks defines the JTIs of the processed SETs that were not
+	// acknowledged with the transmitter yet
+	PendingAcks []string `json:"pending_acks,omitempty"`
+
+	// PendingSetErrors defines the rejected SETs that were not reported to
+	// the transmitter yet, keyed by JTI
+	PendingSetErrors map[string]SetError `json:"pending_set_errors,omitempty"`
+
+	// LastPollTime defines when the transmitter was last polled
+	// successfully
+	LastPollTime time.Time `json:"last_poll_time,omitempty"`
+}
+
+// Persists the receiver state, so a restarted receiver resumes its stream
+// instead of creating a new one.
+//
+// Implementations must be safe for concurrent use
+type StateStore interface {
+	// Returns the saved state, or nil if no state was saved yet
+	Load() (*ReceiverState, error)
+
+	// Saves the state, replacing the previously saved state
+	Save(state *ReceiverState) error
+}
+
+// A StateStore that saves the state as a JSON file.
+//
+// The file is replaced atomically on every save, by writing the state to a
+// temporary file that is synced and then renamed over the previous file,
+// so a crash never leaves a partially written state behind
+type FileStateStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Creates a state store saving the state to the file at the given path
+func NewFileStateStore(path string) *FileStateStore {
+	return &FileStateStore{path: path}
+}
+
+func (store *FileStateStore) Load() (*ReceiverState, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	data, err := os.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state ReceiverState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (store *FileStateStore) Save(state *ReceiverState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return writeFileAtomic(store.path, data)
+}
+
+// Writes the data to a temporary file next to path, syncs it, and renames
+// it over path
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	// Sync the directory so the rename itself is durable
+	return syncDir(dir)
+}
+
+// Loads the saved state for the configured transmitter, ignoring state
+// saved for another transmitter
+func loadReceiverState(store StateStore, transmitterUrl string) (*ReceiverState, error) {
+	if store == nil {
+		return nil, nil
+	}
+
+	state, err := store.Load()
+	if err != nil || state == nil {
+		return nil, err
+	}
+
+	if !issuersMatch(state.TransmitterUrl, transmitterUrl) || state.StreamId == "" {
+		return nil, nil
+	}
+	return state, nil
+}
+
+// Resumes the saved stream, updating it when the events or push endpoint
+// requested by the receiver changed since it was created.
+//
+// Returns an empty stream id when the stream no longer exists, or when it
+// uses another delivery method or can't be updated; the stream is then
+// deleted and a new one must be created
+func resumeStream(client *transmitterClient, configurationUrl string, streamId string, cfg ReceiverConfig, logger *slog.Logger) (string, error) {
+	stream, err := fetchStream(client, configurationUrl, streamId)
+	if err != nil {
+		return "", err
+	}
+	if stream == nil {
+		logger.Info("saved stream no longer exists", slog.String("stream_id", streamId))
+		return "", nil
+	}
+
+	recreate, update := compareStream(stream, cfg)
+	if update && !recreate {
+		delivery := streamDelivery(cfg)
+		request := StreamConfiguration{
+			StreamId:        streamId,
+			Delivery:        &delivery,
+			EventsRequested: events.EventTypeArrayToEventUriArray(cfg.EventsRequested),
+		}
+		_, err = client.send(context.Background(), "PATCH", configurationUrl, request, http.StatusOK, http.StatusAccepted)
+		if err == nil {
+			logger.Info("updated stream to match the receiver configuration", slog.String("stream_id", streamId))
+			return streamId, nil
+		}
+
+		// A transmitter refusing the update is asked for a new stream
+		// instead, any other failure is worth retrying
+		status := TransmitterStatusCode(err)
+		if status < http.StatusBadRequest || status >= http.StatusInternalServerError {
+			return "", err
+		}
+		logger.Warn("transmitter refused the stream update", slog.String("stream_id", streamId), slog.Any("error", err))
+		recreate = true
+	}
+
+	if recreate {
+		logger.Info("replacing stream that no longer matches the receiver configuration", slog.String("stream_id", streamId))
+		_, err = client.send(context.Background(), "DELETE", withStreamId(configurationUrl, streamId), nil, http.StatusOK, http.StatusNoContent)
+		if err != nil {
+			logger.Warn("failed to delete the saved stream", slog.String("stream_id", streamId), slog.Any("error", err))
+		}
+		return "", nil
+	}
+	return streamId, nil
+}
+
+// Returns the configuration of the stream on the transmitter, or nil if
+// the stream no longer exists
+func fetchStream(client *transmitterClient, configurationUrl string, streamId string) (*StreamConfiguration, error) {
+	body, err := client.send(context.Background(), "GET", withStreamId(configurationUrl, streamId), nil, http.StatusOK)
+	if err != nil {
+		switch TransmitterStatusCode(err) {
+		case http.StatusNotFound, http.StatusGone:
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	streams, err := decodeStreams(body)
+	if err != nil {
+		return nil, err
+	}
+	for _, stream := range streams {
+		if stream.StreamId == streamId || (stream.StreamId == "" && len(streams) == 1) {
+			return &stream, nil
+		}
+	}
+	return nil, nil
+}
+
+// Compares a stream with the receiver's configuration. Reports whether the
+// stream uses another delivery method, which requires a new stream, and
+// whether its push endpoint or requested events differ, which can be
+// updated. Fields the transmitter doesn't return are assumed unchanged
+func compareStream(stream *StreamConfiguration, cfg ReceiverConfig) (recreate bool, update bool) {
+	delivery := streamDelivery(cfg)
+	if stream.Delivery != nil {
+		if stream.Delivery.Method != delivery.Method {
+			return true, false
+		}
+		if delivery.EndpointUrl != "" && stream.Delivery.EndpointUrl != delivery.EndpointUrl {
+			update = true
+		}
+	}
+
+	if len(stream.EventsRequested) > 0 {
+		requested := events.EventTypeArrayToEventUriArray(cfg.EventsRequested)
+		if !sameEventUris(stream.EventsRequested, requested) {
+			update = true
+		}
+	}
+	return false, update
+}
+
+// Reports whether both lists hold the same event URIs, in any order
+func sameEventUris(a []string, b []string) bool {
+	counts := map[string]int{}
+	for _, uri := range a {
+		counts[uri]++
+	}
+	for _, uri := range b {
+		counts[uri]--
+	}
+	for _, count := range counts {
+		if count != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a snapshot of the receiver's current state
+func (receiver *SsfReceiverImplementation) currentState() *ReceiverState {
+	receiver.ackMu.Lock()
+	pendingAcks := append([]string{}, receiver.pendingAcks...)
+	var pendingSetErrors map[string]SetError
+	if len(receiver.pendingSetErrors) > 0 {
+		pendingSetErrors = make(map[string]SetError, len(receiver.pendingSetErrors))
+		for jti, setErr := range receiver.pendingSetErrors {
+			pendingSetErrors[jti] = setErr
+		}
+	}
+	receiver.ackMu.Unlock()
+
+	receiver.mu.RLock()
+	defer receiver.mu.RUnlock()
+	return &ReceiverState{
+		TransmitterUrl:    receiver.transmitterUrl,
+		StreamId:          receiver.streamId,
+		TransmitterConfig: receiver.transmitterConfig,
+		PendingAcks:       pendingAcks,
+		PendingSetErrors:  pendingSetErrors,
+		LastPollTime:      receiver.lastPollTime,
+	}
+}
+
+// stateSaveInterval bounds how often the state is saved after polling when
+// only its LastPollTime changed
+const stateSaveInterval = time.Minute
+
+// Saves the receiver's current state to its state store, if it has one
+func (receiver *SsfReceiverImplementation) saveState() error {
+	return receiver.writeState(true)
+}
+
+// Saves the receiver's current state after a poll request. The state is
+// only written when the pending acknowledgements or SET errors, the stream
+// or the transmitter metadata changed, or when the LastPollTime saved is
+// older than stateSaveInterval, so idle polls don't sync the state file
+func (receiver *SsfReceiverImplementation) saveStateIfChanged() error {
+	return receiver.writeState(false)
+}
+
+func (receiver *SsfReceiverImplementation) writeState(always bool) error {
+	if receiver.stateStore == nil {
+		return nil
+	}
+
+	state := receiver.currentState()
+	receiver.stateMu.Lock()
+	defer receiver.stateMu.Unlock()
+	if !always && receiver.savedState != nil && time.Since(receiver.savedStateAt) < stateSaveInterval {
+		unchanged := *state
+		unchanged.LastPollTime = receiver.savedState.LastPollTime
+		if reflect.DeepEqual(&unchanged, receiver.savedState) {
+			return nil
+		}
+	}
+
+	err := receiver.stateStore.Save(state)
+	if err != nil {
+		return err
+	}
+	receiver.savedState, receiver.savedStateAt = state, time.Now()
+	return nil
+}
@@ -0,0 +1,164 @@
+package pkg
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+func TestResumeStream(t *testing.T) {
+	tests := []struct {
+		name         string
+		savedStream  string
+		cfg          ReceiverConfig
+		wantStreamId string
+		wantPatch    int
+		wantDelete   int
+		wantCreate   int
+	}{
+		{
+			name:         "unchanged stream is resumed",
+			savedStream:  "stream-1",
+			wantStreamId: "stream-1",
+		},
+		{
+			name:         "changed events are updated",
+			savedStream:  "stream-1",
+			cfg:          ReceiverConfig{EventsRequested: []events.EventType{events.SessionRevoked, events.CredentialChange}},
+			wantStreamId: "stream-1",
+			wantPatch:    1,
+		},
+		{
+			name:         "changed delivery method is recreated",
+			savedStream:  "stream-1",
+			cfg:          ReceiverConfig{PushEndpointUrl: "https://receiver.example.com/events"},
+			wantStreamId: "stream-2",
+			wantDelete:   1,
+			wantCreate:   1,
+		},
+		{
+			name:         "missing stream is recreated",
+			savedStream:  "stream-unknown",
+			wantStreamId: "stream-2",
+			wantCreate:   1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transmitter := newFakeTransmitter(t)
+			store := NewFileStateStore(filepath.Join(t.TempDir(), "state.json"))
+			first := newTestReceiver(t, transmitter, ReceiverConfig{StateStore: store})
+			first.stop(context.Background())
+
+			state, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			state.StreamId = test.savedStream
+			store.Save(state)
+
+			test.cfg.StateStore = store
+			receiver := newTestReceiver(t, transmitter, test.cfg)
+			if receiver.streamId != test.wantStreamId {
+				t.Fatalf("stream id = %q, want %q", receiver.streamId, test.wantStreamId)
+			}
+
+			// The first receiver created stream-1
+			got := map[string]int{
+				"PATCH":  transmitter.streamRequests("PATCH"),
+				"DELETE": transmitter.streamRequests("DELETE"),
+				"POST":   transmitter.streamRequests("POST") - 1,
+			}
+			want := map[string]int{"PATCH": test.wantPatch, "DELETE": test.wantDelete, "POST": test.wantCreate}
+			for method, count := range want {
+				if got[method] != count {
+					t.Fatalf("%s requests = %d, want %d", method, got[method], count)
+				}
+			}
+		})
+	}
+}
+
+func TestPendingSetErrorsSurviveRestart(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	store := NewFileStateStore(filepath.Join(t.TempDir(), "state.json"))
+	first := newTestReceiver(t, transmitter, ReceiverConfig{StateStore: store})
+	first.queueAck("good")
+	first.queueSetError("bad", SetError{Err: "invalid_request", Description: "malformed"})
+	if err := first.saveState(); err != nil {
+		t.Fatalf("saveState() error = %v", err)
+	}
+	first.stop(context.Background())
+
+	receiver := newTestReceiver(t, transmitter, ReceiverConfig{StateStore: store})
+	_, err := receiver.Poll()
+	if err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+
+	if acks := transmitter.acknowledged(); len(acks) != 1 || acks[0] != "good" {
+		t.Fatalf("acknowledged %v, want [good]", acks)
+	}
+	if setErr, found := transmitter.rejected()["bad"]; !found || setErr.Description != "malformed" {
+		t.Fatalf("rejected %v, want bad reported after the restart", transmitter.rejected())
+	}
+}
+
+// A StateStore counting how many times the state was saved
+type countingStateStore struct {
+	StateStore
+	mu    sync.Mutex
+	saves int
+}
+
+func (store *countingStateStore) Save(state *ReceiverState) error {
+	store.mu.Lock()
+	store.saves++
+	store.mu.Unlock()
+	return store.StateStore.Save(state)
+}
+
+func (store *countingStateStore) count() int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.saves
+}
+
+func TestPollSavesStateOnlyWhenChanged(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	store := &countingStateStore{StateStore: NewFileStateStore(filepath.Join(t.TempDir(), "state.json"))}
+	receiver := newTestReceiver(t, transmitter, ReceiverConfig{StateStore: store})
+
+	// The first poll saves its LastPollTime
+	if _, err := receiver.Poll(); err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	saves := store.count()
+	for i := 0; i < 3; i++ {
+		if _, err := receiver.Poll(); err != nil {
+			t.Fatalf("Poll() error = %v", err)
+		}
+	}
+	if got := store.count(); got != saves {
+		t.Fatalf("idle polls saved the state %d times, want 0", got-saves)
+	}
+
+	// A pending acknowledgement is a change
+	receiver.queueAck("jti-1")
+	for i := 0; i < 2; i++ {
+		if err := receiver.saveStateIfChanged(); err != nil {
+			t.Fatalf("saveStateIfChanged() error = %v", err)
+		}
+	}
+	if got := store.count(); got != saves+1 {
+		t.Fatalf("queueing an ack saved the state %d times, want 1", got-saves)
+	}
+
+	state, err := store.Load()
+	if err != nil || len(state.PendingAcks) != 1 || state.PendingAcks[0] != "jti-1" {
+		t.Fatalf("Load() = %+v, %v, want jti-1 pending", state, err)
+	}
+}
@@ -0,0 +1,324 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// The configuration of a stream, as defined by the SSF stream management
+// API
+type StreamConfiguration struct {
+	StreamId                string       `json:"stream_id,omitempty"`
+	Iss                     string       `json:"iss,omitempty"`
+	Aud                     interface{}  `json:"aud,omitempty"`
+	EventsSupported         []string     `json:"events_supported,omitempty"`
+	EventsRequested         []string     `json:"events_requested,omitempty"`
+	EventsDelivered         []string     `json:"events_delivered,omitempty"`
+	Delivery                *SsfDelivery `json:"delivery,omitempty"`
+	MinVerificationInterval int          `json:"min_verification_interval,omitempty"`
+	InactivityTimeout       int          `json:"inactivity_timeout,omitempty"`
+	Description             string       `json:"description,omitempty"`
+}
+
+// The status of a stream, as returned by the transmitter's status endpoint
+type StreamStatusResponse struct {
+	StreamId string `json:"stream_id,omitempty"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Configures a TransmitterClient
+type ClientConfig struct {
+	// TransmitterUrl defines the issuer url of the transmitter
+	//
+	// Required
+	TransmitterUrl string
+
+	// AuthorizationToken defines the bearer token used to authorize the
+	// requests
+	//
+	// Required
+	AuthorizationToken string
+
+	// RetryPolicy defines how failed GET requests are retried
+	//
+	// Optional, defaults to DefaultRetryPolicy
+	RetryPolicy *RetryPolicy
+
+	// RateLimit defines the maximum number of requests per second
+	//
+	// Optional, defaults to 0 (unlimited)
+	RateLimit float64
+}
+
+// Manages the streams of a transmitter through its stream management API,
+// independently of a running receiver
+type TransmitterClient struct {
+	config *TransmitterConfig
+	client *transmitterClient
+}
+
+// Discovers the transmitter's configuration and returns a client for its
+// stream management API
+func NewTransmitterClient(ctx context.Context, cfg ClientConfig) (*TransmitterClient, error) {
+	if cfg.TransmitterUrl == "" || cfg.AuthorizationToken == "" {
+		return nil, errors.New("Client Config - missing required field")
+	}
+
+	transmitterCfg, err := DiscoverTransmitter(ctx, cfg.TransmitterUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	client := newTransmitterClient(ReceiverConfig{
+		AuthorizationToken: cfg.AuthorizationToken,
+		RetryPolicy:        cfg.RetryPolicy,
+		RateLimit:          cfg.RateLimit,
+	})
+	return &TransmitterClient{config: transmitterCfg, client: client}, nil
+}
+
+// Returns the discovered transmitter configuration metadata
+func (client *TransmitterClient) TransmitterConfig() *TransmitterConfig {
+	return client.config
+}
+
+// Creates a stream
+func (client *TransmitterClient) CreateStream(ctx context.Context, stream StreamConfiguration) (*StreamConfiguration, error) {
+	endpoint, err := client.endpoint(client.config.ConfigurationEndpoint, "configuration")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := client.client.send(ctx, "POST", endpoint, stream, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+	return decodeStream(body)
+}
+
+// Returns the configuration of a stream
+func (client *TransmitterClient) GetStream(ctx context.Context, streamId string) (*StreamConfiguration, error) {
+	endpoint, err := client.endpoint(client.config.ConfigurationEndpoint, "configuration")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := client.client.send(ctx, "GET", withStreamId(endpoint, streamId), nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	streams, err := decodeStreams(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(streams) != 1 {
+		return nil, fmt.Errorf("transmitter returned %d streams, a stream id is required", len(streams))
+	}
+	return &streams[0], nil
+}
+
+// Returns the configurations of all the streams the token has access to.
+// Transmitters that only support a single stream per receiver return that
+// stream
+func (client *TransmitterClient) ListStreams(ctx context.Context) ([]StreamConfiguration, error) {
+	endpoint, err := client.endpoint(client.config.ConfigurationEndpoint, "configuration")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := client.client.send(ctx, "GET", endpoint, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return decodeStreams(body)
+}
+
+// Updates the given fields of a stream, identified by its StreamId
+func (client *TransmitterClient) UpdateStream(ctx context.Context, stream StreamConfiguration) (*StreamConfiguration, error) {
+	if stream.StreamId == "" {
+		return nil, errors.New("stream id is required")
+	}
+
+	endpoint, err := client.endpoint(client.config.ConfigurationEndpoint, "configuration")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := client.client.send(ctx, "PATCH", endpoint, stream, http.StatusOK, http.StatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+	return decodeStream(body)
+}
+
+// Deletes a stream
+func (client *TransmitterClient) DeleteStream(ctx context.Context, streamId string) error {
+	endpoint, err := client.endpoint(client.config.ConfigurationEndpoint, "configuration")
+	if err != nil {
+		return err
+	}
+
+	_, err = client.client.send(ctx, "DELETE", withStreamId(endpoint, streamId), nil, http.StatusOK, http.StatusNoContent)
+	return err
+}
+
+// Returns the status of a stream
+func (client *TransmitterClient) GetStreamStatus(ctx context.Context, streamId string) (*StreamStatusResponse, error) {
+	endpoint, err := client.endpoint(client.config.StatusEndpoint, "status")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := client.client.send(ctx, "GET", withStreamId(endpoint, streamId), nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	var status StreamStatusResponse
+	err = json.Unmarshal(body, &status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Sets the status of a stream, with an optional reason
+func (client *TransmitterClient) SetStreamStatus(ctx context.Context, streamId string, status StreamStatus, reason string) (*StreamStatusResponse, error) {
+	endpoint, err := client.endpoint(client.config.StatusEndpoint, "status")
+	if err != nil {
+		return nil, err
+	}
+
+	request := UpdateStreamRequest{StreamId: streamId, Status: EnumToStringStatusMap[status], Reason: reason}
+	body, err := client.client.send(ctx, "POST", endpoint, request, http.StatusOK, http.StatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+
+	var response StreamStatusResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Adds a subject to a stream. verified, if not nil, tells the transmitter
+// whether the receiver verified the subject
+func (client *TransmitterClient) AddSubject(ctx context.Context, streamId string, subject map[string]interface{}, verified *bool) error {
+	endpoint, err := client.endpoint(client.config.AddSubjectEndpoint, "add subject")
+	if err != nil {
+		return err
+	}
+
+	request := struct {
+		StreamId string                 `json:"stream_id"`
+		Subject  map[string]interface{} `json:"subject"`
+		Verified *bool                  `json:"verified,omitempty"`
+	}{streamId, subject, verified}
+	_, err = client.client.send(ctx, "POST", endpoint, request, http.StatusOK, http.StatusNoContent)
+	return err
+}
+
+// Removes a subject from a stream
+func (client *TransmitterClient) RemoveSubject(ctx context.Context, streamId string, subject map[string]interface{}) error {
+	endpoint, err := client.endpoint(client.config.RemoveSubjectEndpoint, "remove subject")
+	if err != nil {
+		return err
+	}
+
+	request := struct {
+		StreamId string                 `json:"stream_id"`
+		Subject  map[string]interface{} `json:"subject"`
+	}{streamId, subject}
+	_, err = client.client.send(ctx, "POST", endpoint, request, http.StatusOK, http.StatusNoContent)
+	return err
+}
+
+// Asks the transmitter to send a verification event on a stream, carrying
+// the given state
+func (client *TransmitterClient) RequestVerification(ctx context.Context, streamId string, state string) error {
+	endpoint, err := client.endpoint(client.config.VerificationEndpoint, "verification")
+	if err != nil {
+		return err
+	}
+
+	request := struct {
+		StreamId string `json:"stream_id"`
+		State    string `json:"state,omitempty"`
+	}{streamId, state}
+	_, err = client.client.send(ctx, "POST", endpoint, request, http.StatusOK, http.StatusNoContent)
+	return err
+}
+
+// Makes a single poll request to the given poll endpoint, returning the raw
+// SETs. Acknowledgements and SET errors are sent as given in the request.
+// When long polling, ctx bounds how long the request is held open
+func (client *TransmitterClient) Poll(ctx context.Context, pollUrl string, request PollTransmitterRequest) (*PollTransmitterResponse, error) {
+	body, err := client.client.send(ctx, "POST", pollUrl, request, http.StatusOK, http.StatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+
+	var response PollTransmitterResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Sets == nil {
+		response.Sets = map[string]string{}
+	}
+	return &response, nil
+}
+
+// Returns the endpoint, or an error naming it if the transmitter doesn't
+// support it
+func (client *TransmitterClient) endpoint(endpoint string, name string) (string, error) {
+	if endpoint == "" {
+		return "", errors.New("transmitter does not have a " + name + " endpoint")
+	}
+	return endpoint, nil
+}
+
+func withStreamId(endpoint string, streamId string) string {
+	if streamId == "" {
+		return endpoint
+	}
+	return endpoint + "?stream_id=" + url.QueryEscape(streamId)
+}
+
+// Decodes a response holding either a single stream configuration or an
+// array of them
+func decodeStreams(body []byte) ([]StreamConfiguration, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '{' {
+		stream, err := decodeStream(body)
+		if err != nil {
+			return nil, err
+		}
+		return []StreamConfiguration{*stream}, nil
+	}
+
+	var streams []StreamConfiguration
+	err := json.Unmarshal(body, &streams)
+	if err != nil {
+		return nil, err
+	}
+	return streams, nil
+}
+
+func decodeStream(body []byte) (*StreamConfiguration, error) {
+	var stream StreamConfiguration
+	err := json.Unmarshal(body, &stream)
+	if err != nil {
+		return nil, err
+	}
+	return &stream, nil
+}
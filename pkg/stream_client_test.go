@@ -0,0 +1,367 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// A request received by a fakeStreamApi
+type streamApiRequest struct {
+	method        string
+	uri           string
+	body          any
+	authorization string
+}
+
+// A transmitter serving discovery metadata and answering every stream
+// management request with a fixed response, recording the requests
+type fakeStreamApi struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	metadata TransmitterConfig
+	status   int
+	response string
+	requests []streamApiRequest
+}
+
+func newFakeStreamApi(t *testing.T) *fakeStreamApi {
+	api := &fakeStreamApi{status: http.StatusOK}
+	api.server = httptest.NewServer(http.HandlerFunc(api.serveHTTP))
+	base := api.server.URL
+	api.metadata = TransmitterConfig{
+		Issuer:                base,
+		ConfigurationEndpoint: base + "/streams",
+		StatusEndpoint:        base + "/status",
+		AddSubjectEndpoint:    base + "/subjects:add",
+		RemoveSubjectEndpoint: base + "/subjects:remove",
+		VerificationEndpoint:  base + "/verify",
+	}
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+func (api *fakeStreamApi) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if r.URL.Path == TransmitterConfigMetadataPath {
+		json.NewEncoder(w).Encode(api.metadata)
+		return
+	}
+
+	request := streamApiRequest{method: r.Method, uri: r.URL.RequestURI(), authorization: r.Header.Get("Authorization")}
+	data, _ := io.ReadAll(r.Body)
+	if len(data) > 0 {
+		json.Unmarshal(data, &request.body)
+	}
+	api.requests = append(api.requests, request)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(api.status)
+	w.Write([]byte(api.response))
+}
+
+// Returns a client for the fake transmitter, which answers the stream
+// management requests with the given status and body
+func (api *fakeStreamApi) client(t *testing.T, status int, response string) *TransmitterClient {
+	t.Helper()
+	api.mu.Lock()
+	api.status, api.response, api.requests = status, response, nil
+	api.mu.Unlock()
+
+	client, err := NewTransmitterClient(context.Background(), ClientConfig{
+		TransmitterUrl:     api.server.URL,
+		AuthorizationToken: "token",
+		RetryPolicy:        &RetryPolicy{MaxRetries: -1},
+	})
+	if err != nil {
+		t.Fatalf("NewTransmitterClient() error = %v", err)
+	}
+	return client
+}
+
+// Returns the stream management requests received so far
+func (api *fakeStreamApi) received() []streamApiRequest {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return append([]streamApiRequest{}, api.requests...)
+}
+
+// Decodes a JSON value the way the fake transmitter decodes request bodies
+func jsonValue(t *testing.T, data string) any {
+	t.Helper()
+	if data == "" {
+		return nil
+	}
+	var value any
+	err := json.Unmarshal([]byte(data), &value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestTransmitterClientRequests(t *testing.T) {
+	verified := true
+	subject := map[string]interface{}{"format": "email", "email": "user@example.com"}
+	pollStream := &StreamConfiguration{
+		StreamId: "stream-1",
+		Delivery: &SsfDelivery{Method: TransmitterPollRFC, EndpointUrl: "https://tr.example.com/poll"},
+	}
+
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		call     func(ctx context.Context, client *TransmitterClient, base string) (any, error)
+		method   string
+		uri      string
+		body     string
+		want     any
+	}{
+		{
+			name:     "create stream",
+			response: `{"stream_id":"stream-1","delivery":{"method":"urn:ietf:rfc:8936","endpoint_url":"https://tr.example.com/poll"}}`,
+			call: func(ctx context.Context, client *TransmitterClient, base string) (any, error) {
+				return client.CreateStream(ctx, StreamConfiguration{Delivery: &SsfDelivery{Method: TransmitterPollRFC}, EventsRequested: []string{sessionRevokedUri}})
+			},
+			method: "POST",
+			uri:    "/streams",
+			body:   `{"events_requested":["` + sessionRevokedUri + `"],"delivery":{"method":"urn:ietf:rfc:8936"}}`,
+			want:   pollStream,
+		},
+		{
+			name:     "get stream",
+			response: `{"stream_id":"stream-1","delivery":{"method":"urn:ietf:rfc:8936","endpoint_url":"https://tr.example.com/poll"}}`,
+			call: func(ctx context.Context, client *TransmitterClient, base string) (any, error) {
+				return client.GetStream(ctx, "stream 1")
+			},
+			method: "GET",
+			uri:    "/streams?stream_id=stream+1",
+			want:   pollStream,
+		},
+		{
+			name:     "get stream from a list of one",
+			response: `[{"stream_id":"stream-1","delivery":{"method":"urn:ietf:rfc:8936","endpoint_url":"https://tr.example.com/poll"}}]`,
+			call: func(ctx context.Context, client *TransmitterClient, base string) (any, error) {
+				return client.GetStream(ctx, "stream-1")
+			},
+			method: "GET",
+			uri:    "/streams?stream_id=stream-1",
+			want:   pollStream,
+		},
+		{
+			name:     "list streams",
+			response: `[{"stream_id":"stream-1"},{"stream_id":"stream-2"}]`,
+			call: func(ctx context.Context, client *TransmitterClient, base string) (any, error) {
+				return client.ListStreams(ctx)
+			},
+			method: "GET",
+			uri:    "/streams",
+			want:   []StreamConfiguration{{StreamId: "stream-1"}, {StreamId: "stream-2"}},
+		},
+		{
+			name:     "list the single stream",
+			response: `{"stream_id":"stream-1"}`,
+			call: func(ctx context.Context, client *TransmitterClient, base string) (any, error) {
+				return client.ListStreams(ctx)
+			},
+			method: "GET",
+			uri:    "/streams",
+			want:   []StreamConfiguration{{StreamId: "stream-1"}},
+		},
+		{
+			name:     "update stream",
+			response: `{"stream_id":"stream-1","description":"updated"}`,
+			call: func(ctx context.Context, client *TransmitterClient, base string) (any, error) {
+				return client.UpdateStream(ctx, StreamConfiguration{StreamId: "stream-1", Description: "updated"})
+			},
+			method: "PATCH",
+			uri:    "/streams",
+			body:   `{"stream_id":"stream-1","description":"updated"}`,
+			want:   &StreamConfiguration{StreamId: "stream-1", Description: "updated"},
+		},
+		{
+			name:   "delete stream",
+			status: http.StatusNoContent,
+			call: func(ctx context.Context, client *TransmitterClient, base string) (any, error) {
+				return nil, client.DeleteStream(ctx, "stream-1")
+			},
+			method: "DELETE",
+			uri:    "/streams?stream_id=stream-1",
+		},
+		{
+			name:     "get stream status",
+			response: `{"stream_id":"stream-1","status":"paused","reason":"maintenance"}`,
+			call: func(ctx context.Context, client *TransmitterClient, base string) (any, error) {
+				return client.GetStreamStatus(ctx, "stream-1")
+			},
+			method: "GET",
+			uri:    "/status?stream_id=stream-1",
+			want:   &StreamStatusResponse{StreamId: "stream-1", Status: "paused", Reason: "maintenance"},
+		},
+		{
+			name:     "set stream status",
+			response: `{"stream_id":"stream-1","status":"disabled"}`,
+			call: func(ctx context.Context, client *TransmitterClient, base string) (any, error) {
+				return client.SetStreamStatus(ctx, "stream-1", StreamDisabled, "compromised")
+			},
+			method: "POST",
+			uri:    "/status",
+			body:   `{"stream_id":"stream-1","status":"disabled","reason":"compromised"}`,
+			want:   &StreamStatusResponse{StreamId: "stream-1", Status: "disabled"},
+		},
+		{
+			name:   "add subject",
+			status: http.StatusNoContent,
+			call: func(ctx context.Context, client *TransmitterClient, base string) (any, error) {
+				return nil, client.AddSubject(ctx, "stream-1", subject, &verified)
+			},
+			method: "POST",
+			uri:    "/subjects:add",
+			body:   `{"stream_id":"stream-1","subject":{"format":"email","email":"user@example.com"},"verified":true}`,
+		},
+		{
+			name:   "add subject without verified",
+			status: http.StatusNoContent,
+			call: func(ctx context.Context, client *TransmitterClient, base string) (any, error) {
+				return nil, client.AddSubject(ctx, "stream-1", subject, nil)
+			},
+			method: "POST",
+			uri:    "/subjects:add",
+			body:   `{"stream_id":"stream-1","subject":{"format":"email","email":"user@example.com"}}`,
+		},
+		{
+			name:   "remove subject",
+			status: http.StatusNoContent,
+			call: func(ctx context.Context, client *TransmitterClient, base string) (any, error) {
+				return nil, client.RemoveSubject(ctx, "stream-1", subject)
+			},
+			method: "POST",
+			uri:    "/subjects:remove",
+			body:   `{"stream_id":"stream-1","subject":{"format":"email","email":"user@example.com"}}`,
+		},
+		{
+			name:   "request verification",
+			status: http.StatusNoContent,
+			call: func(ctx context.Context, client *TransmitterClient, base string) (any, error) {
+				return nil, client.RequestVerification(ctx, "stream-1", "state-1")
+			},
+			method: "POST",
+			uri:    "/verify",
+			body:   `{"stream_id":"stream-1","state":"state-1"}`,
+		},
+		{
+			name:     "poll",
+			response: `{"sets":{"jti-1":"set-1"},"moreAvailable":true}`,
+			call: func(ctx context.Context, client *TransmitterClient, base string) (any, error) {
+				return client.Poll(ctx, base+"/poll", PollTransmitterRequest{Acknowledgements: []string{"jti-0"}, MaxEvents: 5, ReturnImmediately: true})
+			},
+			method: "POST",
+			uri:    "/poll",
+			body:   `{"ack":["jti-0"],"maxEvents":5,"returnImmediately":true}`,
+			want:   &PollTransmitterResponse{Sets: map[string]string{"jti-1": "set-1"}, MoreAvailable: true},
+		},
+		{
+			name:     "poll with no SETs",
+			response: `{}`,
+			call: func(ctx context.Context, client *TransmitterClient, base string) (any, error) {
+				return client.Poll(ctx, base+"/poll", PollTransmitterRequest{MaxEvents: 5})
+			},
+			method: "POST",
+			uri:    "/poll",
+			body:   `{"ack":null,"maxEvents":5,"returnImmediately":false}`,
+			want:   &PollTransmitterResponse{Sets: map[string]string{}},
+		},
+	}
+	api := newFakeStreamApi(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status := test.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			client := api.client(t, status, test.response)
+
+			got, err := test.call(context.Background(), client, api.server.URL)
+			if err != nil {
+				t.Fatalf("%s error = %v", test.name, err)
+			}
+			if test.want != nil && !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("%s = %+v, want %+v", test.name, got, test.want)
+			}
+
+			requests := api.received()
+			if len(requests) != 1 {
+				t.Fatalf("transmitter got %d requests, want 1", len(requests))
+			}
+			request := requests[0]
+			if request.method != test.method || request.uri != test.uri {
+				t.Fatalf("request = %s %s, want %s %s", request.method, request.uri, test.method, test.uri)
+			}
+			if want := jsonValue(t, test.body); !reflect.DeepEqual(request.body, want) {
+				t.Fatalf("request body = %v, want %v", request.body, want)
+			}
+			if request.authorization != "Bearer token" {
+				t.Fatalf("Authorization = %q, want the bearer token", request.authorization)
+			}
+		})
+	}
+}
+
+func TestTransmitterClientErrors(t *testing.T) {
+	api := newFakeStreamApi(t)
+
+	t.Run("missing config", func(t *testing.T) {
+		_, err := NewTransmitterClient(context.Background(), ClientConfig{TransmitterUrl: api.server.URL})
+		if err == nil {
+			t.Fatal("NewTransmitterClient() accepted a config without a token")
+		}
+	})
+
+	t.Run("update without a stream id", func(t *testing.T) {
+		client := api.client(t, http.StatusOK, `{}`)
+		if _, err := client.UpdateStream(context.Background(), StreamConfiguration{Description: "updated"}); err == nil {
+			t.Fatal("UpdateStream() accepted a stream without an id")
+		}
+		if requests := api.received(); len(requests) != 0 {
+			t.Fatalf("transmitter got %d requests, want none", len(requests))
+		}
+	})
+
+	t.Run("get stream returning several streams", func(t *testing.T) {
+		client := api.client(t, http.StatusOK, `[{"stream_id":"stream-1"},{"stream_id":"stream-2"}]`)
+		if _, err := client.GetStream(context.Background(), ""); err == nil {
+			t.Fatal("GetStream() = nil error, want an error asking for a stream id")
+		}
+	})
+
+	t.Run("transmitter error", func(t *testing.T) {
+		client := api.client(t, http.StatusNotFound, `{"error":"not_found","error_description":"no such stream"}`)
+		_, err := client.GetStreamStatus(context.Background(), "stream-1")
+		var transmitterErr *TransmitterError
+		if !errors.As(err, &transmitterErr) || transmitterErr.StatusCode != http.StatusNotFound || transmitterErr.ErrorCode != "not_found" {
+			t.Fatalf("GetStreamStatus() error = %v, want a 404 *TransmitterError", err)
+		}
+	})
+
+	t.Run("unsupported endpoint", func(t *testing.T) {
+		api.mu.Lock()
+		api.metadata.VerificationEndpoint = ""
+		api.mu.Unlock()
+		client := api.client(t, http.StatusNoContent, "")
+		if err := client.RequestVerification(context.Background(), "stream-1", ""); err == nil {
+			t.Fatal("RequestVerification() = nil error, want the missing endpoint reported")
+		}
+		if requests := api.received(); len(requests) != 0 {
+			t.Fatalf("transmitter got %d requests, want none", len(requests))
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+// The names of the spans recorded by the receiver
+const (
+	// A poll cycle, parent of the poll, verify, parse and ack spans made
+	// while draining the transmitter's queue
+	SpanPollCycle = "ssf.poll_cycle"
+
+	// A single poll request to the transmitter
+	SpanPoll = "ssf.poll"
+
+	// An acknowledgement request to the transmitter
+	SpanAck = "ssf.ack"
+
+	// Decoding and verifying a SET
+	SpanVerify = "ssf.verify"
+
+	// Extracting the events from a verified SET
+	SpanParse = "ssf.parse"
+
+	// A single handler invocation
+	SpanHandle = "ssf.handle"
+
+	// A SET pushed by the transmitter
+	SpanPush = "ssf.push"
+)
+
+// The attribute keys set on the receiver's spans
+const (
+	AttributeJTI           = "ssf.jti"
+	AttributeTxn           = "ssf.txn"
+	AttributeEventType     = "ssf.event_type"
+	AttributeStreamId      = "ssf.stream_id"
+	AttributeTransmitter   = "ssf.transmitter"
+	AttributeEventCount    = "ssf.event_count"
+	AttributeAckCount      = "ssf.ack_count"
+	AttributeMoreAvailable = "ssf.more_available"
+)
+
+// Records the receiver's work as trace spans. The receiver doesn't depend
+// on any tracing library, the ssfotel package provides a Tracer backed by
+// OpenTelemetry.
+//
+// Implementations must be safe for concurrent use
+type Tracer interface {
+	// Starts a span as a child of the span in the context, if any, and
+	// returns a context holding the new span
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// A span started by a Tracer
+type Span interface {
+	// Sets an attribute on the span. The value is a string, an int or a
+	// bool
+	SetAttribute(key string, value interface{})
+
+	// Records the error on the span and marks it as failed
+	RecordError(err error)
+
+	// Ends the span
+	End()
+}
+
+// A Tracer that records nothing
+type nopTracer struct{}
+
+type nopSpan struct{}
+
+func (nopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+func (nopSpan) SetAttribute(string, interface{}) {}
+func (nopSpan) RecordError(error)                {}
+func (nopSpan) End()                             {}
+
+// Starts a span with the receiver's stream attributes
+func (receiver *SsfReceiverImplementation) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	ctx, span := receiver.tracer.Start(ctx, name)
+	span.SetAttribute(AttributeTransmitter, receiver.transmitterUrl)
+	span.SetAttribute(AttributeStreamId, receiver.streamId)
+	return ctx, span
+}
+
+// Ends the span, recording the error first if there is one
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// Sets the attributes identifying a SET on the span
+func setSpanEventInfo(span Span, info EventInfo) {
+	if info.JTI != "" {
+		span.SetAttribute(AttributeJTI, info.JTI)
+	}
+	if info.Txn != "" {
+		span.SetAttribute(AttributeTxn, info.Txn)
+	}
+}
+
+// Parses a SET received with the given JTI, recording the verification and
+// parsing as spans. The JTI is empty for pushed SETs, it is then read from
+// the SET.
+//
+// Errors wrapping ErrSigningKeysUnavailable are transient: the SET isn't
+// counted as rejected, and callers must leave it unacknowledged
+func (receiver *SsfReceiverImplementation) parseSet(ctx context.Context, jti string, set string, method string) (EventInfo, []events.SsfEvent, error) {
+	info := EventInfo{JTI: jti, DeliveryMethod: method}
+
+	_, span := receiver.startSpan(ctx, SpanVerify)
+	setSpanEventInfo(span, info)
+	claims, err := receiver.verifySet(ctx, jti, set)
+	if err == nil {
+		info = eventInfoFromClaims(claims, jti, method)
+		setSpanEventInfo(span, info)
+	}
+	endSpan(span, err)
+	if errors.Is(err, ErrSigningKeysUnavailable) {
+		receiver.logger.Warn("postponed SET verification",
+			slog.String("jti", jti),
+			slog.Any("error", err))
+		return info, nil, err
+	}
+	if err != nil {
+		receiver.rejectSet(jti, err)
+		return info, nil, err
+	}
+
+	_, span = receiver.startSpan(ctx, SpanParse)
+	setSpanEventInfo(span, info)
+	ssfEvents, err := eventsFromClaims(claims)
+	span.SetAttribute(AttributeEventCount, len(ssfEvents))
+	endSpan(span, err)
+	if err != nil {
+		receiver.rejectSet(info.JTI, err)
+		return info, nil, err
+	}
+
+	receiver.recordReceivedEvents(info.JTI, method, claims, ssfEvents)
+	return info, ssfEvents, nil
+}
+
+// Returns a middleware recording a span for every handler invocation
+func (receiver *SsfReceiverImplementation) tracingMiddleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, event events.SsfEvent) error {
+			ctx, span := receiver.startSpan(ctx, SpanHandle)
+			span.SetAttribute(AttributeEventType, event.GetEventUri())
+			if info, ok := EventInfoFromContext(ctx); ok {
+				setSpanEventInfo(span, info)
+			}
+
+			err := next(ctx, event)
+			endSpan(span, err)
+			return err
+		}
+	}
+}
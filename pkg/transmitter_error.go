@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Represents an unsuccessful response returned by the transmitter.
+//
+// Every transmitter call made by the receiver returns a *TransmitterError
+// when the transmitter answers with an unexpected status code, so callers
+// can use errors.As to branch on the status, e.g. to refresh the
+// authorization token on a 401 or to back off on a 429
+type TransmitterError struct {
+	// StatusCode defines the HTTP status code returned by the transmitter
+	StatusCode int
+
+	// Method defines the HTTP method of the failed request
+	Method string
+
+	// Path defines the path of the failed request
+	Path string
+
+	// ErrorCode defines the error code decoded from the response body,
+	// read from the "err" member used by RFC 8936 or the "error" member
+	// used by OAuth style error responses
+	ErrorCode string
+
+	// Description defines the human readable error description decoded
+	// from the response body, if any
+	Description string
+
+	// Body defines the raw response body
+	Body []byte
+
+	// RetryAfter defines how long the transmitter asked the receiver to
+	// wait before retrying, parsed from the Retry-After header. Zero if
+	// the transmitter didn't send one
+	RetryAfter time.Duration
+}
+
+func (e *TransmitterError) Error() string {
+	message := fmt.Sprintf("transmitter returned status %d for %s %s", e.StatusCode, e.Method, e.Path)
+	switch {
+	case e.ErrorCode != "" && e.Description != "":
+		return message + ": " + e.ErrorCode + ": " + e.Description
+	case e.ErrorCode != "":
+		return message + ": " + e.ErrorCode
+	case e.Description != "":
+		return message + ": " + e.Description
+	case len(e.Body) > 0:
+		return message + ": " + strings.TrimSpace(string(e.Body))
+	default:
+		return message
+	}
+}
+
+// Returns the status code of the TransmitterError wrapped in err, or 0 if
+// err does not wrap a TransmitterError
+func TransmitterStatusCode(err error) int {
+	var transmitterErr *TransmitterError
+	if errors.As(err, &transmitterErr) {
+		return transmitterErr.StatusCode
+	}
+	return 0
+}
+
+// Builds a TransmitterError from the transmitter's response, decoding the
+// error body when it is JSON
+func newTransmitterError(response *http.Response, body []byte) *TransmitterError {
+	transmitterErr := &TransmitterError{
+		StatusCode: response.StatusCode,
+		Body:       body,
+		RetryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+	}
+	if response.Request != nil {
+		transmitterErr.Method = response.Request.Method
+		transmitterErr.Path = response.Request.URL.Path
+	}
+
+	var errorBody struct {
+		Err              string `json:"err"`
+		Error            string `json:"error"`
+		Description      string `json:"description"`
+		ErrorDescription string `json:"error_description"`
+		Message          string `json:"message"`
+	}
+	if json.Unmarshal(body, &errorBody) == nil {
+		transmitterErr.ErrorCode = firstNonEmpty(errorBody.Err, errorBody.Error)
+		transmitterErr.Description = firstNonEmpty(errorBody.Description, errorBody.ErrorDescription, errorBody.Message)
+	}
+
+	return transmitterErr
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTransmitterErrorFromResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		header http.Header
+		body   string
+		want   TransmitterError
+	}{
+		{
+			name:   "RFC 8936 error",
+			status: http.StatusBadRequest,
+			body:   `{"err":"invalid_request","description":"unknown jti"}`,
+			want:   TransmitterError{StatusCode: http.StatusBadRequest, ErrorCode: "invalid_request", Description: "unknown jti"},
+		},
+		{
+			name:   "OAuth error",
+			status: http.StatusUnauthorized,
+			body:   `{"error":"invalid_token","error_description":"token expired"}`,
+			want:   TransmitterError{StatusCode: http.StatusUnauthorized, ErrorCode: "invalid_token", Description: "token expired"},
+		},
+		{
+			name:   "throttled with Retry-After",
+			status: http.StatusTooManyRequests,
+			header: http.Header{"Retry-After": {"30"}},
+			body:   `{"message":"slow down"}`,
+			want:   TransmitterError{StatusCode: http.StatusTooManyRequests, Description: "slow down", RetryAfter: 30 * time.Second},
+		},
+		{
+			name:   "plain text body",
+			status: http.StatusInternalServerError,
+			body:   "internal error",
+			want:   TransmitterError{StatusCode: http.StatusInternalServerError},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for name, values := range test.header {
+					w.Header()[name] = values
+				}
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+
+			client := newTransmitterClient(ReceiverConfig{AuthorizationToken: "token"})
+			_, err := client.send(context.Background(), "POST", server.URL+"/streams", map[string]string{}, http.StatusOK)
+
+			var transmitterErr *TransmitterError
+			if !errors.As(err, &transmitterErr) {
+				t.Fatalf("send() error = %v, want a *TransmitterError", err)
+			}
+			if transmitterErr.StatusCode != test.want.StatusCode || transmitterErr.ErrorCode != test.want.ErrorCode ||
+				transmitterErr.Description != test.want.Description || transmitterErr.RetryAfter != test.want.RetryAfter {
+				t.Fatalf("send() error = %+v, want %+v", transmitterErr, test.want)
+			}
+			if transmitterErr.Method != "POST" || transmitterErr.Path != "/streams" {
+				t.Fatalf("send() error is for %s %s, want POST /streams", transmitterErr.Method, transmitterErr.Path)
+			}
+			if string(transmitterErr.Body) != test.body {
+				t.Fatalf("send() error body = %q, want %q", transmitterErr.Body, test.body)
+			}
+			if got := TransmitterStatusCode(err); got != test.status {
+				t.Fatalf("TransmitterStatusCode() = %d, want %d", got, test.status)
+			}
+		})
+	}
+}
+
+func TestTransmitterErrorFromReceiverCalls(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	receiver := newTestReceiver(t, transmitter, ReceiverConfig{RetryPolicy: &RetryPolicy{MaxRetries: -1}})
+	transmitter.mu.Lock()
+	transmitter.statusCode = http.StatusServiceUnavailable
+	transmitter.mu.Unlock()
+
+	_, err := receiver.GetStreamStatus()
+	var transmitterErr *TransmitterError
+	if !errors.As(err, &transmitterErr) || transmitterErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("GetStreamStatus() error = %v, want a 503 *TransmitterError", err)
+	}
+}
@@ -0,0 +1,120 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Makes the authorized requests of a receiver to its transmitter, applying
+// the receiver's retry policy and rate limit
+type transmitterClient struct {
+	// token defines the Auth Token used to authorize the receiver with
+	// the transmitter
+	token string
+
+	// retryPolicy defines how idempotent requests are retried
+	retryPolicy RetryPolicy
+
+	// limiter limits the rate of requests, nil if unlimited
+	limiter *rateLimiter
+
+	// requestTimeout bounds each request made without a deadline, 0 uses
+	// DefaultRequestTimeout
+	requestTimeout time.Duration
+}
+
+func newTransmitterClient(cfg ReceiverConfig) *transmitterClient {
+	client := &transmitterClient{
+		token:          cfg.AuthorizationToken,
+		retryPolicy:    DefaultRetryPolicy,
+		limiter:        newRateLimiter(cfg.RateLimit),
+		requestTimeout: cfg.RequestTimeout,
+	}
+	if cfg.RetryPolicy != nil {
+		client.retryPolicy = cfg.RetryPolicy.withDefaults()
+	}
+	return client
+}
+
+// Sends an authorized request to the transmitter and returns the response
+// body.
+//
+// payload, if not nil, is sent as the JSON request body. Responses whose
+// status code is not one of expectedStatus are returned as a
+// *TransmitterError. GET requests are idempotent and are retried according
+// to the client's retry policy
+func (client *transmitterClient) send(ctx context.Context, method string, url string, payload interface{}, expectedStatus ...int) ([]byte, error) {
+	var encoded []byte
+	if payload != nil {
+		var err error
+		encoded, err = json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	maxRetries := 0
+	if method == "GET" && client.retryPolicy.MaxRetries > 0 {
+		maxRetries = client.retryPolicy.MaxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		body, err := client.sendOnce(ctx, method, url, encoded, expectedStatus)
+		if err == nil || attempt >= maxRetries || !isRetryable(err) {
+			return body, err
+		}
+
+		err = sleepContext(ctx, client.retryPolicy.Backoff(attempt+1, err))
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (client *transmitterClient) sendOnce(ctx context.Context, method string, url string, encoded []byte, expectedStatus []int) ([]byte, error) {
+	err := client.limiter.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withRequestTimeout(ctx, client.requestTimeout)
+	defer cancel()
+
+	var requestBody io.Reader
+	if encoded != nil {
+		requestBody = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+client.token)
+	if encoded != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, status := range expectedStatus {
+		if response.StatusCode == status {
+			return body, nil
+		}
+	}
+
+	return nil, newTransmitterError(response, body)
+}
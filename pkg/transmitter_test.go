@@ -0,0 +1,236 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	events "github.com/sgnl-ai/caep.dev-receiver/pkg/ssf_events"
+)
+
+const sessionRevokedUri = "https://schemas.openid.net/secevent/caep/event-type/session-revoked"
+
+// A fake SSF transmitter serving discovery, stream management and RFC 8936
+// polling from a queue of SETs
+type fakeTransmitter struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	queue     map[string]string
+	acks      []string
+	setErrors map[string]SetError
+	jwks      []byte
+
+	// stream defines the stream returned by the configuration endpoint,
+	// requests record the stream management requests by method, the
+	// status requests under "status" and the poll requests under "poll"
+	stream   StreamConfiguration
+	requests map[string]int
+
+	// statusCode, when set, is returned by the status endpoint instead of
+	// the stream status
+	statusCode int
+
+	// createGate, when set before the first request, holds the stream
+	// creation requests until it is closed, heldCreates counts them
+	createGate  chan struct{}
+	heldCreates int
+}
+
+func newFakeTransmitter(t *testing.T) *fakeTransmitter {
+	transmitter := &fakeTransmitter{queue: map[string]string{}, setErrors: map[string]SetError{}, requests: map[string]int{}}
+	transmitter.server = httptest.NewServer(http.HandlerFunc(transmitter.serveHTTP))
+	transmitter.stream = StreamConfiguration{
+		StreamId:        "stream-1",
+		Delivery:        &SsfDelivery{Method: TransmitterPollRFC, EndpointUrl: transmitter.url() + "/poll"},
+		EventsRequested: []string{sessionRevokedUri},
+		EventsDelivered: []string{sessionRevokedUri},
+	}
+	t.Cleanup(transmitter.server.Close)
+	return transmitter
+}
+
+func (transmitter *fakeTransmitter) url() string {
+	return transmitter.server.URL
+}
+
+// Returns how many stream management requests were made with the method
+func (transmitter *fakeTransmitter) streamRequests(method string) int {
+	transmitter.mu.Lock()
+	defer transmitter.mu.Unlock()
+	return transmitter.requests[method]
+}
+
+// Returns how many stream creation requests were held by createGate
+func (transmitter *fakeTransmitter) waitingCreates() int {
+	transmitter.mu.Lock()
+	defer transmitter.mu.Unlock()
+	return transmitter.heldCreates
+}
+
+// Queues a SET to be returned by the next poll request
+func (transmitter *fakeTransmitter) add(jti string, set string) {
+	transmitter.mu.Lock()
+	defer transmitter.mu.Unlock()
+	transmitter.queue[jti] = set
+}
+
+// Returns the JTIs acknowledged so far
+func (transmitter *fakeTransmitter) acknowledged() []string {
+	transmitter.mu.Lock()
+	defer transmitter.mu.Unlock()
+	return append([]string{}, transmitter.acks...)
+}
+
+// Returns the SET errors reported so far
+func (transmitter *fakeTransmitter) rejected() map[string]SetError {
+	transmitter.mu.Lock()
+	defer transmitter.mu.Unlock()
+	rejected := map[string]SetError{}
+	for jti, setErr := range transmitter.setErrors {
+		rejected[jti] = setErr
+	}
+	return rejected
+}
+
+func (transmitter *fakeTransmitter) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	base := transmitter.url()
+	body, _ := io.ReadAll(r.Body)
+	if transmitter.createGate != nil && r.URL.Path == "/streams" && r.Method == http.MethodPost {
+		transmitter.mu.Lock()
+		transmitter.heldCreates++
+		transmitter.mu.Unlock()
+		<-transmitter.createGate
+	}
+
+	transmitter.mu.Lock()
+	defer transmitter.mu.Unlock()
+	switch r.URL.Path {
+	case TransmitterConfigMetadataPath:
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                 base,
+			"jwks_uri":               base + "/jwks",
+			"configuration_endpoint": base + "/streams",
+			"status_endpoint":        base + "/status",
+		})
+	case "/jwks":
+		if transmitter.jwks == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(transmitter.jwks)
+	case "/streams":
+		transmitter.requests[r.Method]++
+		switch r.Method {
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case http.MethodPost, http.MethodPatch:
+			var request StreamConfiguration
+			json.Unmarshal(body, &request)
+			if request.Delivery != nil {
+				transmitter.stream.Delivery = request.Delivery
+			}
+			if request.EventsRequested != nil {
+				transmitter.stream.EventsRequested = request.EventsRequested
+			}
+			if r.Method == http.MethodPost {
+				transmitter.stream.StreamId = fmt.Sprintf("stream-%d", transmitter.requests[r.Method])
+				w.WriteHeader(http.StatusCreated)
+			}
+		}
+		json.NewEncoder(w).Encode(transmitter.stream)
+	case "/status":
+		transmitter.requests["status"]++
+		if transmitter.statusCode != 0 {
+			w.WriteHeader(transmitter.statusCode)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"stream_id": transmitter.stream.StreamId, "status": "enabled"})
+	case "/poll":
+		transmitter.requests["poll"]++
+		var request PollTransmitterRequest
+		json.Unmarshal(body, &request)
+		for _, jti := range request.Acknowledgements {
+			transmitter.acks = append(transmitter.acks, jti)
+			delete(transmitter.queue, jti)
+		}
+		for jti, setErr := range request.SetErrors {
+			transmitter.setErrors[jti] = setErr
+			delete(transmitter.queue, jti)
+		}
+		sets := map[string]string{}
+		if request.MaxEvents > 0 {
+			for jti, set := range transmitter.queue {
+				sets[jti] = set
+			}
+		}
+		json.NewEncoder(w).Encode(PollTransmitterResponse{Sets: sets})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Configures a receiver polling the fake transmitter. The receiver is shut
+// down when the test ends
+func newTestReceiver(t *testing.T, transmitter *fakeTransmitter, cfg ReceiverConfig) *SsfReceiverImplementation {
+	t.Helper()
+	cfg.TransmitterUrl = transmitter.url()
+	cfg.TransmitterPollUrl = transmitter.url() + "/poll"
+	cfg.AuthorizationToken = "token"
+	if cfg.EventsRequested == nil {
+		cfg.EventsRequested = []events.EventType{events.SessionRevoked}
+	}
+	if cfg.MetadataRefreshInterval == 0 {
+		cfg.MetadataRefreshInterval = -1
+	}
+
+	receiver, err := ConfigureSsfReceiver(cfg)
+	if err != nil {
+		t.Fatalf("ConfigureSsfReceiver() error = %v", err)
+	}
+	implementation := receiver.(*SsfReceiverImplementation)
+	t.Cleanup(func() { implementation.stop(context.Background()) })
+	return implementation
+}
+
+// Returns an unsigned session revoked SET with the given claims merged in
+func testSet(t *testing.T, jti string, extra jwt.MapClaims) string {
+	t.Helper()
+	return signTestSet(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, jti, extra, "")
+}
+
+func signTestSet(t *testing.T, method jwt.SigningMethod, key interface{}, jti string, extra jwt.MapClaims, kid string) string {
+	t.Helper()
+	now := time.Now().Unix()
+	claims := jwt.MapClaims{
+		"jti": jti,
+		"iat": now,
+		"events": map[string]any{
+			sessionRevokedUri: map[string]any{
+				"subject":         map[string]any{"format": "email", "email": "user@example.com"},
+				"event_timestamp": now,
+			},
+		},
+	}
+	for name, value := range extra {
+		claims[name] = value
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	set, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("signing SET: %v", err)
+	}
+	return set
+}
@@ -0,0 +1,160 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The reasons a SET fails verification, as reported to Metrics.SetRejected
+const (
+	// The SET's signature is missing, invalid, or made with an unknown key
+	SetRejectedInvalidSignature = "invalid_signature"
+
+	// The SET was issued by another issuer than the transmitter
+	SetRejectedInvalidIssuer = "invalid_issuer"
+
+	// The SET's aud claim doesn't contain the receiver's audience
+	SetRejectedInvalidAudience = "invalid_audience"
+)
+
+// The signature algorithms accepted for SETs
+var setSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// The result of decoding and verifying the most recent SET
+type VerificationResult struct {
+	// Time defines when the SET was verified
+	Time time.Time `json:"time"`
+
+	// JTI defines the JTI of the SET, if it could be read
+	JTI string `json:"jti,omitempty"`
+
+	// Valid reports whether the SET was accepted
+	Valid bool `json:"valid"`
+
+	// Error defines why the SET was rejected
+	Error string `json:"error,omitempty"`
+}
+
+// Returns the RFC 8935 error reported to the transmitter for a SET that
+// failed parsing or verification: "invalid_key" for a bad signature,
+// "invalid_issuer" for another issuer, "invalid_audience" for another
+// audience, and "invalid_request" otherwise
+func setErrorFor(err error) SetError {
+	code := "invalid_request"
+	switch rejectionReason(err) {
+	case SetRejectedInvalidSignature:
+		code = "invalid_key"
+	case SetRejectedInvalidIssuer:
+		code = "invalid_issuer"
+	case SetRejectedInvalidAudience:
+		code = "invalid_audience"
+	}
+	return SetError{Err: code, Description: err.Error()}
+}
+
+// Decodes a SET without verifying its signature, and returns its claims
+func decodeSet(set string) (map[string]interface{}, error) {
+	token, err := jwt.Parse(set, func(token *jwt.Token) (interface{}, error) { return jwt.UnsafeAllowNoneSignatureType, nil })
+	if err != nil {
+		return nil, &setParseError{reason: SetRejectedMalformed, err: err}
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, &setParseError{reason: SetRejectedMalformed, err: errors.New("Can't get JWT Claims")}
+	}
+	return claims, nil
+}
+
+// Decodes a SET and verifies its signature with the given keys, returning
+// its claims. The key is picked by the kid header of the SET.
+//
+// Errors of keyFunc wrapping ErrSigningKeysUnavailable are returned as is,
+// any other failure is a *setParseError
+func verifySetSignature(set string, keyFunc func(kid string) (interface{}, error)) (map[string]interface{}, error) {
+	token, err := jwt.Parse(set, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return keyFunc(kid)
+	}, jwt.WithValidMethods(setSigningMethods))
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenMalformed) {
+			return nil, &setParseError{reason: SetRejectedMalformed, err: err}
+		}
+		if errors.Is(err, ErrSigningKeysUnavailable) {
+			// The signature wasn't checked, the SET is verified again
+			// when it is redelivered
+			return nil, err
+		}
+		return nil, &setParseError{reason: SetRejectedInvalidSignature, err: err}
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, &setParseError{reason: SetRejectedMalformed, err: errors.New("Can't get JWT Claims")}
+	}
+	return claims, nil
+}
+
+// Decodes a SET and returns its claims. When the receiver verifies
+// signatures, the SET must be signed with one of the transmitter's keys
+// and issued by the transmitter, and its aud claim, when present, must
+// contain the receiver's audience. The result is recorded for Health
+func (receiver *SsfReceiverImplementation) verifySet(ctx context.Context, jti string, set string) (map[string]interface{}, error) {
+	var claims map[string]interface{}
+	var err error
+	if receiver.jwks == nil {
+		claims, err = decodeSet(set)
+	} else {
+		claims, err = verifySetSignature(set, func(kid string) (interface{}, error) {
+			return receiver.jwks.Key(ctx, kid)
+		})
+		if err == nil {
+			issuer, _ := claims["iss"].(string)
+			if expected := receiver.GetTransmitterConfig().Issuer; !issuersMatch(issuer, expected) {
+				err = &setParseError{reason: SetRejectedInvalidIssuer, err: errors.New("SET issuer " + issuer + " does not match the transmitter " + expected)}
+			}
+		}
+		if err == nil {
+			err = checkAudience(claims, receiver.audience)
+		}
+	}
+
+	result := VerificationResult{Time: time.Now(), JTI: jti, Valid: err == nil}
+	if result.JTI == "" && claims != nil {
+		result.JTI, _ = claims["jti"].(string)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	receiver.mu.Lock()
+	receiver.lastVerification = &result
+	receiver.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Checks that the aud claim of a SET contains the expected audience. SETs
+// without an aud claim are accepted, since RFC 8417 makes it optional, and
+// nothing is checked when the receiver has no audience
+func checkAudience(claims map[string]interface{}, expected string) error {
+	if expected == "" || claims["aud"] == nil {
+		return nil
+	}
+	audience, err := jwt.MapClaims(claims).GetAudience()
+	if err != nil {
+		return &setParseError{reason: SetRejectedMalformed, err: err}
+	}
+	for _, aud := range audience {
+		if aud == expected {
+			return nil
+		}
+	}
+	return &setParseError{reason: SetRejectedInvalidAudience, err: fmt.Errorf("SET audience %v does not contain the receiver %s", []string(audience), expected)}
+}
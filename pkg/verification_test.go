@@ -0,0 +1,315 @@
+package pkg
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestSigningKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// Returns a JWKS publishing the public keys under the given key ids
+func testJwks(t *testing.T, keys map[string]*ecdsa.PrivateKey) []byte {
+	t.Helper()
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, key := range keys {
+		jwks.Keys = append(jwks.Keys, map[string]string{
+			"kty": "EC",
+			"kid": kid,
+			"use": "sig",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		})
+	}
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestVerifySetSignature(t *testing.T) {
+	transmitterKey := newTestSigningKey(t)
+	otherKey := newTestSigningKey(t)
+	keySet, err := ParseJwks(testJwks(t, map[string]*ecdsa.PrivateKey{"key-1": transmitterKey}))
+	if err != nil {
+		t.Fatalf("ParseJwks() error = %v", err)
+	}
+	keyFunc := func(kid string) (interface{}, error) {
+		key, found := keySet.Key(kid)
+		if !found {
+			return nil, fmt.Errorf("no signing key with kid %q", kid)
+		}
+		return key, nil
+	}
+
+	tests := []struct {
+		name       string
+		set        string
+		wantReason string
+	}{
+		{"signed with the transmitter's key", signTestSet(t, jwt.SigningMethodES256, transmitterKey, "jti-1", nil, "key-1"), ""},
+		{"signed with another key", signTestSet(t, jwt.SigningMethodES256, otherKey, "jti-1", nil, "key-1"), SetRejectedInvalidSignature},
+		{"signed with an unknown key", signTestSet(t, jwt.SigningMethodES256, otherKey, "jti-1", nil, "key-2"), SetRejectedInvalidSignature},
+		{"unsigned", testSet(t, "jti-1", nil), SetRejectedInvalidSignature},
+		{"malformed", "not a SET", SetRejectedMalformed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := verifySetSignature(test.set, keyFunc)
+			if test.wantReason == "" {
+				if err != nil || claims["jti"] != "jti-1" {
+					t.Fatalf("verifySetSignature() = %v, %v, want the SET's claims", claims, err)
+				}
+				return
+			}
+			if reason := rejectionReason(err); err == nil || reason != test.wantReason {
+				t.Fatalf("verifySetSignature() error = %v (%s), want %s", err, reason, test.wantReason)
+			}
+		})
+	}
+}
+
+func TestJwksCacheReportsUnavailableKeys(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	key := newTestSigningKey(t)
+	transmitter.mu.Lock()
+	transmitter.jwks = testJwks(t, map[string]*ecdsa.PrivateKey{"key-1": key})
+	transmitter.mu.Unlock()
+
+	cache := NewJwksCache(transmitter.url()+"/jwks", 0)
+	ctx := context.Background()
+	if err := cache.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if _, err := cache.Key(ctx, "key-1"); err != nil {
+		t.Fatalf("Key() error = %v", err)
+	}
+	if _, err := cache.Key(ctx, "key-2"); err == nil || errors.Is(err, ErrSigningKeysUnavailable) {
+		t.Fatalf("Key() of an unknown kid error = %v, want a permanent error", err)
+	}
+
+	// Once fetching fails, cached keys are still served but unknown key ids
+	// may be keys that couldn't be fetched
+	transmitter.mu.Lock()
+	transmitter.jwks = nil
+	transmitter.mu.Unlock()
+	if err := cache.Refresh(ctx); err == nil {
+		t.Fatal("Refresh() succeeded, want an error")
+	}
+	if _, err := cache.Key(ctx, "key-1"); err != nil {
+		t.Fatalf("Key() error = %v, want the cached key", err)
+	}
+	if _, err := cache.Key(ctx, "key-2"); !errors.Is(err, ErrSigningKeysUnavailable) {
+		t.Fatalf("Key() of an unknown kid error = %v, want ErrSigningKeysUnavailable", err)
+	}
+}
+
+func TestPollLeavesSetsUnacknowledgedWhileKeysAreUnavailable(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	key := newTestSigningKey(t)
+	receiver := newTestReceiver(t, transmitter, ReceiverConfig{VerifySignatures: true})
+	transmitter.add("jti-1", signTestSet(t, jwt.SigningMethodES256, key, "jti-1", jwt.MapClaims{"iss": transmitter.url()}, "key-1"))
+
+	response, err := receiver.Poll()
+	if err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	if len(response.Events) != 0 {
+		t.Fatalf("Poll() returned %d events, want none", len(response.Events))
+	}
+	receiver.Poll()
+	if acks, rejected := transmitter.acknowledged(), transmitter.rejected(); len(acks) != 0 || len(rejected) != 0 {
+		t.Fatalf("acknowledged %v and rejected %v, want the SET left for redelivery", acks, rejected)
+	}
+
+	transmitter.mu.Lock()
+	transmitter.jwks = testJwks(t, map[string]*ecdsa.PrivateKey{"key-1": key})
+	transmitter.mu.Unlock()
+	if err := receiver.jwks.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	response, err = receiver.Poll()
+	if err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	if len(response.Events) != 1 {
+		t.Fatalf("Poll() returned %d events, want 1", len(response.Events))
+	}
+	if acks := transmitter.acknowledged(); len(acks) != 1 || acks[0] != "jti-1" {
+		t.Fatalf("acknowledged %v, want [jti-1]", acks)
+	}
+}
+
+func TestParseJwkRsaExponent(t *testing.T) {
+	modulus := base64.RawURLEncoding.EncodeToString(make([]byte, 256))
+	tests := []struct {
+		name     string
+		exponent []byte
+		wantErr  bool
+	}{
+		{"65537", []byte{0x01, 0x00, 0x01}, false},
+		{"3", []byte{0x03}, false},
+		{"1", []byte{0x01}, true},
+		{"even", []byte{0x01, 0x00, 0x00}, true},
+		{"larger than 32 bits", []byte{0x01, 0x00, 0x00, 0x00, 0x01}, true},
+		{"larger than 64 bits", []byte{0x01, 0, 0, 0, 0, 0, 0, 0, 0x01}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw := fmt.Sprintf(`{"kty":"RSA","kid":"key-1","n":%q,"e":%q}`, modulus, base64.RawURLEncoding.EncodeToString(test.exponent))
+			_, key, err := parseJwk(json.RawMessage(raw))
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("parseJwk() = %v, want an error", key)
+				}
+				return
+			}
+			if err != nil || key == nil {
+				t.Fatalf("parseJwk() = %v, %v, want a key", key, err)
+			}
+		})
+	}
+}
+
+func TestPollReportsVerificationFailuresWithRfcErrorCodes(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	key := newTestSigningKey(t)
+	transmitter.jwks = testJwks(t, map[string]*ecdsa.PrivateKey{"key-1": key})
+	receiver := newTestReceiver(t, transmitter, ReceiverConfig{VerifySignatures: true})
+
+	issuer := jwt.MapClaims{"iss": transmitter.url()}
+	transmitter.add("forged", signTestSet(t, jwt.SigningMethodES256, newTestSigningKey(t), "forged", issuer, "key-1"))
+	transmitter.add("unknown-key", signTestSet(t, jwt.SigningMethodES256, key, "unknown-key", issuer, "key-2"))
+	transmitter.add("other-issuer", signTestSet(t, jwt.SigningMethodES256, key, "other-issuer", jwt.MapClaims{"iss": "https://other.example.com"}, "key-1"))
+	transmitter.add("malformed", "not-a-set")
+
+	for i := 0; i < 2; i++ {
+		if _, err := receiver.Poll(); err != nil {
+			t.Fatalf("Poll() error = %v", err)
+		}
+	}
+
+	want := map[string]string{
+		"forged":       "invalid_key",
+		"unknown-key":  "invalid_key",
+		"other-issuer": "invalid_issuer",
+		"malformed":    "invalid_request",
+	}
+	rejected := transmitter.rejected()
+	for jti, code := range want {
+		if setErr, found := rejected[jti]; !found || setErr.Err != code {
+			t.Fatalf("SET %s rejected with %+v, want %s", jti, setErr, code)
+		}
+	}
+}
+
+func TestPollRejectsSetsForAnotherAudience(t *testing.T) {
+	transmitter := newFakeTransmitter(t)
+	key := newTestSigningKey(t)
+	transmitter.jwks = testJwks(t, map[string]*ecdsa.PrivateKey{"key-1": key})
+	receiver := newTestReceiver(t, transmitter, ReceiverConfig{VerifySignatures: true, Audience: "https://receiver.example.com"})
+
+	signed := func(jti string, aud any) string {
+		claims := jwt.MapClaims{"iss": transmitter.url()}
+		if aud != nil {
+			claims["aud"] = aud
+		}
+		return signTestSet(t, jwt.SigningMethodES256, key, jti, claims, "key-1")
+	}
+	transmitter.add("audience", signed("audience", "https://receiver.example.com"))
+	transmitter.add("audiences", signed("audiences", []string{"https://other.example.com", "https://receiver.example.com"}))
+	transmitter.add("no-audience", signed("no-audience", nil))
+	transmitter.add("other-audience", signed("other-audience", "https://other.example.com"))
+	transmitter.add("other-audiences", signed("other-audiences", []string{"https://other.example.com"}))
+
+	for i := 0; i < 2; i++ {
+		if _, err := receiver.Poll(); err != nil {
+			t.Fatalf("Poll() error = %v", err)
+		}
+	}
+
+	acked := map[string]bool{}
+	for _, jti := range transmitter.acknowledged() {
+		acked[jti] = true
+	}
+	for _, jti := range []string{"audience", "audiences", "no-audience"} {
+		if !acked[jti] {
+			t.Fatalf("SET %s wasn't acknowledged, acknowledged %v", jti, transmitter.acknowledged())
+		}
+	}
+	rejected := transmitter.rejected()
+	for _, jti := range []string{"other-audience", "other-audiences"} {
+		if setErr, found := rejected[jti]; !found || setErr.Err != "invalid_audience" {
+			t.Fatalf("SET %s rejected with %+v, want invalid_audience", jti, setErr)
+		}
+	}
+}
+
+func TestParseJwkEcPoint(t *testing.T) {
+	key := newTestSigningKey(t)
+	coordinate := func(value *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(value.FillBytes(make([]byte, 32)))
+	}
+	offCurve := new(big.Int).Add(key.Y, big.NewInt(1))
+	tooLarge := base64.RawURLEncoding.EncodeToString(append([]byte{1}, key.X.FillBytes(make([]byte, 32))...))
+
+	tests := []struct {
+		name    string
+		x       string
+		y       string
+		wantErr bool
+	}{
+		{"on the curve", coordinate(key.X), coordinate(key.Y), false},
+		{"not on the curve", coordinate(key.X), coordinate(offCurve), true},
+		{"point at infinity", coordinate(big.NewInt(0)), coordinate(big.NewInt(0)), true},
+		{"coordinate too large", tooLarge, coordinate(key.Y), true},
+		{"field modulus", coordinate(elliptic.P256().Params().P), coordinate(key.Y), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw := fmt.Sprintf(`{"kty":"EC","kid":"key-1","crv":"P-256","x":%q,"y":%q}`, test.x, test.y)
+			_, parsed, err := parseJwk(json.RawMessage(raw))
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("parseJwk() = %v, want an error", parsed)
+				}
+				return
+			}
+			if err != nil || parsed == nil {
+				t.Fatalf("parseJwk() = %v, %v, want a key", parsed, err)
+			}
+
+			_, err = ParseJwks([]byte(`{"keys":[` + raw + `]}`))
+			if err != nil {
+				t.Fatalf("ParseJwks() error = %v", err)
+			}
+		})
+	}
+
+	// A JWKS whose only key is malformed has no usable keys
+	raw := fmt.Sprintf(`{"keys":[{"kty":"EC","kid":"key-1","crv":"P-256","x":%q,"y":%q}]}`, coordinate(key.X), coordinate(offCurve))
+	if _, err := ParseJwks([]byte(raw)); err == nil {
+		t.Fatal("ParseJwks() accepted a key that isn't on the curve")
+	}
+}